@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
 
 	"github.com/Microkubes/microservice-tools/gateway"
 )
@@ -23,6 +24,9 @@ type ServiceConfig struct {
 	// GatewayAdminURL is the administration URL of the API Gateway. Used for purposes of registration of a
 	// microservice with the API gateway.
 	GatewayAdminURL string `json:"gatewayAdminUrl"`
+	// GatewayAdmin holds the authentication (RBAC token, workspace) and TLS configuration for the administration
+	// URL of the API Gateway. Optional, not needed for an unprotected administration URL.
+	GatewayAdmin *gateway.AdminConfig `json:"gatewayAdmin,omitempty"`
 	// ContainerManager is the platform for managing containerized services
 	// Can be swarm or kubernetes
 	ContainerManager string `json:"containerManager,omitempty"`
@@ -30,6 +34,22 @@ type ServiceConfig struct {
 	Version string `json:"version"`
 }
 
+// NewKongGateway creates a Kong Gateway registration for the service, with the GatewayAdminURL and the
+// GatewayAdmin configuration. If the service has no version label, the Version of the service is used.
+// The version is set on a copy of the service configuration, so the ServiceConfig is left unchanged.
+func (c *ServiceConfig) NewKongGateway() (*gateway.KongGateway, error) {
+	service := c.Service
+	if service != nil && service.Version == "" {
+		svc := *service
+		svc.Version = c.Version
+		service = &svc
+	}
+	if c.GatewayAdmin == nil {
+		return gateway.NewKongGateway(c.GatewayAdminURL, &http.Client{}, service), nil
+	}
+	return gateway.NewKongGatewayWithAdminConfig(c.GatewayAdminURL, c.GatewayAdmin, service)
+}
+
 // DBConfig holds the database configuration parameters.
 type DBConfig struct {
 	// DBname is the database name (mongodb/dynamodb)
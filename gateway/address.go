@@ -0,0 +1,279 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AddressResolver resolves the address (IP or host name) on which this instance of the microservice is
+// reachable by the gateway.
+type AddressResolver interface {
+	ResolveAddress() (string, error)
+}
+
+// AddressResolverFunc is a function that implements AddressResolver.
+type AddressResolverFunc func() (string, error)
+
+// ResolveAddress calls the function.
+func (f AddressResolverFunc) ResolveAddress() (string, error) {
+	return f()
+}
+
+// DefaultAddressResolver is used when no AddressResolver is set on the registration. It uses the POD_IP
+// environment variable (set by the Kubernetes downward API) if set, and GetServiceIP otherwise.
+var DefaultAddressResolver AddressResolver = FirstAddress(EnvAddress("POD_IP"), AddressResolverFunc(GetServiceIP))
+
+// DefaultDockerSocket is the path of the unix socket of the Docker Engine API.
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// StaticAddress returns an AddressResolver that always resolves to the given address.
+func StaticAddress(address string) AddressResolver {
+	return AddressResolverFunc(func() (string, error) {
+		return address, nil
+	})
+}
+
+// EnvAddress returns an AddressResolver that reads the address from the first set environment variable
+// from the given names. For example, EnvAddress("POD_IP") reads the pod IP exposed by the Kubernetes downward API:
+//
+//	env:
+//	- name: POD_IP
+//	  valueFrom:
+//	    fieldRef:
+//	      fieldPath: status.podIP
+func EnvAddress(names ...string) AddressResolver {
+	return AddressResolverFunc(func() (string, error) {
+		for _, name := range names {
+			if address := strings.TrimSpace(os.Getenv(name)); address != "" {
+				return address, nil
+			}
+		}
+		return "", fmt.Errorf("none of the environment variables %s is set", strings.Join(names, ", "))
+	})
+}
+
+// InterfaceAddress returns an AddressResolver that resolves to the IP of the network interface with the given
+// name (for example "eth1"). IPv4 addresses are preferred over IPv6 addresses.
+func InterfaceAddress(name string) AddressResolver {
+	return AddressResolverFunc(func() (string, error) {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return "", err
+		}
+		ips, err := interfaceIPs(*iface)
+		if err != nil {
+			return "", err
+		}
+		if ip := selectIP(ips); ip != nil {
+			return ip.String(), nil
+		}
+		return "", fmt.Errorf("no IP found on interface %s", name)
+	})
+}
+
+// CIDRAddress returns an AddressResolver that resolves to the first IP of the local network interfaces that is
+// in one of the given networks in CIDR notation (for example "10.0.1.0/24" or "fd00::/64").
+func CIDRAddress(cidrs ...string) AddressResolver {
+	return AddressResolverFunc(func() (string, error) {
+		networks := []*net.IPNet{}
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return "", err
+			}
+			networks = append(networks, network)
+		}
+
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return "", err
+		}
+		for _, iface := range ifaces {
+			ips, err := interfaceIPs(iface)
+			if err != nil {
+				return "", err
+			}
+			for _, ip := range ips {
+				for _, network := range networks {
+					if network.Contains(ip) {
+						return ip.String(), nil
+					}
+				}
+			}
+		}
+		return "", fmt.Errorf("no IP found in %s", strings.Join(cidrs, ", "))
+	})
+}
+
+// SwarmNetworkResolver resolves the address of the container on a Docker Swarm (overlay) network with the given
+// name. The subnets of the network are read from the Docker Engine API, so the Docker socket must be mounted
+// into the container.
+type SwarmNetworkResolver struct {
+	// Network is the name (or ID) of the Docker network.
+	Network string
+
+	// DockerURL is the URL of the Docker Engine API.
+	DockerURL string
+
+	// Client is the http.Client used to call the Docker Engine API.
+	Client *http.Client
+}
+
+// SwarmNetworkAddress creates a SwarmNetworkResolver for the network with the given name that calls the Docker
+// Engine API through DefaultDockerSocket.
+func SwarmNetworkAddress(network string) *SwarmNetworkResolver {
+	return &SwarmNetworkResolver{
+		Network:   network,
+		DockerURL: "http://docker",
+		Client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", DefaultDockerSocket)
+				},
+			},
+		},
+	}
+}
+
+// dockerNetwork is the network object of the Docker Engine API.
+type dockerNetwork struct {
+	IPAM struct {
+		Config []struct {
+			Subnet string `json:"Subnet"`
+		} `json:"Config"`
+	} `json:"IPAM"`
+}
+
+// ResolveAddress looks up the subnets of the network and resolves to the local IP in one of them.
+func (s *SwarmNetworkResolver) ResolveAddress() (string, error) {
+	resp, err := s.Client.Get(fmt.Sprintf("%s/networks/%s", s.DockerURL, url.PathEscape(s.Network)))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to inspect Docker network %s: %s", s.Network, resp.Status)
+	}
+
+	network := &dockerNetwork{}
+	if err := json.NewDecoder(resp.Body).Decode(network); err != nil {
+		return "", err
+	}
+	subnets := []string{}
+	for _, config := range network.IPAM.Config {
+		if config.Subnet != "" {
+			subnets = append(subnets, config.Subnet)
+		}
+	}
+	if len(subnets) == 0 {
+		return "", fmt.Errorf("Docker network %s has no subnets", s.Network)
+	}
+	return CIDRAddress(subnets...).ResolveAddress()
+}
+
+// FirstAddress returns an AddressResolver that tries the given resolvers in order, and resolves to the address
+// from the first one that succeeds.
+func FirstAddress(resolvers ...AddressResolver) AddressResolver {
+	return AddressResolverFunc(func() (string, error) {
+		messages := []string{}
+		for _, resolver := range resolvers {
+			address, err := resolver.ResolveAddress()
+			if err == nil && address != "" {
+				return address, nil
+			}
+			if err != nil {
+				messages = append(messages, err.Error())
+			}
+		}
+		return "", fmt.Errorf("failed to resolve the service address: %s", strings.Join(messages, "; "))
+	})
+}
+
+// GetServiceIP returns the valid IP of the microservice container.
+// This is the first non-loopback IPv4 address of the network interfaces that are up, or the first global
+// IPv6 address if the container has no IPv4 address.
+func GetServiceIP() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	ips := []net.IP{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ifaceIPs, err := interfaceIPs(iface)
+		if err != nil {
+			return "", err
+		}
+		ips = append(ips, ifaceIPs...)
+	}
+
+	if ip := selectIP(ips); ip != nil {
+		return ip.String(), nil
+	}
+	return "", errors.New("IP not found")
+}
+
+// resolveAddress returns the address of this instance: the AdvertiseAddress from the config if set, otherwise
+// the address resolved with the resolver, or with DefaultAddressResolver if the resolver is nil.
+func resolveAddress(config *MicroserviceConfig, resolver AddressResolver) (string, error) {
+	if config.AdvertiseAddress != "" {
+		return config.AdvertiseAddress, nil
+	}
+	if resolver == nil {
+		resolver = DefaultAddressResolver
+	}
+	return resolver.ResolveAddress()
+}
+
+// interfaceIPs returns the IPs of the network interface.
+func interfaceIPs(iface net.Interface) ([]net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the addresses of interface %s: %s", iface.Name, err.Error())
+	}
+	ips := []net.IP{}
+	for _, addr := range addrs {
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ips = append(ips, v.IP)
+		case *net.IPAddr:
+			ips = append(ips, v.IP)
+		}
+	}
+	return ips, nil
+}
+
+// selectIP returns the first non-loopback IPv4 address, or the first global unicast IPv6 address if there are
+// no IPv4 addresses. Returns nil if there is no such address.
+func selectIP(ips []net.IP) net.IP {
+	var ipv6 net.IP
+	for _, ip := range ips {
+		if ip.IsLoopback() || !ip.IsGlobalUnicast() {
+			continue
+		}
+		if ip.To4() != nil {
+			return ip
+		}
+		if ipv6 == nil {
+			ipv6 = ip
+		}
+	}
+	return ipv6
+}
+
+// joinHostPort joins the host (IPv4, IPv6 or a host name) and the port into an address.
+func joinHostPort(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestEnvAddress(t *testing.T) {
+	os.Setenv("TEST_POD_IP", "10.1.2.3")
+	defer os.Unsetenv("TEST_POD_IP")
+
+	address, err := EnvAddress("TEST_UNSET_IP", "TEST_POD_IP").ResolveAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "10.1.2.3" {
+		t.Fatalf("Expected 10.1.2.3, got %s", address)
+	}
+
+	if _, err := EnvAddress("TEST_UNSET_IP").ResolveAddress(); err == nil {
+		t.Fatal("Expected an error when the environment variable is not set")
+	}
+}
+
+func TestFirstAddress(t *testing.T) {
+	failing := AddressResolverFunc(func() (string, error) {
+		return "", fmt.Errorf("not available")
+	})
+
+	address, err := FirstAddress(failing, StaticAddress("fd00::5"), StaticAddress("10.0.0.5")).ResolveAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "fd00::5" {
+		t.Fatalf("Expected fd00::5, got %s", address)
+	}
+
+	if _, err := FirstAddress(failing).ResolveAddress(); err == nil {
+		t.Fatal("Expected an error when no resolver succeeds")
+	}
+}
+
+func TestCIDRAddress(t *testing.T) {
+	address, err := CIDRAddress("198.51.100.0/24", "127.0.0.0/8").ResolveAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "127.0.0.1" {
+		t.Fatalf("Expected 127.0.0.1, got %s", address)
+	}
+
+	if _, err := CIDRAddress("198.51.100.0/24").ResolveAddress(); err == nil {
+		t.Fatal("Expected an error when no interface is in the network")
+	}
+	if _, err := CIDRAddress("invalid").ResolveAddress(); err == nil {
+		t.Fatal("Expected an error for an invalid CIDR")
+	}
+}
+
+func TestSwarmNetworkAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/networks/microservices" {
+			http.Error(rw, "network not found", http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(`{"Name":"microservices","IPAM":{"Config":[{"Subnet":"127.0.0.0/8"}]}}`))
+	}))
+	defer server.Close()
+
+	resolver := SwarmNetworkAddress("microservices")
+	resolver.DockerURL = server.URL
+	resolver.Client = &http.Client{}
+
+	address, err := resolver.ResolveAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "127.0.0.1" {
+		t.Fatalf("Expected 127.0.0.1, got %s", address)
+	}
+
+	resolver.Network = "unknown"
+	if _, err := resolver.ResolveAddress(); err == nil {
+		t.Fatal("Expected an error for an unknown network")
+	}
+}
+
+func TestSelectIP(t *testing.T) {
+	ips := []net.IP{net.ParseIP("::1"), net.ParseIP("fe80::1"), net.ParseIP("2001:db8::5"), net.ParseIP("10.0.0.5")}
+	if ip := selectIP(ips); ip.String() != "10.0.0.5" {
+		t.Fatalf("Expected the IPv4 address to be preferred, got %s", ip)
+	}
+	if ip := selectIP(ips[:3]); ip.String() != "2001:db8::5" {
+		t.Fatalf("Expected the global IPv6 address, got %s", ip)
+	}
+	if ip := selectIP([]net.IP{net.ParseIP("127.0.0.1")}); ip != nil {
+		t.Fatalf("Expected no address, got %s", ip)
+	}
+}
+
+func TestKongSelfTargetIPv6(t *testing.T) {
+	config := &MicroserviceConfig{MicroservicePort: 8080}
+	gateway := NewKongGateway("http://kong:8001", &http.Client{}, config)
+	gateway.AddressResolver = StaticAddress("2001:db8::5")
+
+	self, err := gateway.selfTarget(8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self != "[2001:db8::5]:8080" {
+		t.Fatalf("Expected [2001:db8::5]:8080, got %s", self)
+	}
+
+	config.AdvertiseAddress = "10.0.0.5"
+	if self, _ = gateway.selfTarget(8080); self != "10.0.0.5:8080" {
+		t.Fatalf("Expected the advertise address to take precedence, got %s", self)
+	}
+}
@@ -0,0 +1,207 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ConsulRegistration registers this instance of the microservice in the Consul service catalog through
+// the local Consul agent.
+// The instance is registered with tags built from Hosts ("host=<host>") and Paths ("path=<path>"), and
+// with an HTTP check of the healthcheck endpoint of the instance (over HTTPS if UpstreamTLS is configured).
+type ConsulRegistration struct {
+	// ConsulURL is the URL of the Consul agent, for example http://localhost:8500.
+	ConsulURL string
+
+	// InstanceAddress is the address (host or IP) of this instance. If empty, the address is resolved
+	// with AddressResolver.
+	InstanceAddress string
+
+	// AddressResolver resolves the address of this instance. If nil, DefaultAddressResolver is used.
+	AddressResolver AddressResolver
+
+	// Tags is a list of additional tags for the service instance.
+	Tags []string
+
+	// CheckInterval is the interval of the HTTP health check. Defaults to 10 seconds.
+	CheckInterval time.Duration
+
+	// CheckTimeout is the timeout of the HTTP health check. Defaults to 5 seconds.
+	CheckTimeout time.Duration
+
+	// DeregisterCriticalAfter is the time after which Consul deregisters an instance that fails the health
+	// check (for example a crashed container). Zero disables the automatic deregistration.
+	DeregisterCriticalAfter time.Duration
+
+	config *MicroserviceConfig
+	client *http.Client
+}
+
+// NewConsulRegistration creates a Consul Registration with the given URL of the Consul agent, an http.Client
+// and a MicroserviceConfig.
+func NewConsulRegistration(consulURL string, client *http.Client, config *MicroserviceConfig) *ConsulRegistration {
+	return &ConsulRegistration{
+		ConsulURL:     consulURL,
+		CheckInterval: 10 * time.Second,
+		CheckTimeout:  5 * time.Second,
+		config:        config,
+		client:        client,
+	}
+}
+
+// consulService is the Consul agent service registration.
+// See https://developer.hashicorp.com/consul/api-docs/agent/service#register-service
+type consulService struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Name"`
+	Address string            `json:"Address"`
+	Port    int               `json:"Port"`
+	Tags    []string          `json:"Tags,omitempty"`
+	Meta    map[string]string `json:"Meta,omitempty"`
+	Weights *consulWeights    `json:"Weights,omitempty"`
+	Check   *consulCheck      `json:"Check,omitempty"`
+}
+
+type consulWeights struct {
+	Passing int `json:"Passing"`
+	Warning int `json:"Warning"`
+}
+
+type consulCheck struct {
+	HTTP                           string `json:"HTTP"`
+	TLSServerName                  string `json:"TLSServerName,omitempty"`
+	TLSSkipVerify                  bool   `json:"TLSSkipVerify,omitempty"`
+	Interval                       string `json:"Interval"`
+	Timeout                        string `json:"Timeout,omitempty"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter,omitempty"`
+}
+
+// SelfRegister registers this instance with the Consul agent.
+func (c *ConsulRegistration) SelfRegister() error {
+	service, err := c.serviceRegistration()
+	if err != nil {
+		return err
+	}
+	return c.put("v1/agent/service/register", service)
+}
+
+// Unregister deregisters this instance from the Consul agent.
+func (c *ConsulRegistration) Unregister() error {
+	address, err := c.instanceAddress()
+	if err != nil {
+		return err
+	}
+	return c.put(fmt.Sprintf("v1/agent/service/deregister/%s", c.serviceID(address)), nil)
+}
+
+// serviceRegistration maps the microservice configuration onto a Consul service registration.
+func (c *ConsulRegistration) serviceRegistration() (*consulService, error) {
+	address, err := c.instanceAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, host := range c.config.Hosts {
+		tags = append(tags, fmt.Sprintf("host=%s", host))
+	}
+	for _, path := range c.config.Paths {
+		tags = append(tags, fmt.Sprintf("path=%s", path))
+	}
+	tags = append(tags, c.Tags...)
+
+	weight := c.config.Weight
+	if weight <= 0 {
+		weight = DefaultTargetWeight
+	}
+
+	scheme := "http"
+	check := &consulCheck{
+		Interval: c.CheckInterval.String(),
+	}
+	if tlsConfig := c.config.UpstreamTLS; tlsConfig != nil {
+		// The microservice serves HTTPS only. Consul verifies its certificate only if the gateway does,
+		// since the CA of the microservice is usually not known to the Consul agent.
+		scheme = "https"
+		check.TLSServerName = tlsConfig.SNI
+		check.TLSSkipVerify = !tlsConfig.verifies()
+	}
+	check.HTTP = fmt.Sprintf("%s://%s%s", scheme, joinHostPort(address, c.config.MicroservicePort), c.healthCheckPath())
+	if c.CheckTimeout > 0 {
+		check.Timeout = c.CheckTimeout.String()
+	}
+	if c.DeregisterCriticalAfter > 0 {
+		check.DeregisterCriticalServiceAfter = c.DeregisterCriticalAfter.String()
+	}
+
+	return &consulService{
+		ID:      c.serviceID(address),
+		Name:    c.config.MicroserviceName,
+		Address: address,
+		Port:    c.config.MicroservicePort,
+		Tags:    tags,
+		Meta: map[string]string{
+			"weight": strconv.Itoa(weight),
+		},
+		Weights: &consulWeights{
+			Passing: weight,
+			Warning: 1,
+		},
+		Check: check,
+	}, nil
+}
+
+// healthCheckPath returns the path of the healthcheck endpoint: the active health check path if configured,
+// or DefaultHealthCheckPath.
+func (c *ConsulRegistration) healthCheckPath() string {
+	if healthChecks := c.config.HealthChecks; healthChecks != nil && healthChecks.Active != nil && healthChecks.Active.HTTPPath != "" {
+		return healthChecks.Active.HTTPPath
+	}
+	return DefaultHealthCheckPath
+}
+
+// serviceID returns the ID of this service instance in the Consul catalog.
+func (c *ConsulRegistration) serviceID(address string) string {
+	return sanitizeName(fmt.Sprintf("%s-%s-%d", c.config.MicroserviceName, address, c.config.MicroservicePort))
+}
+
+// instanceAddress returns the address of this instance.
+func (c *ConsulRegistration) instanceAddress() (string, error) {
+	if c.InstanceAddress != "" {
+		return c.InstanceAddress, nil
+	}
+	return resolveAddress(c.config, c.AddressResolver)
+}
+
+// put sends a PUT request with the body encoded as JSON to the Consul agent.
+func (c *ConsulRegistration) put(path string, body interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/%s", c.ConsulURL, path), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type consulAgentStub struct {
+	services map[string]*consulService
+}
+
+func (c *consulAgentStub) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case req.URL.Path == "/v1/agent/service/register":
+		service := &consulService{}
+		if err := json.NewDecoder(req.Body).Decode(service); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.services[service.ID] = service
+	case strings.HasPrefix(req.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(req.URL.Path, "/v1/agent/service/deregister/")
+		if _, ok := c.services[id]; !ok {
+			http.Error(rw, "Unknown service ID", http.StatusNotFound)
+			return
+		}
+		delete(c.services, id)
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestConsulRegistration(t *testing.T) {
+	agent := &consulAgentStub{services: map[string]*consulService{}}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	config := &MicroserviceConfig{
+		MicroserviceName: "user-microservice",
+		MicroservicePort: 8080,
+		Hosts:            []string{"user.api.jormugandr.org"},
+		Paths:            []string{"/users"},
+		Weight:           10,
+	}
+	registration := NewConsulRegistration(server.URL, &http.Client{}, config)
+	registration.InstanceAddress = "10.0.0.5"
+	registration.Tags = []string{"v1"}
+	registration.DeregisterCriticalAfter = time.Minute
+
+	if err := registration.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	service, ok := agent.services["user-microservice-10-0-0-5-8080"]
+	if !ok {
+		t.Fatalf("Expected the instance to be registered, got %v", agent.services)
+	}
+	if service.Name != "user-microservice" || service.Address != "10.0.0.5" || service.Port != 8080 {
+		t.Fatalf("Unexpected service registration: %+v", service)
+	}
+	if strings.Join(service.Tags, ",") != "host=user.api.jormugandr.org,path=/users,v1" {
+		t.Fatalf("Unexpected tags: %v", service.Tags)
+	}
+	if service.Weights.Passing != 10 {
+		t.Fatalf("Expected passing weight 10, got %d", service.Weights.Passing)
+	}
+	if service.Check.HTTP != "http://10.0.0.5:8080/healthcheck" || service.Check.Interval != "10s" ||
+		service.Check.DeregisterCriticalServiceAfter != "1m0s" {
+		t.Fatalf("Unexpected health check: %+v", service.Check)
+	}
+
+	if err := registration.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if len(agent.services) != 0 {
+		t.Fatal("Expected the instance to be deregistered")
+	}
+
+	if err := registration.Unregister(); err == nil {
+		t.Fatal("Expected an error when deregistering an unknown instance")
+	}
+}
+
+func TestConsulRegistrationUpstreamTLS(t *testing.T) {
+	config := &MicroserviceConfig{
+		MicroserviceName: "user-microservice",
+		MicroservicePort: 8443,
+		UpstreamTLS:      &UpstreamTLSConfig{SNI: "users.internal"},
+	}
+	registration := NewConsulRegistration("http://consul:8500", &http.Client{}, config)
+	registration.InstanceAddress = "10.0.0.5"
+
+	service, err := registration.serviceRegistration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.Check.HTTP != "https://10.0.0.5:8443/healthcheck" || service.Check.TLSServerName != "users.internal" || !service.Check.TLSSkipVerify {
+		t.Fatalf("Expected an HTTPS check without the verification, got %+v", service.Check)
+	}
+
+	config.UpstreamTLS.Verify = boolPtr(true)
+	if service, err = registration.serviceRegistration(); err != nil || service.Check.TLSSkipVerify {
+		t.Fatalf("Expected the certificate to be verified, got %+v (%v)", service.Check, err)
+	}
+}
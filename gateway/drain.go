@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Drainable is a Registration that can stop the API Gateway from sending new requests to this instance
+// before the instance is unregistered.
+type Drainable interface {
+	Registration
+
+	// Drain stops the API Gateway from sending new requests to this instance, without unregistering it.
+	Drain(ctx context.Context) error
+}
+
+// DrainAndUnregister gracefully removes this instance from the API Gateway.
+// If the registration is Drainable, the instance is first drained, then the drain period is waited
+// for the in-flight requests to complete, and then the instance is unregistered.
+// If the drain fails, the instance is still unregistered after the drain period and the drain error is returned.
+// Otherwise, the instance is unregistered first and then the drain period is waited.
+// The wait is interrupted if the context is done.
+func DrainAndUnregister(ctx context.Context, registration Registration, drainPeriod time.Duration) error {
+	drainable, ok := registration.(Drainable)
+	if !ok {
+		if err := unregister(ctx, registration); err != nil {
+			return err
+		}
+		return wait(ctx, drainPeriod)
+	}
+
+	drainErr := drainable.Drain(ctx)
+	if err := wait(ctx, drainPeriod); err != nil {
+		return err
+	}
+	err := unregister(ctx, registration)
+	if drainErr == nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("drain failed: %s; unregister: %s", drainErr.Error(), err.Error())
+	}
+	return fmt.Errorf("drain failed: %s", drainErr.Error())
+}
+
+// UnregisterOnSignal blocks until one of the given OS signals is received, then gracefully removes this instance
+// from the API Gateway (see DrainAndUnregister) and returns. If no signals are given, it waits for SIGINT or SIGTERM.
+// If the context is done before a signal is received, it returns the context error without unregistering.
+//
+// Use it instead of deferring Unregister, which does not run when the container is stopped:
+//
+//	go func() {
+//		gateway.UnregisterOnSignal(ctx, registration, 10*time.Second)
+//		server.Shutdown(context.Background())
+//	}()
+//
+// A Reconciler for the same registration should be stopped once the signal is received, otherwise it re-registers
+// the drained instance.
+func UnregisterOnSignal(ctx context.Context, registration Registration, drainPeriod time.Duration, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-received:
+	}
+
+	return DrainAndUnregister(context.Background(), registration, drainPeriod)
+}
+
+// unregister unregisters with the context, if the registration supports it.
+func unregister(ctx context.Context, registration Registration) error {
+	if contextRegistration, ok := registration.(ContextRegistration); ok {
+		return contextRegistration.UnregisterContext(ctx)
+	}
+	return registration.Unregister()
+}
+
+// wait waits for the given period, or until the context is done.
+func wait(ctx context.Context, period time.Duration) error {
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+type drainableRegistration struct {
+	calls    []string
+	drainErr error
+}
+
+func (d *drainableRegistration) SelfRegister() error {
+	d.calls = append(d.calls, "register")
+	return nil
+}
+
+func (d *drainableRegistration) Unregister() error {
+	d.calls = append(d.calls, "unregister")
+	return nil
+}
+
+func (d *drainableRegistration) Drain(ctx context.Context) error {
+	d.calls = append(d.calls, "drain")
+	return d.drainErr
+}
+
+func TestDrainAndUnregister(t *testing.T) {
+	registration := &drainableRegistration{}
+
+	start := time.Now()
+	if err := DrainAndUnregister(context.Background(), registration, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("Expected to wait for the drain period")
+	}
+	if strings.Join(registration.calls, ",") != "drain,unregister" {
+		t.Fatalf("Expected drain and then unregister, got %v", registration.calls)
+	}
+}
+
+func TestDrainAndUnregisterNotDrainable(t *testing.T) {
+	registration := &countingRegistration{}
+
+	if err := DrainAndUnregister(context.Background(), registration, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainAndUnregisterDrainFails(t *testing.T) {
+	registration := &drainableRegistration{drainErr: errors.New("kong unavailable")}
+
+	err := DrainAndUnregister(context.Background(), registration, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "kong unavailable") {
+		t.Fatalf("Expected the drain error, got %v", err)
+	}
+	if strings.Join(registration.calls, ",") != "drain,unregister" {
+		t.Fatalf("Expected the instance to be unregistered after the failed drain, got %v", registration.calls)
+	}
+}
+
+func TestDrainAndUnregisterCancelled(t *testing.T) {
+	registration := &drainableRegistration{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := DrainAndUnregister(ctx, registration, time.Minute); err != context.Canceled {
+		t.Fatalf("Expected the drain to be cancelled, got %v", err)
+	}
+	if strings.Join(registration.calls, ",") != "drain" {
+		t.Fatalf("Expected drain only, got %v", registration.calls)
+	}
+}
+
+func TestUnregisterOnSignal(t *testing.T) {
+	registration := &drainableRegistration{}
+
+	done := make(chan error)
+	go func() {
+		done <- UnregisterOnSignal(context.Background(), registration, time.Millisecond, syscall.SIGUSR1)
+	}()
+
+	// give the goroutine time to install the signal handler
+	time.Sleep(20 * time.Millisecond)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected to unregister after the signal")
+	}
+	if strings.Join(registration.calls, ",") != "drain,unregister" {
+		t.Fatalf("Expected drain and then unregister, got %v", registration.calls)
+	}
+}
+
+func TestKongDrainSetsTargetWeightToZero(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Patch("/upstreams/user.api.jormugandr.org/targets/.+:8080").
+		SetMatcher(NewJSONMatcher(t).
+			Field("weight", 0).
+			Matcher).
+		Reply(200).
+		JSON(map[string]interface{}{"weight": 0})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, newServicesModeConfig())
+
+	if err := gateway.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if gock.IsPending() {
+		t.Fatal("Expected the target to be updated")
+	}
+}
@@ -0,0 +1,586 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EnvoyRegistration registers the microservice with Envoy through the file based dynamic configuration.
+//
+// Every instance writes its own file with its address and the configuration of the microservice into the config
+// directory. The listener (lds.yaml) and the clusters (cds.yaml) are regenerated from all instance files in the
+// directory, for all microservices, on every registration and unregistration. The files are written atomically,
+// so Envoy picks up the complete configuration when it is moved into place. Use DynamicBootstrap to generate
+// the Envoy bootstrap configuration that points to the files.
+type EnvoyRegistration struct {
+	// ConfigDir is the directory with the Envoy dynamic configuration files and the instance files.
+	ConfigDir string
+
+	// Listener is the Envoy listener that proxies the requests to the microservices.
+	Listener EnvoyListener
+
+	// InstanceAddress is the address (host or IP) of this instance. If empty, the address is resolved
+	// with AddressResolver.
+	InstanceAddress string
+
+	// AddressResolver resolves the address of this instance. If nil, DefaultAddressResolver is used.
+	AddressResolver AddressResolver
+
+	config *MicroserviceConfig
+}
+
+// EnvoyListener is the configuration of the Envoy listener.
+type EnvoyListener struct {
+	// Name is the name of the listener and its route configuration. Defaults to "ingress".
+	Name string `json:"name,omitempty"`
+
+	// Address is the address on which the listener accepts the connections. Defaults to "0.0.0.0".
+	Address string `json:"address,omitempty"`
+
+	// Port is the port of the listener. Defaults to 10000.
+	Port int `json:"port,omitempty"`
+}
+
+// EnvoyInstance is a registered instance of a microservice.
+type EnvoyInstance struct {
+	// Address is the address (host or IP) of the instance.
+	Address string `json:"address"`
+
+	// Config is the configuration of the microservice. The port of the instance is the MicroservicePort.
+	Config *MicroserviceConfig `json:"config"`
+}
+
+const (
+	// EnvoyListenersFile is the name of the file with the Envoy listeners (LDS) in the config directory.
+	EnvoyListenersFile = "lds.yaml"
+
+	// EnvoyClustersFile is the name of the file with the Envoy clusters (CDS) in the config directory.
+	EnvoyClustersFile = "cds.yaml"
+)
+
+// NewEnvoyRegistration creates an Envoy Registration that writes the dynamic configuration into the given directory.
+func NewEnvoyRegistration(configDir string, config *MicroserviceConfig) *EnvoyRegistration {
+	return &EnvoyRegistration{
+		ConfigDir: configDir,
+		config:    config,
+	}
+}
+
+type envoyResources struct {
+	Resources []interface{} `yaml:"resources"`
+}
+
+type envoyListener struct {
+	Type         string             `yaml:"@type,omitempty"`
+	Name         string             `yaml:"name"`
+	Address      envoyAddress       `yaml:"address"`
+	FilterChains []envoyFilterChain `yaml:"filter_chains"`
+}
+
+type envoyAddress struct {
+	SocketAddress envoySocketAddress `yaml:"socket_address"`
+}
+
+type envoySocketAddress struct {
+	Address   string `yaml:"address"`
+	PortValue int    `yaml:"port_value"`
+}
+
+type envoyFilterChain struct {
+	Filters []envoyFilter `yaml:"filters"`
+}
+
+type envoyFilter struct {
+	Name        string      `yaml:"name"`
+	TypedConfig interface{} `yaml:"typed_config"`
+}
+
+type envoyTypedConfig struct {
+	Type string `yaml:"@type"`
+}
+
+type envoyConnectionManager struct {
+	Type        string           `yaml:"@type"`
+	StatPrefix  string           `yaml:"stat_prefix"`
+	RouteConfig envoyRouteConfig `yaml:"route_config"`
+	HTTPFilters []envoyFilter    `yaml:"http_filters"`
+}
+
+type envoyRouteConfig struct {
+	Name         string             `yaml:"name"`
+	VirtualHosts []envoyVirtualHost `yaml:"virtual_hosts"`
+}
+
+type envoyVirtualHost struct {
+	Name    string       `yaml:"name"`
+	Domains []string     `yaml:"domains"`
+	Routes  []envoyRoute `yaml:"routes"`
+}
+
+type envoyRoute struct {
+	Name  string           `yaml:"name"`
+	Match envoyRouteMatch  `yaml:"match"`
+	Route envoyRouteAction `yaml:"route"`
+}
+
+type envoyRouteMatch struct {
+	Prefix  string               `yaml:"prefix"`
+	Headers []envoyHeaderMatcher `yaml:"headers,omitempty"`
+}
+
+type envoyHeaderMatcher struct {
+	Name        string             `yaml:"name"`
+	StringMatch envoyStringMatcher `yaml:"string_match"`
+}
+
+type envoyStringMatcher struct {
+	SafeRegex envoyRegex `yaml:"safe_regex"`
+}
+
+type envoyRegex struct {
+	Regex string `yaml:"regex"`
+}
+
+type envoyRouteAction struct {
+	Cluster          string                 `yaml:"cluster,omitempty"`
+	WeightedClusters *envoyWeightedClusters `yaml:"weighted_clusters,omitempty"`
+	RegexRewrite     *envoyRegexRewrite     `yaml:"regex_rewrite,omitempty"`
+}
+
+type envoyRegexRewrite struct {
+	Pattern      envoyRegex `yaml:"pattern"`
+	Substitution string     `yaml:"substitution"`
+}
+
+type envoyWeightedClusters struct {
+	Clusters []envoyClusterWeight `yaml:"clusters"`
+}
+
+type envoyClusterWeight struct {
+	Name   string `yaml:"name"`
+	Weight int    `yaml:"weight"`
+}
+
+type envoyCluster struct {
+	Type            string                `yaml:"@type,omitempty"`
+	Name            string                `yaml:"name"`
+	DiscoveryType   string                `yaml:"type"`
+	ConnectTimeout  string                `yaml:"connect_timeout"`
+	LBPolicy        string                `yaml:"lb_policy"`
+	LoadAssignment  envoyLoadAssignment   `yaml:"load_assignment"`
+	TransportSocket *envoyTransportSocket `yaml:"transport_socket,omitempty"`
+}
+
+type envoyLoadAssignment struct {
+	ClusterName string                   `yaml:"cluster_name"`
+	Endpoints   []envoyLocalityEndpoints `yaml:"endpoints"`
+}
+
+type envoyLocalityEndpoints struct {
+	LBEndpoints []envoyLBEndpoint `yaml:"lb_endpoints"`
+}
+
+type envoyLBEndpoint struct {
+	Endpoint            envoyEndpoint `yaml:"endpoint"`
+	LoadBalancingWeight int           `yaml:"load_balancing_weight"`
+}
+
+type envoyEndpoint struct {
+	Address envoyAddress `yaml:"address"`
+}
+
+type envoyTransportSocket struct {
+	Name        string                  `yaml:"name"`
+	TypedConfig envoyUpstreamTLSContext `yaml:"typed_config"`
+}
+
+type envoyUpstreamTLSContext struct {
+	Type             string                `yaml:"@type"`
+	SNI              string                `yaml:"sni,omitempty"`
+	CommonTLSContext envoyCommonTLSContext `yaml:"common_tls_context"`
+}
+
+type envoyCommonTLSContext struct {
+	TLSCertificates   []envoyTLSCertificate   `yaml:"tls_certificates,omitempty"`
+	ValidationContext *envoyValidationContext `yaml:"validation_context,omitempty"`
+}
+
+type envoyTLSCertificate struct {
+	CertificateChain envoyDataSource `yaml:"certificate_chain"`
+	PrivateKey       envoyDataSource `yaml:"private_key"`
+}
+
+type envoyValidationContext struct {
+	TrustedCA envoyDataSource `yaml:"trusted_ca"`
+}
+
+type envoyDataSource struct {
+	Filename string `yaml:"filename"`
+}
+
+const (
+	envoyListenerType          = "type.googleapis.com/envoy.config.listener.v3.Listener"
+	envoyClusterType           = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+	envoyConnectionManagerType = "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager"
+	envoyRouterType            = "type.googleapis.com/envoy.extensions.filters.http.router.v3.Router"
+	envoyUpstreamTLSType       = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext"
+)
+
+// SelfRegister writes the file for this instance and regenerates the Envoy listeners and clusters.
+func (e *EnvoyRegistration) SelfRegister() error {
+	address, err := e.instanceAddress()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(&EnvoyInstance{Address: address, Config: e.config}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(e.instanceFile(address), data); err != nil {
+		return err
+	}
+	return e.writeResources()
+}
+
+// Unregister removes the file for this instance and regenerates the Envoy listeners and clusters.
+func (e *EnvoyRegistration) Unregister() error {
+	address, err := e.instanceAddress()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(e.instanceFile(address)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return e.writeResources()
+}
+
+// DynamicBootstrap returns the Envoy bootstrap configuration that loads the listeners and the clusters
+// from the files in the config directory. The node ID identifies the Envoy instance.
+func (e *EnvoyRegistration) DynamicBootstrap(nodeID string) ([]byte, error) {
+	configSource := func(file string) map[string]interface{} {
+		return map[string]interface{}{
+			"path_config_source":   map[string]interface{}{"path": filepath.Join(e.ConfigDir, file)},
+			"resource_api_version": "V3",
+		}
+	}
+	return yaml.Marshal(map[string]interface{}{
+		"node": map[string]interface{}{"id": nodeID, "cluster": e.Listener.name()},
+		"dynamic_resources": map[string]interface{}{
+			"lds_config": configSource(EnvoyListenersFile),
+			"cds_config": configSource(EnvoyClustersFile),
+		},
+	})
+}
+
+// writeResources renders the listeners and the clusters from the instance files found in the config directory.
+// The clusters are written first, so the routes never point to a missing cluster.
+func (e *EnvoyRegistration) writeResources() error {
+	instances, err := e.instances()
+	if err != nil {
+		return err
+	}
+	listeners, clusters, err := RenderEnvoyResources(e.Listener, instances)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(e.ConfigDir, EnvoyClustersFile), clusters); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(e.ConfigDir, EnvoyListenersFile), listeners)
+}
+
+// instances reads all instance files in the config directory.
+func (e *EnvoyRegistration) instances() ([]EnvoyInstance, error) {
+	files, err := filepath.Glob(filepath.Join(e.ConfigDir, "*.instance.*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	instances := []EnvoyInstance{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		instance := EnvoyInstance{}
+		if err := json.Unmarshal(data, &instance); err != nil {
+			return nil, fmt.Errorf("invalid instance file %s: %s", file, err.Error())
+		}
+		if instance.Config != nil {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+// instanceAddress returns the address of this instance.
+func (e *EnvoyRegistration) instanceAddress() (string, error) {
+	if e.InstanceAddress != "" {
+		return e.InstanceAddress, nil
+	}
+	return resolveAddress(e.config, e.AddressResolver)
+}
+
+// instanceFile returns the path of the file for the instance with the given address.
+func (e *EnvoyRegistration) instanceFile(address string) string {
+	return filepath.Join(e.ConfigDir, fmt.Sprintf("%s.instance.%s.json", sanitizeName(e.config.MicroserviceName), sanitizeName(fmt.Sprintf("%s_%d", address, e.config.MicroservicePort))))
+}
+
+// RenderEnvoyResources renders the registered instances into the Envoy listeners (LDS) and clusters (CDS)
+// discovery files. Every microservice gets a cluster per version (see MicroserviceConfig.Version) with an
+// endpoint per instance, weighted by the instance Weight. The virtual hosts are built from the Hosts, and the
+// routes from the Paths and Methods of the microservices. When a microservice has multiple versions, its routes
+// split the requests between the version clusters by the total weight of their instances.
+// The routes of a microservice are taken from its first instance.
+func RenderEnvoyResources(listener EnvoyListener, instances []EnvoyInstance) ([]byte, []byte, error) {
+	rendered, clusters, err := renderEnvoy(listener, instances)
+	if err != nil {
+		return nil, nil, err
+	}
+	rendered.Type = envoyListenerType
+	listeners := envoyResources{Resources: []interface{}{rendered}}
+	clusterResources := envoyResources{Resources: []interface{}{}}
+	for _, cluster := range clusters {
+		cluster.Type = envoyClusterType
+		clusterResources.Resources = append(clusterResources.Resources, cluster)
+	}
+	listenersData, err := yaml.Marshal(listeners)
+	if err != nil {
+		return nil, nil, err
+	}
+	clustersData, err := yaml.Marshal(clusterResources)
+	if err != nil {
+		return nil, nil, err
+	}
+	return listenersData, clustersData, nil
+}
+
+// RenderEnvoyStaticBootstrap renders the registered instances into a static Envoy bootstrap configuration,
+// with the same listener and clusters as RenderEnvoyResources.
+func RenderEnvoyStaticBootstrap(listener EnvoyListener, instances []EnvoyInstance) ([]byte, error) {
+	rendered, clusters, err := renderEnvoy(listener, instances)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(map[string]interface{}{
+		"static_resources": map[string]interface{}{
+			"listeners": []*envoyListener{rendered},
+			"clusters":  clusters,
+		},
+	})
+}
+
+// envoyService holds the instances of a microservice, grouped into clusters by version.
+type envoyService struct {
+	config   *MicroserviceConfig
+	clusters map[string]*envoyCluster
+	weights  map[string]int
+}
+
+func renderEnvoy(listener EnvoyListener, instances []EnvoyInstance) (*envoyListener, []*envoyCluster, error) {
+	services := map[string]*envoyService{}
+	names := []string{}
+	for _, instance := range instances {
+		config := instance.Config
+		if config.MicroserviceName == "" {
+			return nil, nil, fmt.Errorf("instance %s without a microservice name", instance.Address)
+		}
+		service, ok := services[config.MicroserviceName]
+		if !ok {
+			service = &envoyService{config: config, clusters: map[string]*envoyCluster{}, weights: map[string]int{}}
+			services[config.MicroserviceName] = service
+			names = append(names, config.MicroserviceName)
+		}
+		clusterName := sanitizeName(config.MicroserviceName)
+		if config.Version != "" {
+			clusterName = sanitizeName(fmt.Sprintf("%s-%s", config.MicroserviceName, config.Version))
+		}
+		cluster, ok := service.clusters[clusterName]
+		if !ok {
+			cluster = newEnvoyCluster(clusterName, config)
+			service.clusters[clusterName] = cluster
+		}
+		if net.ParseIP(instance.Address) == nil {
+			cluster.DiscoveryType = "STRICT_DNS"
+		}
+		weight := config.Weight
+		if weight <= 0 {
+			weight = DefaultTargetWeight
+		}
+		endpoints := &cluster.LoadAssignment.Endpoints[0]
+		endpoints.LBEndpoints = append(endpoints.LBEndpoints, envoyLBEndpoint{
+			Endpoint: envoyEndpoint{Address: envoyAddress{SocketAddress: envoySocketAddress{
+				Address:   instance.Address,
+				PortValue: config.MicroservicePort,
+			}}},
+			LoadBalancingWeight: weight,
+		})
+		service.weights[clusterName] += weight
+	}
+	sort.Strings(names)
+
+	// The virtual hosts are built per domain, because a domain can be used only once in the route configuration.
+	routesByDomain := map[string][]envoyRoute{}
+	clusters := []*envoyCluster{}
+	for _, name := range names {
+		service := services[name]
+		clusterNames := []string{}
+		for clusterName, cluster := range service.clusters {
+			clusterNames = append(clusterNames, clusterName)
+			clusters = append(clusters, cluster)
+		}
+		sort.Strings(clusterNames)
+		action := envoyRouteAction{}
+		if len(clusterNames) == 1 {
+			action.Cluster = clusterNames[0]
+		} else {
+			action.WeightedClusters = &envoyWeightedClusters{}
+			for _, clusterName := range clusterNames {
+				action.WeightedClusters.Clusters = append(action.WeightedClusters.Clusters, envoyClusterWeight{
+					Name:   clusterName,
+					Weight: service.weights[clusterName],
+				})
+			}
+		}
+		routes := envoyRoutes(service.config, action)
+		domains := service.config.Hosts
+		if len(domains) == 0 {
+			domains = []string{"*"}
+		}
+		for _, domain := range domains {
+			routesByDomain[domain] = append(routesByDomain[domain], routes...)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	domains := []string{}
+	for domain := range routesByDomain {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	virtualHosts := []envoyVirtualHost{}
+	for _, domain := range domains {
+		routes := routesByDomain[domain]
+		// Envoy uses the first matching route, so the longer prefixes go first.
+		sort.SliceStable(routes, func(i, j int) bool {
+			return len(routes[i].Match.Prefix) > len(routes[j].Match.Prefix)
+		})
+		virtualHost := envoyVirtualHost{Name: sanitizeName(domain), Domains: []string{domain}, Routes: routes}
+		if domain == "*" {
+			virtualHost.Name = "default"
+		} else if !strings.Contains(domain, ":") {
+			virtualHost.Domains = append(virtualHost.Domains, domain+":*")
+		}
+		virtualHosts = append(virtualHosts, virtualHost)
+	}
+
+	name := listener.name()
+	address := listener.Address
+	if address == "" {
+		address = "0.0.0.0"
+	}
+	port := listener.Port
+	if port == 0 {
+		port = 10000
+	}
+	return &envoyListener{
+		Name:    name,
+		Address: envoyAddress{SocketAddress: envoySocketAddress{Address: address, PortValue: port}},
+		FilterChains: []envoyFilterChain{{
+			Filters: []envoyFilter{{
+				Name: "envoy.filters.network.http_connection_manager",
+				TypedConfig: &envoyConnectionManager{
+					Type:        envoyConnectionManagerType,
+					StatPrefix:  name,
+					RouteConfig: envoyRouteConfig{Name: name, VirtualHosts: virtualHosts},
+					HTTPFilters: []envoyFilter{{
+						Name:        "envoy.filters.http.router",
+						TypedConfig: &envoyTypedConfig{Type: envoyRouterType},
+					}},
+				},
+			}},
+		}},
+	}, clusters, nil
+}
+
+// newEnvoyCluster creates a cluster without endpoints for the microservice.
+func newEnvoyCluster(name string, config *MicroserviceConfig) *envoyCluster {
+	cluster := &envoyCluster{
+		Name:           name,
+		DiscoveryType:  "STATIC",
+		ConnectTimeout: "5s",
+		LBPolicy:       "ROUND_ROBIN",
+		LoadAssignment: envoyLoadAssignment{
+			ClusterName: name,
+			Endpoints:   []envoyLocalityEndpoints{{LBEndpoints: []envoyLBEndpoint{}}},
+		},
+	}
+	if tlsConfig := config.UpstreamTLS; tlsConfig != nil {
+		tlsContext := envoyUpstreamTLSContext{Type: envoyUpstreamTLSType, SNI: tlsConfig.SNI}
+		if tlsConfig.ClientCertFile != "" {
+			tlsContext.CommonTLSContext.TLSCertificates = []envoyTLSCertificate{{
+				CertificateChain: envoyDataSource{Filename: tlsConfig.ClientCertFile},
+				PrivateKey:       envoyDataSource{Filename: tlsConfig.ClientKeyFile},
+			}}
+		}
+		if tlsConfig.CACertFile != "" {
+			tlsContext.CommonTLSContext.ValidationContext = &envoyValidationContext{
+				TrustedCA: envoyDataSource{Filename: tlsConfig.CACertFile},
+			}
+		}
+		cluster.TransportSocket = &envoyTransportSocket{Name: "envoy.transport_sockets.tls", TypedConfig: tlsContext}
+	}
+	return cluster
+}
+
+// envoyRoutes builds a route for every path of the microservice, matching the methods of the microservice.
+func envoyRoutes(config *MicroserviceConfig, action envoyRouteAction) []envoyRoute {
+	paths := config.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	routes := []envoyRoute{}
+	for _, path := range paths {
+		route := envoyRoute{
+			Name:  strings.TrimSuffix(sanitizeName(config.MicroserviceName+path), "-"),
+			Match: envoyRouteMatch{Prefix: path},
+			Route: action,
+		}
+		if len(config.Methods) > 0 {
+			route.Match.Headers = []envoyHeaderMatcher{{
+				Name:        ":method",
+				StringMatch: envoyStringMatcher{SafeRegex: envoyRegex{Regex: fmt.Sprintf("^(%s)$", strings.Join(config.Methods, "|"))}},
+			}}
+		}
+		if config.StripPath && path != "/" {
+			// A prefix rewrite to "/" would turn "/users/1" into "//1", so the prefix is stripped with a regex.
+			route.Route.RegexRewrite = &envoyRegexRewrite{
+				Pattern:      envoyRegex{Regex: fmt.Sprintf("^%s/?", regexp.QuoteMeta(strings.TrimSuffix(path, "/")))},
+				Substitution: "/",
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+func (l EnvoyListener) name() string {
+	if l.Name == "" {
+		return "ingress"
+	}
+	return l.Name
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newEnvoyTestConfig() *MicroserviceConfig {
+	return &MicroserviceConfig{
+		MicroserviceName: "user-microservice",
+		MicroservicePort: 8080,
+		Hosts:            []string{"user.api.jormugandr.org"},
+		Paths:            []string{"/users", "/users/profile"},
+		Methods:          []string{"GET", "POST"},
+		StripPath:        true,
+		Weight:           10,
+	}
+}
+
+func readEnvoyFile(t *testing.T, dir, file string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEnvoyRegistration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envoy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := NewEnvoyRegistration(dir, newEnvoyTestConfig())
+	first.InstanceAddress = "10.0.0.5"
+
+	secondConfig := newEnvoyTestConfig()
+	secondConfig.Weight = 30
+	second := NewEnvoyRegistration(dir, secondConfig)
+	second.InstanceAddress = "10.0.0.6"
+
+	otherConfig := &MicroserviceConfig{
+		MicroserviceName: "todo-microservice",
+		MicroservicePort: 8081,
+		Paths:            []string{"/todos"},
+		UpstreamTLS:      &UpstreamTLSConfig{CACertFile: "/etc/ssl/ca.pem", SNI: "todos.internal"},
+	}
+	other := NewEnvoyRegistration(dir, otherConfig)
+	other.InstanceAddress = "todo.internal"
+
+	for _, registration := range []*EnvoyRegistration{first, second, other} {
+		if err := registration.SelfRegister(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "user-microservice.instance.10-0-0-5_8080.json")); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "envoy/lds.yaml", readEnvoyFile(t, dir, EnvoyListenersFile))
+	assertGolden(t, "envoy/cds.yaml", readEnvoyFile(t, dir, EnvoyClustersFile))
+
+	if err := second.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	clusters := string(readEnvoyFile(t, dir, EnvoyClustersFile))
+	if strings.Contains(clusters, "10.0.0.6") || strings.Contains(clusters, "todo-microservice") {
+		t.Fatalf("Expected the unregistered instances to be removed:\n%s", clusters)
+	}
+	if !strings.Contains(clusters, "10.0.0.5") {
+		t.Fatalf("Expected the registered instance to remain:\n%s", clusters)
+	}
+	// Unregistering twice is not an error.
+	if err := other.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvoyWeightedVersions(t *testing.T) {
+	stable := newEnvoyTestConfig()
+	stable.Version = "v1"
+	stable.Weight = 90
+	canary := newEnvoyTestConfig()
+	canary.Version = "v2"
+	canary.Weight = 10
+	canary.Hosts = nil
+	instances := []EnvoyInstance{
+		{Address: "10.0.0.5", Config: stable},
+		{Address: "10.0.0.6", Config: stable},
+		{Address: "10.0.0.7", Config: canary},
+	}
+
+	bootstrap, err := RenderEnvoyStaticBootstrap(EnvoyListener{Name: "public", Port: 8000}, instances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "envoy/static.yaml", bootstrap)
+
+	if _, _, err := RenderEnvoyResources(EnvoyListener{}, []EnvoyInstance{{Address: "10.0.0.5", Config: &MicroserviceConfig{}}}); err == nil {
+		t.Fatal("Expected an error for an instance without a microservice name")
+	}
+}
+
+func TestEnvoyDynamicBootstrap(t *testing.T) {
+	registration := NewEnvoyRegistration("/etc/envoy/dynamic", newEnvoyTestConfig())
+	bootstrap, err := registration.DynamicBootstrap("edge-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "envoy/bootstrap.yaml", bootstrap)
+}
@@ -1,5 +1,7 @@
 package gateway
 
+import "context"
+
 // Registration registers and unregisters microservices on the API Gateway.
 type Registration interface {
 
@@ -9,3 +11,14 @@ type Registration interface {
 	// Unregister unregisters previously registerd microservice on an API Gateway.
 	Unregister() error
 }
+
+// ContextRegistration is a Registration that supports cancellation and deadlines through a context.Context.
+type ContextRegistration interface {
+	Registration
+
+	// SelfRegisterContext performs a self registration of the microservice with the given context.
+	SelfRegisterContext(ctx context.Context) error
+
+	// UnregisterContext unregisters the microservice with the given context.
+	UnregisterContext(ctx context.Context) error
+}
@@ -1,22 +1,33 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"time"
 )
 
 // KongGateway holds the configuration and values for a pre-defined Kong API Gateway.
 type KongGateway struct {
 	// GatewayURL is the admin URL of the kong gateway. This is usually the URL (host plus port) of Kong admin
 	GatewayURL string
-	config     *MicroserviceConfig
-	client     *http.Client
+	// Retry configures the retries of SelfRegisterContext and UnregisterContext. If nil, DefaultRetryConfig is used.
+	Retry *RetryConfig
+	// AddressResolver resolves the address of this instance for the upstream target. If nil, DefaultAddressResolver is used.
+	AddressResolver AddressResolver
+	// Admin configures the authentication on a protected Admin API (RBAC token, workspace). If nil, no authentication is used.
+	Admin *AdminConfig
+	// InstanceID identifies this instance in the tags of its target on Kong. If empty, the host name is used.
+	InstanceID string
+	// Observer receives the structured events of the registration (requests, changes, retries). If nil, no events are emitted.
+	Observer Observer
+	config   *MicroserviceConfig
+	client   *http.Client
 }
 
 // MicroserviceConfig represents configuration for the microservice itself.
@@ -37,8 +48,18 @@ type MicroserviceConfig struct {
 	// This is a configuration for 'upstream' in Kong Gateway.
 	VirtualHost string `json:"virtual_host,omitempty"`
 
+	// Paths is a list of URL paths (prefixes) on the gateway that are proxied to the microservice.
 	Paths []string `json:"paths,omitempty"`
 
+	// Methods is a list of HTTP methods allowed to be proxied to the microservice. If empty, all methods are allowed.
+	Methods []string `json:"methods,omitempty"`
+
+	// StripPath signals whether the gateway should strip the matched path prefix from the upstream request URL.
+	StripPath bool `json:"strip_path,omitempty"`
+
+	// PreserveHost signals whether the gateway should pass the original Host header to the microservice.
+	PreserveHost bool `json:"preserve_host,omitempty"`
+
 	// Hosts is a list of supported hosts by the microservice.
 	// When accessing the microservice, you must set the HTTP header 'Host' to a value
 	// that is listed in this list of hosts.
@@ -50,8 +71,50 @@ type MicroserviceConfig struct {
 	// ServicesMaxSlots is the maximal number of slots which the load ballancer on the gateway will
 	// allocate for the this VirtualHost.
 	ServicesMaxSlots int `json:"slots,omitempty"`
+
+	// KongMode selects the Kong Admin API objects used for registration. Use "services" (KongModeServices)
+	// for Kong 1.x and newer. If not set, the legacy "apis" (KongModeAPIs) mode is used.
+	KongMode string `json:"kong_mode,omitempty"`
+
+	// HealthChecks is the configuration of the active and passive health checks of the upstream on Kong.
+	// Kong stops proxying requests to the instances (targets) that are found unhealthy.
+	HealthChecks *HealthChecks `json:"healthchecks,omitempty"`
+
+	// Plugins is a list of Kong plugins bound to the API (or Route) of the microservice.
+	// If set (even to an empty list), the plugins bound to the API (or Route) that are not in the list are removed.
+	Plugins []PluginConfig `json:"plugins,omitempty"`
+
+	// Routes is a list of routes of the microservice, each with its own paths, methods, hosts and plugins.
+	// Every route is registered as a separate Kong Route (or API). If set, the Paths and Methods are ignored,
+	// and the Routes (or APIs) of the microservice on Kong that are not in the list are removed.
+	// Used only by the Kong registration.
+	Routes []RouteConfig `json:"routes,omitempty"`
+
+	// AdvertiseAddress is the address (IP or host name) on which the gateway reaches this instance.
+	// If set, it is used instead of the address resolved from the network interfaces.
+	AdvertiseAddress string `json:"advertise_address,omitempty"`
+
+	// Version is the version label of this instance, for example "v2" or "1.4.0". The targets are tagged with
+	// the version, so the traffic can be shifted between the versions (see KongGateway.SetTrafficSplit).
+	// Used only in the services Kong mode.
+	Version string `json:"version,omitempty"`
+
+	// UpstreamTLS is the configuration of the TLS connection from the gateway to the microservice.
+	// If set, the gateway proxies the requests to the microservice over HTTPS.
+	UpstreamTLS *UpstreamTLSConfig `json:"upstream_tls,omitempty"`
 }
 
+// DefaultTargetWeight is the weight of the instance target on Kong when no weight is configured.
+const DefaultTargetWeight = 100
+
+const (
+	// KongModeAPIs registers the microservice as a legacy Kong API object (Kong 0.x).
+	KongModeAPIs = "apis"
+
+	// KongModeServices registers the microservice as a Kong Service with a Route (Kong 1.x and newer).
+	KongModeServices = "services"
+)
+
 // NewKongGateway creates a Kong Gateway with the given admin URL of kong, an http.Client and a MicroserviceConfig.
 func NewKongGateway(adminURL string, client *http.Client, config *MicroserviceConfig) *KongGateway {
 	return &KongGateway{
@@ -70,7 +133,12 @@ func NewKongGateway(adminURL string, client *http.Client, config *MicroserviceCo
 //		"virtual_host": "Microservices upstream virtual host",
 // 		"hosts": ["localhost", "example.org"] // valid HTTP Host header values for this microservice
 // 		"weight": 10, // microservice instance weight used for load ballancing
-// 		"slots": 100 // maximal number of slots to allocate for this microservices group
+// 		"slots": 100, // maximal number of slots to allocate for this microservices group
+// 		"kong_mode": "services" // "services" for Kong 1.x and newer, "apis" (default) for the legacy API objects
+// 		"advertise_address": "10.0.1.5" // optional address on which the gateway reaches this instance
+// 		"version": "v2" // optional version label for shifting the traffic between the versions
+// 		"upstream_tls": {"ca_cert_file": "/run/secrets/ca.pem", "verify": true} // optional HTTPS to the microservice
+// 		"routes": [{"name": "public", "paths": ["/users"], "methods": ["GET"]}] // optional routes with their own policies
 // }
 func NewKongGatewayFromConfigFile(adminURL string, client *http.Client, configFile string) (*KongGateway, error) {
 	var config MicroserviceConfig
@@ -91,52 +159,182 @@ func NewKongGatewayFromConfigFile(adminURL string, client *http.Client, configFi
 // 1. Checks for existence of 'upstream' for the microservices group. If there is no 'upstream'
 // configured, it creates a new one with the given configuration.
 // 2. Checks for the existince of API for the microservices group. If there is no API
-// created on Kong, it creates a new one with the given configuration.
-// 3. Adds new target on kong for the configured 'upstream' and 'API'.
+// created on Kong, it creates a new one with the given configuration. The API is pointed at the 'upstream'.
+// 3. Creates, updates or deletes the plugins bound to the API, as configured in Plugins.
+// 4. Adds new target on kong for the configured 'upstream' and 'API'.
+// When KongMode is set to "services", a Kong Service and Route are registered instead of an API.
+// When Routes are configured, an API (or Route) is registered for every route, with its own plugins.
 func (kong *KongGateway) SelfRegister() error {
-	// err := kong.createOrUpdateUpstream(kong.config.VirtualHost, kong.config.ServicesMaxSlots)
-	// if err != nil {
-	// 	return err
-	// }
-
-	apiConf := NewAPIConf()
-	// TODO: map here from config
-	apiConf.Name = kong.config.MicroserviceName
-	apiConf.Hosts = kong.config.Hosts
-	apiConf.UpstreamURL = fmt.Sprintf("http://%s:%d", kong.config.VirtualHost, kong.config.MicroservicePort)
-	apiConf.URIs = kong.config.Paths
-	_, err := kong.createOrUpdateAPI(apiConf)
-	return err
+	return kong.selfRegister(context.Background())
+}
 
-	// _, err = kong.addSelfAsTarget(kong.config.VirtualHost, kong.config.MicroservicePort, kong.config.Weight)
-	// return err
+// SelfRegisterContext performs the self registration (see SelfRegister) with the given context.
+// If Kong is not reachable or reports a temporary error, the registration is retried with exponential
+// backoff (see Retry) until it succeeds or the context is done.
+func (kong *KongGateway) SelfRegisterContext(ctx context.Context) error {
+	return kong.withRetry(ctx, OperationRegister, kong.selfRegister)
 }
 
 // Unregister unregisters this instance of the microservice from the Kong Gateway.
-// Basically it updates the upstream target with weight 0, which disables the target.
+// It deletes the target for this instance from the upstream, so the gateway stops proxying
+// requests to this instance. The upstream and the API (or Service) are left intact for the other instances.
 func (kong *KongGateway) Unregister() error {
-	_, err := kong.addSelfAsTarget(kong.config.VirtualHost, kong.config.MicroservicePort, 0)
+	return kong.unregister(context.Background())
+}
+
+// UnregisterContext unregisters this instance (see Unregister) with the given context.
+// Temporary failures are retried with exponential backoff (see Retry) until the context is done.
+func (kong *KongGateway) UnregisterContext(ctx context.Context) error {
+	return kong.withRetry(ctx, OperationUnregister, kong.unregister)
+}
+
+// Drain stops Kong from proxying new requests to this instance, without unregistering it. The target
+// for this instance is set to weight 0, so the requests already in flight can still complete.
+// Use Unregister to delete the target once the instance is drained.
+func (kong *KongGateway) Drain(ctx context.Context) error {
+	if kong.config.KongMode == KongModeServices {
+		self, err := kong.selfTarget(kong.config.MicroservicePort)
+		if err != nil {
+			return err
+		}
+		return kong.request(ctx, "PATCH", fmt.Sprintf("upstreams/%s/targets/%s", kong.config.VirtualHost, url.PathEscape(self)), map[string]int{"weight": 0}, nil)
+	}
+	// Kong 0.x does not support updating targets, the latest target entry with the same address takes precedence.
+	_, err := kong.addSelfAsTarget(ctx, kong.config.VirtualHost, kong.config.MicroservicePort, 0)
 	return err
 }
 
+func (kong *KongGateway) selfRegister(ctx context.Context) (err error) {
+	start := time.Now()
+	defer func() {
+		kong.emit(ctx, Event{Type: EventRegister, Operation: OperationRegister, Duration: time.Since(start), Err: err})
+	}()
+
+	var register func(ctx context.Context) error
+	switch kong.config.KongMode {
+	case "", KongModeAPIs:
+		register = kong.registerAPI
+	case KongModeServices:
+		register = kong.registerServiceAndRoute
+	default:
+		return fmt.Errorf("unsupported Kong mode: %s", kong.config.KongMode)
+	}
+	if err := kong.validateUpstreamTLS(); err != nil {
+		return err
+	}
+	if err := kong.validateRoutes(); err != nil {
+		return err
+	}
+
+	if _, err := kong.createOrUpdateUpstream(ctx, kong.desiredUpstream()); err != nil {
+		return err
+	}
+
+	if err := register(ctx); err != nil {
+		return err
+	}
+
+	// The removed routes are deleted only after the new routes are registered, so no requests are left unmatched.
+	if _, err := kong.deleteStaleRoutes(ctx); err != nil {
+		return err
+	}
+
+	if _, err := kong.syncPlugins(ctx); err != nil {
+		return err
+	}
+
+	weight, err := kong.instanceWeight(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = kong.addSelfAsTarget(ctx, kong.config.VirtualHost, kong.config.MicroservicePort, weight)
+	return err
+}
+
+func (kong *KongGateway) unregister(ctx context.Context) error {
+	start := time.Now()
+	err := kong.removeSelfAsTarget(ctx, kong.config.VirtualHost, kong.config.MicroservicePort)
+	kong.emit(ctx, Event{Type: EventUnregister, Operation: OperationUnregister, Duration: time.Since(start), Err: err})
+	return err
+}
+
+// registerAPI creates or updates the (legacy) Kong API objects (one per route) for the microservice.
+func (kong *KongGateway) registerAPI(ctx context.Context) error {
+	for _, api := range kong.desiredAPIs() {
+		if _, err := kong.createOrUpdateAPI(ctx, api); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetWeight returns the configured weight for this instance, or DefaultTargetWeight if no weight is configured.
+func (kong *KongGateway) targetWeight() int {
+	if kong.config.Weight > 0 {
+		return kong.config.Weight
+	}
+	return DefaultTargetWeight
+}
+
+// InstanceTagPrefix is the prefix of the tag holding the instance ID on the target of the instance.
+const InstanceTagPrefix = "instance:"
+
+// ownerTags returns the tags that mark the objects on Kong as owned by this microservice.
+// Tags are supported by Kong 1.1 and newer, so the objects are tagged only in the services mode.
+func (kong *KongGateway) ownerTags() []string {
+	if kong.config.KongMode != KongModeServices {
+		return nil
+	}
+	return []string{kong.config.MicroserviceName}
+}
+
+// targetTags returns the tags of the target for this instance: the owner tags, the instance ID and the version label.
+// The upstream, service, route and plugins are shared by all instances, so only the target is tagged with the instance ID.
+func (kong *KongGateway) targetTags() []string {
+	tags := kong.ownerTags()
+	if tags == nil {
+		return nil
+	}
+	tags = append(tags, InstanceTagPrefix+kong.instanceID())
+	if kong.config.Version != "" {
+		tags = append(tags, VersionTagPrefix+kong.config.Version)
+	}
+	return tags
+}
+
+// instanceID returns the configured InstanceID, or the host name (the container ID in Docker) if it is not set.
+func (kong *KongGateway) instanceID() string {
+	if kong.InstanceID != "" {
+		return kong.InstanceID
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "unknown"
+}
+
 // upstream is internally used structure that represents Kong's 'upstream' object.
 // See https://getkong.org/docs/0.10.x/admin-api/#upstream-object
 type upstream struct {
-	ID        string `json:"id,omitempty"`
-	Name      string `json:"name,omitempty"`
-	OrderList []int  `json:"orderlist,omitempty"`
-	Slots     int    `json:"slots,omitempty"`
-	CreatedAt int    `json:"created_at,omitempty"`
+	ID           string        `json:"id,omitempty"`
+	Name         string        `json:"name,omitempty"`
+	OrderList    []int         `json:"orderlist,omitempty"`
+	Slots        int           `json:"slots,omitempty"`
+	Healthchecks *HealthChecks `json:"healthchecks,omitempty"`
+	HostHeader   string        `json:"host_header,omitempty"`
+	Tags         []string      `json:"tags,omitempty"`
+	CreatedAt    int           `json:"created_at,omitempty"`
 }
 
 // upstreamTarget is internally used structure that represents Kong's 'upstream-target' object.
 // See https://getkong.org/docs/0.10.x/admin-api/#target-object
 type upstreamTarget struct {
-	ID         string `json:"id,omitempty"`
-	Target     string `json:"target,omitempty"`
-	Weight     int    `json:"weight,omitempty"`
-	UpstreamID string `json:"upstream_id,omitempty"`
-	CreatedAt  int    `json:"created_at,omitempty"`
+	ID         string   `json:"id,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	Weight     int      `json:"weight,omitempty"`
+	UpstreamID string   `json:"upstream_id,omitempty"`
+	CreatedAt  int      `json:"created_at,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
 }
 
 // API is a structure that represents Kong's API object.
@@ -145,8 +343,8 @@ type API struct {
 	ID                     string   `json:"id,omitempty"`
 	CreatedAt              int      `json:"created_at,omitempty"`
 	Hosts                  []string `json:"hosts,omitempty"`
-	URIs                   []string `json:"-"`
-	Methods                []string `json:"-"`
+	URIs                   []string `json:"uris,omitempty"`
+	Methods                []string `json:"methods,omitempty"`
 	HTTPIfTerminated       bool     `json:"http_if_terminated,omitempty"`
 	HTTPSOnly              bool     `json:"https_only,omitempty"`
 	Name                   string   `json:"name,omitempty"`
@@ -182,101 +380,104 @@ func (api *API) AddHost(host string) {
 	api.Hosts = append(api.Hosts, host)
 }
 
-// GetServiceIP returns the valid IP of the microservice container.
-func GetServiceIP() (string, error) {
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return "", err
-	}
-
-	for _, iface := range ifaces {
-		addrs, err := iface.Addrs()
-		if err != nil {
-			return "", nil
-		}
-		for _, addr := range addrs {
-			var ip net.IP
-
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-
-			if ip.To4() != nil && ip.String() != "127.0.0.1" && ip.String() != "::1" {
-				return ip.String(), nil
-			}
-		}
-
-	}
-
-	return "", errors.New("IP not found")
-}
-
 // getKongURL returns a full URL to the desired 'path' on the Kong Gateway.
 func (kong *KongGateway) getKongURL(path string) string {
+	if kong.Admin != nil && kong.Admin.Workspace != "" {
+		return fmt.Sprintf("%s/%s/%s", kong.GatewayURL, kong.Admin.Workspace, path)
+	}
 	return fmt.Sprintf("%s/%s", kong.GatewayURL, path)
 }
 
 // getUpstreamObj call the 'upstream' API on Kong and retrieves an upstream object with the given name.
 // Returns the upstream object if found, or nil if there is no upstream with that name.
-func (kong *KongGateway) getUpstreamObj(name string) (*upstream, error) {
+func (kong *KongGateway) getUpstreamObj(ctx context.Context, name string) (*upstream, error) {
 	var upstreamObj upstream
-	resp, err := kong.client.Get(kong.getKongURL(fmt.Sprintf("upstreams/%s", name)))
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode == 404 {
+	err := kong.request(ctx, "GET", fmt.Sprintf("upstreams/%s", name), nil, &upstreamObj)
+	if isNotFound(err) {
 		return nil, nil
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&upstreamObj); err != nil {
+	if err != nil {
 		return nil, err
 	}
 	return &upstreamObj, nil
 }
 
 // createUpstreamObj creates new upstream object on Kong.
-func (kong *KongGateway) createUpstreamObj(name string, slots int) (*upstream, error) {
+func (kong *KongGateway) createUpstreamObj(ctx context.Context, upstreamConf *upstream) (*upstream, error) {
 	var upstreamObj upstream
-	form := url.Values{}
-
-	form.Add("name", name)
-	form.Add("slots", fmt.Sprintf("%d", slots))
-
-	resp, err := kong.client.Post(kong.getKongURL("upstreams/"), "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
-	if err != nil {
+	if err := kong.request(ctx, "POST", "upstreams/", upstreamConf, &upstreamObj); err != nil {
 		return nil, err
 	}
+	return &upstreamObj, nil
+}
 
-	if resp.StatusCode != 201 {
-		return nil, fmt.Errorf(resp.Status)
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&upstreamObj); err != nil {
-		return nil, err
+// updateUpstreamObj updates the slots and the health checks of an existing upstream object on Kong.
+func (kong *KongGateway) updateUpstreamObj(ctx context.Context, upstreamConf *upstream) error {
+	update := &upstream{
+		Slots:        upstreamConf.Slots,
+		Healthchecks: upstreamConf.Healthchecks,
+		HostHeader:   upstreamConf.HostHeader,
+		Tags:         upstreamConf.Tags,
 	}
-	return &upstreamObj, nil
+	return kong.request(ctx, "PATCH", fmt.Sprintf("upstreams/%s", upstreamConf.Name), update, nil)
 }
 
 // createOrUpdateUpstream creates a new upstream object on Kong if it doesn't exist.
-func (kong *KongGateway) createOrUpdateUpstream(name string, slots int) error {
-	up, err := kong.getUpstreamObj(name)
+// If the upstream exists, but with a different number of slots, different health checks or without the owner tags,
+// the upstream is updated.
+// Returns true if the upstream was created or updated.
+func (kong *KongGateway) createOrUpdateUpstream(ctx context.Context, upstreamConf *upstream) (bool, error) {
+	up, err := kong.getUpstreamObj(ctx, upstreamConf.Name)
 	if err != nil {
-		return err
+		return false, err
 	}
 	if up == nil {
-		if _, err = kong.createUpstreamObj(name, slots); err != nil {
-			return err
+		_, err = kong.createUpstreamObj(ctx, upstreamConf)
+		return err == nil, err
+	}
+	if upstreamDiffers(upstreamConf, up) {
+		// Keep the tags set by others, for example the traffic split between the versions.
+		update := *upstreamConf
+		if update.Tags != nil {
+			update.Tags = unionStrings(up.Tags, upstreamConf.Tags)
 		}
+		err = kong.updateUpstreamObj(ctx, &update)
+		return err == nil, err
 	}
-	return nil
+	return false, nil
+}
+
+// desiredUpstream maps the microservice configuration onto a Kong upstream object.
+func (kong *KongGateway) desiredUpstream() *upstream {
+	desired := &upstream{
+		Name:         kong.config.VirtualHost,
+		Slots:        kong.config.ServicesMaxSlots,
+		Healthchecks: kong.config.HealthChecks.withDefaults(),
+		Tags:         kong.ownerTags(),
+	}
+	if kong.config.UpstreamTLS != nil {
+		desired.HostHeader = kong.config.UpstreamTLS.SNI
+	}
+	return desired
+}
+
+// upstreamDiffers checks whether the upstream on Kong differs from the desired upstream.
+// Only the configured values are compared, because Kong fills in the defaults for the rest of the values.
+func upstreamDiffers(desired, current *upstream) bool {
+	if desired.Slots > 0 && desired.Slots != current.Slots {
+		return true
+	}
+	if !containsStrings(current.Tags, desired.Tags) {
+		return true
+	}
+	if desired.HostHeader != "" && desired.HostHeader != current.HostHeader {
+		return true
+	}
+	return desired.Healthchecks != nil && !containsJSON(current.Healthchecks, desired.Healthchecks)
 }
 
 // createKongAPI creates new API object on Kong.
-func (kong *KongGateway) createOrUpdateKongAPI(apiConf *API) (*API, error) {
+func (kong *KongGateway) createOrUpdateKongAPI(ctx context.Context, apiConf *API) (*API, error) {
 	var result API
 	form := url.Values{}
 
@@ -309,31 +510,16 @@ func (kong *KongGateway) createOrUpdateKongAPI(apiConf *API) (*API, error) {
 	form.Add("preserve_host", fmt.Sprintf("%t", apiConf.PreserveHost))
 	form.Add("https_only", fmt.Sprintf("%t", apiConf.HTTPSOnly))
 	form.Add("http_if_terminated", fmt.Sprintf("%t", apiConf.HTTPIfTerminated))
-	var resp *http.Response
+
 	var err error
 	if apiConf.ID == "" {
 		// Create API
-		resp, err = kong.client.Post(kong.getKongURL("apis/"), "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
-		if err != nil {
-			return nil, err
-		}
+		err = kong.request(ctx, "POST", "apis/", form, &result)
 	} else {
 		// Update API
-		req, err := http.NewRequest("PATCH", kong.getKongURL(fmt.Sprintf("apis/%s", apiConf.ID)), strings.NewReader(form.Encode()))
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		resp, err = kong.client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		if resp.StatusCode != 200 {
-			return nil, fmt.Errorf(resp.Status)
-		}
+		err = kong.request(ctx, "PATCH", fmt.Sprintf("apis/%s", apiConf.ID), form, &result)
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
@@ -345,21 +531,16 @@ func (kong *KongGateway) createOrUpdateKongAPI(apiConf *API) (*API, error) {
 
 // getAPI retrieves the API object from Kong with the given name.
 // Returns the API object if found, or nil if no such object exists on Kong.
-func (kong *KongGateway) getAPI(name string) (*API, error) {
+func (kong *KongGateway) getAPI(ctx context.Context, name string) (*API, error) {
 	if name == "" {
 		return nil, fmt.Errorf("name is empty")
 	}
-	resp, err := kong.client.Get(kong.getKongURL(fmt.Sprintf("apis/%s", name)))
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode == 404 {
+	var api API
+	err := kong.request(ctx, "GET", fmt.Sprintf("apis/%s", name), nil, &api)
+	if isNotFound(err) {
 		return nil, nil
 	}
-
-	var api API
-	if err := json.NewDecoder(resp.Body).Decode(&api); err != nil {
+	if err != nil {
 		return nil, err
 	}
 	return &api, nil
@@ -367,43 +548,73 @@ func (kong *KongGateway) getAPI(name string) (*API, error) {
 
 // createOrUpdateAPI creates a new API object if it doesn't exist on Kong.
 // Returns the created (or existing) object from Kong.
-func (kong *KongGateway) createOrUpdateAPI(apiConf *API) (*API, error) {
-	api, err := kong.getAPI(apiConf.Name)
+func (kong *KongGateway) createOrUpdateAPI(ctx context.Context, apiConf *API) (*API, error) {
+	api, err := kong.getAPI(ctx, apiConf.Name)
 	if err != nil {
 		return nil, err
 	}
 	if api != nil {
 		apiConf.ID = api.ID
 	}
-	api, err = kong.createOrUpdateKongAPI(apiConf)
+	api, err = kong.createOrUpdateKongAPI(ctx, apiConf)
 	return api, err
 }
 
 // addSelfAsTarget crates a new target object on kong for this specific service with the upstream and weight.
-func (kong *KongGateway) addSelfAsTarget(upstream string, port int, weight int) (*upstreamTarget, error) {
+// If the target already exists on the upstream and Kong rejects the duplicate, the existing target is updated.
+func (kong *KongGateway) addSelfAsTarget(ctx context.Context, upstream string, port int, weight int) (*upstreamTarget, error) {
 	var target upstreamTarget
 
-	ip, err := GetServiceIP()
+	self, err := kong.selfTarget(port)
 	if err != nil {
 		return nil, err
 	}
 
-	form := url.Values{}
-	form.Add("target", fmt.Sprintf("%s:%d", ip, port))
-	form.Add("weight", fmt.Sprintf("%d", weight))
-
-	resp, err := kong.client.Post(kong.getKongURL(fmt.Sprintf("upstreams/%s/targets", upstream)), "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	// Kong 0.x accepts only form encoded targets, while the tags (Kong 1.1 and newer) are sent as JSON.
+	var body interface{}
+	if tags := kong.targetTags(); tags != nil {
+		body = map[string]interface{}{"target": self, "weight": weight, "tags": tags}
+	} else {
+		form := url.Values{}
+		form.Add("target", self)
+		form.Add("weight", fmt.Sprintf("%d", weight))
+		body = form
+	}
 
+	path := fmt.Sprintf("upstreams/%s/targets", upstream)
+	err = kong.request(ctx, "POST", path, body, &target)
+	if isConflict(err) {
+		// Kong 3.x rejects a target that already exists on the upstream (for example an instance that restarted
+		// on the same address), so the existing target is updated instead.
+		err = kong.request(ctx, "PATCH", fmt.Sprintf("%s/%s", path, url.PathEscape(self)), body, &target)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 201 {
-		return nil, fmt.Errorf(resp.Status)
+
+	return &target, nil
+}
+
+// removeSelfAsTarget deletes the target object for this specific service instance from the upstream on Kong.
+// It is not an error if the target does not exist on Kong.
+func (kong *KongGateway) removeSelfAsTarget(ctx context.Context, upstream string, port int) error {
+	self, err := kong.selfTarget(port)
+	if err != nil {
+		return err
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&target); err != nil {
-		return nil, err
+	err = kong.request(ctx, "DELETE", fmt.Sprintf("upstreams/%s/targets/%s", upstream, url.PathEscape(self)), nil, nil)
+	if isNotFound(err) {
+		return nil
 	}
+	return err
+}
 
-	return &target, nil
+// selfTarget returns the target address (IP:port) of this service instance.
+func (kong *KongGateway) selfTarget(port int) (string, error) {
+	address, err := resolveAddress(kong.config, kong.AddressResolver)
+	if err != nil {
+		return "", err
+	}
+	return joinHostPort(address, port), nil
 }
@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DefaultAdminTokenHeader is the header in which the RBAC token is sent to the Kong Admin API.
+const DefaultAdminTokenHeader = "Kong-Admin-Token"
+
+// AdminConfig holds the configuration of the connection to a protected Kong Admin API.
+type AdminConfig struct {
+	// Token is the RBAC token of the Kong admin user.
+	Token string `json:"token,omitempty"`
+
+	// TokenFile is the location of a file holding the RBAC token, for example a mounted secret.
+	// Used if Token is not set. The file is read on every request, so the token can be rotated.
+	TokenFile string `json:"tokenFile,omitempty"`
+
+	// TokenHeader is the header in which the token is sent. Defaults to DefaultAdminTokenHeader.
+	TokenHeader string `json:"tokenHeader,omitempty"`
+
+	// Workspace is the Kong workspace. If set, all Admin API paths are prefixed with the workspace name.
+	Workspace string `json:"workspace,omitempty"`
+
+	// CACertFile is the location of the PEM encoded CA bundle used to verify the certificate of the Admin API.
+	// If not set, the system CA pool is used.
+	CACertFile string `json:"caCertFile,omitempty"`
+
+	// ClientCertFile is the location of the PEM encoded client certificate for the mutual-TLS Admin API listener.
+	ClientCertFile string `json:"clientCertFile,omitempty"`
+
+	// ClientKeyFile is the location of the PEM encoded private key of the client certificate.
+	ClientKeyFile string `json:"clientKeyFile,omitempty"`
+
+	// Headers holds additional headers sent with every request to the Admin API.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// TLSConfig loads the CA bundle and the client certificate into a tls.Config.
+// Returns nil if neither is configured.
+func (c *AdminConfig) TLSConfig() (*tls.Config, error) {
+	if c.CACertFile == "" && c.ClientCertFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if c.CACertFile != "" {
+		pem, err := ioutil.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.ClientCertFile != "" {
+		if c.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client key file is required for the client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// NewHTTPClient creates an http.Client for the Admin API with the configured TLS settings.
+func (c *AdminConfig) NewHTTPClient() (*http.Client, error) {
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return &http.Client{}, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// NewKongGatewayWithAdminConfig creates a Kong Gateway for a protected Admin API. The http.Client is created
+// with the TLS settings from the AdminConfig.
+func NewKongGatewayWithAdminConfig(adminURL string, admin *AdminConfig, config *MicroserviceConfig) (*KongGateway, error) {
+	client, err := admin.NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	kong := NewKongGateway(adminURL, client, config)
+	kong.Admin = admin
+	return kong, nil
+}
+
+// setHeaders sets the token and the additional headers on the request to the Admin API.
+func (c *AdminConfig) setHeaders(req *http.Request) error {
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	token := c.Token
+	if token == "" && c.TokenFile != "" {
+		data, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil
+	}
+	header := c.TokenHeader
+	if header == "" {
+		header = DefaultAdminTokenHeader
+	}
+	req.Header.Set(header, token)
+	return nil
+}
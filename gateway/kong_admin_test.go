@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdminTokenAndWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kong-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var path, token, custom string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		token = req.Header.Get("Kong-Admin-Token")
+		custom = req.Header.Get("X-Custom")
+		rw.Write([]byte(`{"id":"1","username":"john"}`))
+	}))
+	defer server.Close()
+
+	gateway, err := NewKongGatewayWithAdminConfig(server.URL, &AdminConfig{
+		TokenFile: tokenFile,
+		Workspace: "microservices",
+		Headers:   map[string]string{"X-Custom": "value"},
+	}, &MicroserviceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gateway.GetConsumer(context.Background(), "john"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/microservices/consumers/john" {
+		t.Fatalf("Expected the path to be prefixed with the workspace, got %s", path)
+	}
+	if token != "secret-token" || custom != "value" {
+		t.Fatalf("Expected the token and custom headers, got %q and %q", token, custom)
+	}
+}
+
+func writePEM(t *testing.T, file, blockType string, data []byte) {
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kong-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "microservice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, _ := x509.ParseCertificate(certDER)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`{"id":"1","username":"john"}`))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", server.Certificate().Raw)
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", certDER)
+	writePEM(t, filepath.Join(dir, "client-key.pem"), "EC PRIVATE KEY", keyDER)
+
+	admin := &AdminConfig{CACertFile: filepath.Join(dir, "ca.pem")}
+	gateway, err := NewKongGatewayWithAdminConfig(server.URL, admin, &MicroserviceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gateway.GetConsumer(context.Background(), "john"); err == nil {
+		t.Fatal("Expected the request without a client certificate to fail")
+	}
+
+	admin.ClientCertFile = filepath.Join(dir, "client.pem")
+	admin.ClientKeyFile = filepath.Join(dir, "client-key.pem")
+	gateway, err = NewKongGatewayWithAdminConfig(server.URL, admin, &MicroserviceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := gateway.GetConsumer(context.Background(), "john")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumer.Username != "john" {
+		t.Fatalf("Unexpected consumer: %+v", consumer)
+	}
+}
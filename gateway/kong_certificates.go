@@ -0,0 +1,368 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNI is a structure that represents Kong's SNI object, which binds a host name to a certificate.
+// See https://docs.konghq.com/gateway/latest/admin-api/#sni-object
+type SNI struct {
+	ID          string     `json:"id,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	Certificate *ObjectRef `json:"certificate,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+}
+
+// CertificateInfo describes a host certificate of the microservice on Kong.
+type CertificateInfo struct {
+	// ID is the ID of the certificate on Kong.
+	ID string
+
+	// Subject is the common name of the certificate.
+	Subject string
+
+	// DNSNames are the host names for which the certificate is valid.
+	DNSNames []string
+
+	// SNIs are the host names bound to the certificate on Kong.
+	SNIs []string
+
+	// NotAfter is the expiry time of the certificate.
+	NotAfter time.Time
+}
+
+// ExpiresWithin checks whether the certificate expires within the given duration from now.
+func (c *CertificateInfo) ExpiresWithin(d time.Duration) bool {
+	return time.Now().Add(d).After(c.NotAfter)
+}
+
+const (
+	// hostCertTag marks the certificate for the public hosts of the microservice on Kong.
+	hostCertTag = "host-certificate"
+
+	// TLSCertFileName is the name of the PEM encoded host certificate in the keys directory
+	// (see HostCertificateFiles), as in the Kubernetes TLS secrets.
+	TLSCertFileName = "tls.crt"
+
+	// TLSKeyFileName is the name of the PEM encoded private key of the host certificate in the keys directory.
+	TLSKeyFileName = "tls.key"
+)
+
+// HostCertificateFiles returns the locations of the host certificate and its private key in the keys directory
+// of the microservice (SecurityConfig.KeysDir).
+func HostCertificateFiles(keysDir string) (string, string) {
+	return filepath.Join(keysDir, TLSCertFileName), filepath.Join(keysDir, TLSKeyFileName)
+}
+
+// UploadHostCertificateFiles uploads the PEM encoded certificate and private key from the given files as
+// the host certificate of the microservice (see UploadHostCertificate).
+func (kong *KongGateway) UploadHostCertificateFiles(ctx context.Context, certFile, keyFile string) (*CertificateInfo, error) {
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return kong.UploadHostCertificate(ctx, cert, key)
+}
+
+// UploadHostCertificate uploads the PEM encoded certificate and private key to Kong and binds the Hosts of
+// the microservice and the Hosts of its Routes to it (as SNIs), so Kong terminates TLS for the hosts with
+// the certificate. The certificate must be valid for all these hosts.
+//
+// A new certificate is rotated without downtime: the new certificate is uploaded first, then the SNIs are moved
+// to it one by one, and only then the previous certificate is deleted. Uploading the same certificate again
+// changes nothing. Requires the services Kong mode.
+func (kong *KongGateway) UploadHostCertificate(ctx context.Context, certPEM, keyPEM []byte) (*CertificateInfo, error) {
+	if kong.config.KongMode != KongModeServices {
+		return nil, fmt.Errorf("host certificates are supported only in the %q Kong mode", KongModeServices)
+	}
+	hosts := []string{}
+	for _, route := range kong.routes() {
+		hosts = append(hosts, route.config.Hosts...)
+	}
+	hosts = certificateHosts(hosts)
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts are configured for the certificate")
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate %s expired on %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	}
+	for _, host := range hosts {
+		if !certificateCovers(leaf, host) {
+			return nil, fmt.Errorf("certificate %s is not valid for host %s", leaf.Subject.CommonName, host)
+		}
+	}
+
+	existing, err := kong.hostCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var current *Certificate
+	for i := range existing {
+		if existing[i].Cert == string(certPEM) && existing[i].Key == string(keyPEM) {
+			current = &existing[i]
+		}
+	}
+	if current == nil {
+		current = &Certificate{}
+		values := map[string]interface{}{
+			"cert": string(certPEM),
+			"key":  string(keyPEM),
+			"tags": append(kong.ownerTags(), hostCertTag),
+		}
+		if err := kong.request(ctx, "POST", "certificates", values, current); err != nil {
+			return nil, err
+		}
+	}
+
+	bound, err := kong.certificateSNIs(ctx, current.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		if containsStrings(bound, []string{host}) {
+			continue
+		}
+		// PUT moves the SNI to the new certificate in a single step, so the host is never left without a certificate.
+		sni := &SNI{Name: host, Certificate: &ObjectRef{ID: current.ID}, Tags: kong.ownerTags()}
+		if err := kong.request(ctx, "PUT", fmt.Sprintf("snis/%s", url.PathEscape(host)), sni, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// Deleting the previous certificates also deletes the SNIs of the hosts that are no longer configured.
+	for _, previous := range existing {
+		if previous.ID == current.ID {
+			continue
+		}
+		if err := kong.deleteEntity(ctx, fmt.Sprintf("certificates/%s", previous.ID)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &CertificateInfo{
+		ID:       current.ID,
+		Subject:  leaf.Subject.CommonName,
+		DNSNames: leaf.DNSNames,
+		SNIs:     hosts,
+		NotAfter: leaf.NotAfter,
+	}, nil
+}
+
+// HostCertificates returns the host certificates of the microservice on Kong, with the SNIs bound to them.
+func (kong *KongGateway) HostCertificates(ctx context.Context) ([]CertificateInfo, error) {
+	certificates, err := kong.hostCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos := []CertificateInfo{}
+	for _, certificate := range certificates {
+		pair, err := tls.X509KeyPair([]byte(certificate.Cert), []byte(certificate.Key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate %s: %s", certificate.ID, err.Error())
+		}
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		snis, err := kong.certificateSNIs(ctx, certificate.ID)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, CertificateInfo{
+			ID:       certificate.ID,
+			Subject:  leaf.Subject.CommonName,
+			DNSNames: leaf.DNSNames,
+			SNIs:     snis,
+			NotAfter: leaf.NotAfter,
+		})
+	}
+	return infos, nil
+}
+
+// ExpiringCertificates returns the host certificates of the microservice on Kong that expire within the given
+// duration from now (or have already expired).
+func (kong *KongGateway) ExpiringCertificates(ctx context.Context, within time.Duration) ([]CertificateInfo, error) {
+	certificates, err := kong.HostCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expiring := []CertificateInfo{}
+	for _, certificate := range certificates {
+		if certificate.ExpiresWithin(within) {
+			expiring = append(expiring, certificate)
+		}
+	}
+	return expiring, nil
+}
+
+// hostCertificates lists the certificates on Kong tagged as the host certificates of the microservice.
+func (kong *KongGateway) hostCertificates(ctx context.Context) ([]Certificate, error) {
+	if kong.config.KongMode != KongModeServices {
+		return nil, fmt.Errorf("host certificates are supported only in the %q Kong mode", KongModeServices)
+	}
+	certificates := []Certificate{}
+	query := url.QueryEscape(strings.Join(append(kong.ownerTags(), hostCertTag), ","))
+	if err := kong.listAll(ctx, fmt.Sprintf("certificates?tags=%s", query), &certificates); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// certificateSNIs returns the sorted names of the SNIs bound to the certificate.
+func (kong *KongGateway) certificateSNIs(ctx context.Context, id string) ([]string, error) {
+	snis := []SNI{}
+	if err := kong.listAll(ctx, fmt.Sprintf("certificates/%s/snis", id), &snis); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, sni := range snis {
+		names = append(names, sni.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// certificateHosts returns the sorted, unique host names (without the port) to bind to the certificate.
+func certificateHosts(hosts []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, host := range hosts {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(host)
+		if host != "" && !seen[host] {
+			seen[host] = true
+			result = append(result, host)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// certificateCovers checks whether the certificate is valid for the host. A wildcard host ("*.example.com")
+// must be listed as is in the certificate.
+func certificateCovers(cert *x509.Certificate, host string) bool {
+	if strings.Contains(host, "*") {
+		for _, name := range cert.DNSNames {
+			if strings.EqualFold(name, host) {
+				return true
+			}
+		}
+		return false
+	}
+	return cert.VerifyHostname(host) == nil
+}
+
+// CertificateStatus holds the outcome of the last certificate check.
+type CertificateStatus struct {
+	// LastCheck is the time of the last check.
+	LastCheck time.Time
+
+	// Certificate is the host certificate on Kong after the last check.
+	Certificate *CertificateInfo
+
+	// Rotated signals whether the certificate was uploaded (or replaced) by the last check.
+	Rotated bool
+
+	// Error is the error of the last check, or nil if it was successful.
+	Error error
+}
+
+// CertificateRotator periodically uploads the host certificate of the microservice from the certificate and
+// key files to Kong (see KongGateway.UploadHostCertificate), so a renewed certificate (for example by
+// cert-manager) is rotated on Kong without downtime. A certificate that expires within RenewBefore is reported
+// as an error, so the failed renewal is detected before the certificate expires.
+type CertificateRotator struct {
+	// Gateway is the Kong gateway of the microservice.
+	Gateway *KongGateway
+
+	// CertFile is the location of the PEM encoded host certificate.
+	CertFile string
+
+	// KeyFile is the location of the PEM encoded private key of the host certificate.
+	KeyFile string
+
+	// RenewBefore is the time before the expiry of the certificate from which the certificate is reported as expiring.
+	RenewBefore time.Duration
+
+	// Interval is the time between two checks.
+	Interval time.Duration
+
+	mutex  sync.RWMutex
+	status CertificateStatus
+}
+
+// NewCertificateRotator creates a CertificateRotator for the given Kong gateway and certificate files,
+// that checks the certificate on the given interval.
+func NewCertificateRotator(kong *KongGateway, certFile, keyFile string, interval time.Duration) *CertificateRotator {
+	return &CertificateRotator{
+		Gateway:  kong,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Interval: interval,
+	}
+}
+
+// Run checks the certificate immediately and then on every interval, until the context is done.
+// Returns an error if the Interval is not positive, or the context error once the context is done.
+func (r *CertificateRotator) Run(ctx context.Context) error {
+	return runEvery(ctx, r.Interval, func(ctx context.Context) {
+		r.Check(ctx)
+	})
+}
+
+// Check uploads the certificate from the files, if it differs from the certificate on Kong, and records the status.
+func (r *CertificateRotator) Check(ctx context.Context) (*CertificateInfo, error) {
+	previous, err := r.Gateway.hostCertificates(ctx)
+	var info *CertificateInfo
+	if err == nil {
+		info, err = r.Gateway.UploadHostCertificateFiles(ctx, r.CertFile, r.KeyFile)
+	}
+	if err == nil && info.ExpiresWithin(r.RenewBefore) {
+		err = fmt.Errorf("certificate %s expires on %s", info.Subject, info.NotAfter.Format(time.RFC3339))
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.status = CertificateStatus{
+		LastCheck:   time.Now(),
+		Certificate: info,
+		Error:       err,
+	}
+	if info != nil {
+		r.status.Rotated = len(previous) != 1 || previous[0].ID != info.ID
+	}
+	return info, err
+}
+
+// Status returns the status of the last check.
+func (r *CertificateRotator) Status() CertificateStatus {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.status
+}
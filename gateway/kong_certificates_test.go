@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+// newHostCertificate generates a self-signed PEM encoded certificate for the given host names, valid for the
+// given duration, and its PEM encoded private key.
+func newHostCertificate(t *testing.T, validFor time.Duration, names ...string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// sniCertificates returns the ID of the certificate bound to every SNI on the fake Kong.
+func sniCertificates(kong *kongtest.Server) map[string]string {
+	bound := map[string]string{}
+	for _, sni := range kong.List("snis") {
+		bound[sni["name"].(string)] = sni["certificate"].(map[string]interface{})["id"].(string)
+	}
+	return bound
+}
+
+func TestUploadHostCertificate(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	ctx := context.Background()
+
+	cert, key := newHostCertificate(t, 24*time.Hour, "user.api.jormugandr.org", "localhost")
+	first, err := gateway.UploadHostCertificate(ctx, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound := sniCertificates(kong)
+	if len(bound) != 2 || bound["localhost"] != first.ID || bound["user.api.jormugandr.org"] != first.ID {
+		t.Fatalf("Expected the hosts to be bound to the certificate %s, got %v", first.ID, bound)
+	}
+
+	requests := len(kong.Requests())
+	if _, err := gateway.UploadHostCertificate(ctx, cert, key); err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range kong.Requests()[requests:] {
+		if !strings.HasPrefix(request, "GET ") {
+			t.Fatalf("Expected no changes for the same certificate, got %s", request)
+		}
+	}
+
+	// Rotate to a new certificate with one of the hosts removed.
+	config.Hosts = []string{"localhost"}
+	cert, key = newHostCertificate(t, 24*time.Hour, "localhost")
+	second, err := gateway.UploadHostCertificate(ctx, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID == first.ID {
+		t.Fatal("Expected a new certificate")
+	}
+	if certificates := kong.List("certificates"); len(certificates) != 1 || certificates[0]["id"] != second.ID {
+		t.Fatalf("Expected the previous certificate to be deleted, got %v", certificates)
+	}
+	if bound := sniCertificates(kong); len(bound) != 1 || bound["localhost"] != second.ID {
+		t.Fatalf("Expected only localhost to be bound to the new certificate, got %v", bound)
+	}
+
+	expiring, err := gateway.ExpiringCertificates(ctx, 48*time.Hour)
+	if err != nil || len(expiring) != 1 || expiring[0].ID != second.ID || expiring[0].SNIs[0] != "localhost" {
+		t.Fatalf("Expected the certificate to expire within 48h, got %v (%v)", expiring, err)
+	}
+	if expiring, _ := gateway.ExpiringCertificates(ctx, time.Hour); len(expiring) != 0 {
+		t.Fatalf("Expected no certificates to expire within an hour, got %v", expiring)
+	}
+
+	cert, key = newHostCertificate(t, 24*time.Hour, "example.org")
+	if _, err := gateway.UploadHostCertificate(ctx, cert, key); err == nil {
+		t.Fatal("Expected an error for a certificate that is not valid for the hosts")
+	}
+	cert, key = newHostCertificate(t, -time.Minute, "localhost")
+	if _, err := gateway.UploadHostCertificate(ctx, cert, key); err == nil {
+		t.Fatal("Expected an error for an expired certificate")
+	}
+
+	config.KongMode = KongModeAPIs
+	if _, err := gateway.UploadHostCertificate(ctx, cert, key); err == nil {
+		t.Fatal("Expected an error in the apis Kong mode")
+	}
+}
+
+func TestUploadHostCertificateRouteHosts(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newRoutesConfig()
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	ctx := context.Background()
+
+	cert, key := newHostCertificate(t, 24*time.Hour, "user.api.jormugandr.org", "localhost")
+	if _, err := gateway.UploadHostCertificate(ctx, cert, key); err == nil || !strings.Contains(err.Error(), "users.internal") {
+		t.Fatalf("Expected an error for the host of the internal route, got %v", err)
+	}
+
+	cert, key = newHostCertificate(t, 24*time.Hour, "user.api.jormugandr.org", "localhost", "users.internal")
+	info, err := gateway.UploadHostCertificate(ctx, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound := sniCertificates(kong)
+	if len(bound) != 3 || bound["users.internal"] != info.ID {
+		t.Fatalf("Expected the hosts of the routes to be bound to the certificate %s, got %v", info.ID, bound)
+	}
+}
+
+func TestCertificateRotator(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	dir, err := ioutil.TempDir("", "host-certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := HostCertificateFiles(dir)
+	writeFiles := func(validFor time.Duration) {
+		cert, key := newHostCertificate(t, validFor, "localhost", "user.api.jormugandr.org")
+		if err := ioutil.WriteFile(certFile, cert, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gateway := NewKongGateway(kong.URL, &http.Client{}, newServicesModeConfig())
+	rotator := NewCertificateRotator(gateway, certFile, keyFile, time.Minute)
+	rotator.RenewBefore = 24 * time.Hour
+
+	writeFiles(30 * 24 * time.Hour)
+	if _, err := rotator.Check(context.Background()); err != nil || !rotator.Status().Rotated {
+		t.Fatalf("Expected the certificate to be uploaded, got %+v", rotator.Status())
+	}
+	if _, err := rotator.Check(context.Background()); err != nil || rotator.Status().Rotated {
+		t.Fatalf("Expected the certificate not to change, got %+v", rotator.Status())
+	}
+
+	writeFiles(time.Hour)
+	info, err := rotator.Check(context.Background())
+	if err == nil {
+		t.Fatal("Expected an error for a certificate that expires within RenewBefore")
+	}
+	if status := rotator.Status(); !status.Rotated || status.Certificate == nil || status.Certificate.ID != info.ID {
+		t.Fatalf("Expected the certificate to be rotated anyway, got %+v", status)
+	}
+}
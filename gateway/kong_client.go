@@ -0,0 +1,284 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KongError is an error response returned by the Kong Admin API.
+type KongError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Status is the HTTP status line of the response.
+	Status string
+
+	// Name is the name of the error as reported by Kong 1.x and newer, for example "unique constraint violation".
+	Name string
+
+	// Message is the error message reported by Kong.
+	Message string
+
+	// Fields holds the validation errors per field, as reported by Kong.
+	Fields map[string]interface{}
+}
+
+// Error returns the error message.
+func (e *KongError) Error() string {
+	msg := e.Status
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+	if len(e.Fields) > 0 {
+		fields, _ := json.Marshal(e.Fields)
+		msg = fmt.Sprintf("%s %s", msg, fields)
+	}
+	return msg
+}
+
+// IsNotFound checks whether Kong reported that the object does not exist.
+func (e *KongError) IsNotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// IsConflict checks whether Kong reported a conflict with an existing object (unique constraint violation).
+func (e *KongError) IsConflict() bool {
+	return e.StatusCode == http.StatusConflict
+}
+
+// IsValidation checks whether Kong rejected the object because of invalid or missing values.
+func (e *KongError) IsValidation() bool {
+	return e.StatusCode == http.StatusBadRequest
+}
+
+// IsTemporary checks whether the error is a temporary outage of Kong and the request may be retried.
+func (e *KongError) IsTemporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// newKongError reads the error response from Kong into a KongError.
+// Kong 0.x reports validation errors as a map of field name to message, while Kong 1.x and newer
+// report a message and a 'fields' object.
+func newKongError(resp *http.Response) *KongError {
+	kongErr := &KongError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil || len(data) == 0 {
+		return kongErr
+	}
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		kongErr.Message = strings.TrimSpace(string(data))
+		return kongErr
+	}
+	if message, ok := body["message"]; ok {
+		kongErr.Message = fmt.Sprintf("%v", message)
+	}
+	if _, ok := body["code"]; ok {
+		// Kong 1.x and newer
+		if name, ok := body["name"]; ok {
+			kongErr.Name = fmt.Sprintf("%v", name)
+		}
+		if fields, ok := body["fields"].(map[string]interface{}); ok {
+			kongErr.Fields = fields
+		}
+		return kongErr
+	}
+	// Kong 0.x
+	for key, value := range body {
+		if key == "message" {
+			continue
+		}
+		if kongErr.Fields == nil {
+			kongErr.Fields = map[string]interface{}{}
+		}
+		kongErr.Fields[key] = value
+	}
+	return kongErr
+}
+
+// isNotFound checks whether the error is a Kong "404 Not Found" error.
+func isNotFound(err error) bool {
+	var kongErr *KongError
+	return errors.As(err, &kongErr) && kongErr.IsNotFound()
+}
+
+// isConflict checks whether the error is a Kong "409 Conflict" error.
+func isConflict(err error) bool {
+	var kongErr *KongError
+	return errors.As(err, &kongErr) && kongErr.IsConflict()
+}
+
+// request sends a request to the Kong Admin API and decodes the response into result, if result is not nil.
+// The body is sent form encoded if it is url.Values, or encoded as JSON otherwise.
+// Non-2xx responses are returned as *KongError.
+func (kong *KongGateway) request(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case url.Values:
+		reader = strings.NewReader(b.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, kong.getKongURL(path), reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if kong.Admin != nil {
+		if err := kong.Admin.setHeaders(req); err != nil {
+			return err
+		}
+	}
+
+	kong.emit(ctx, Event{Type: EventRequest, Method: method, Path: path})
+	start := time.Now()
+	resp, err := kong.client.Do(req)
+	if err != nil {
+		kong.emit(ctx, Event{Type: EventResponse, Method: method, Path: path, Duration: time.Since(start), Err: err})
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		kongErr := newKongError(resp)
+		kong.emit(ctx, Event{Type: EventResponse, Method: method, Path: path, StatusCode: resp.StatusCode, Duration: time.Since(start), Err: kongErr})
+		return kongErr
+	}
+	kong.emit(ctx, Event{Type: EventResponse, Method: method, Path: path, StatusCode: resp.StatusCode, Duration: time.Since(start)})
+	kong.emitChange(ctx, method, path, resp.StatusCode)
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// listAll retrieves all pages of a Kong list endpoint, following the 'next' links, and decodes the
+// entities into items, which must be a pointer to a slice.
+func (kong *KongGateway) listAll(ctx context.Context, path string, items interface{}) error {
+	all := []json.RawMessage{}
+	for path != "" {
+		var page struct {
+			Data []json.RawMessage `json:"data"`
+			Next *string           `json:"next"`
+		}
+		if err := kong.request(ctx, "GET", path, nil, &page); err != nil {
+			return err
+		}
+		all = append(all, page.Data...)
+		path = ""
+		if page.Next != nil {
+			// Kong 0.x returns the full URL, Kong 1.x and newer return the path (with the workspace)
+			path = strings.TrimPrefix(strings.TrimPrefix(*page.Next, kong.GatewayURL), "/")
+			if kong.Admin != nil && kong.Admin.Workspace != "" {
+				path = strings.TrimPrefix(path, kong.Admin.Workspace+"/")
+			}
+		}
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, items)
+}
+
+// RetryConfig configures the retries with exponential backoff of the context-aware registration calls.
+type RetryConfig struct {
+	// MaxAttempts is the maximal number of attempts. Zero means retry until the context is done.
+	MaxAttempts int
+
+	// InitialInterval is the wait time before the first retry.
+	InitialInterval time.Duration
+
+	// MaxInterval is the maximal wait time between two attempts.
+	MaxInterval time.Duration
+
+	// Multiplier is the factor by which the wait time grows after every attempt.
+	Multiplier float64
+}
+
+// DefaultRetryConfig is the retry configuration used when KongGateway has no Retry configuration set.
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts:     10,
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+}
+
+// backoff returns the wait time before the given retry attempt (starting from 1).
+func (r *RetryConfig) backoff(attempt int) time.Duration {
+	multiplier := r.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	wait := float64(r.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if r.MaxInterval > 0 && wait > float64(r.MaxInterval) {
+		return r.MaxInterval
+	}
+	return time.Duration(wait)
+}
+
+// isRetryable checks whether the failed call may succeed if retried. Network errors
+// (for example Kong not being up yet) and temporary Kong errors are retryable,
+// while validation errors and conflicts are not. Wrapped errors are recognized as well.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var kongErr *KongError
+	if errors.As(err, &kongErr) {
+		return kongErr.IsTemporary()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not retryable, the maximal number of
+// attempts is reached, or the context is done. The wait time between the attempts grows exponentially.
+// Every retry of the operation is reported to the Observer as EventRetry.
+func (kong *KongGateway) withRetry(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	retry := DefaultRetryConfig
+	if kong.Retry != nil {
+		retry = *kong.Retry
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if (retry.MaxAttempts > 0 && attempt >= retry.MaxAttempts) || ctx.Err() != nil {
+			return err
+		}
+		wait := retry.backoff(attempt)
+		kong.emit(ctx, Event{Type: EventRetry, Operation: operation, Attempt: attempt, Duration: wait, Err: err})
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
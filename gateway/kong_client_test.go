@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestKongErrorFromKong1Response(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Put("/services/user-microservice").
+		Reply(409).
+		JSON(map[string]interface{}{
+			"code":    5,
+			"name":    "unique constraint violation",
+			"message": "UNIQUE violation detected on '{name=\"user-microservice\"}'",
+			"fields": map[string]interface{}{
+				"name": "user-microservice",
+			},
+		})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, newServicesModeConfig())
+
+	_, err := gateway.createOrUpdateService(context.Background(), gateway.desiredService())
+	kongErr, ok := err.(*KongError)
+	if !ok {
+		t.Fatalf("Expected *KongError, got %v", err)
+	}
+	if !kongErr.IsConflict() || kongErr.IsTemporary() {
+		t.Fatalf("Expected a conflict error, got %d", kongErr.StatusCode)
+	}
+	if kongErr.Name != "unique constraint violation" || kongErr.Fields["name"] != "user-microservice" {
+		t.Fatalf("Kong error body not parsed: %+v", kongErr)
+	}
+}
+
+func TestKongErrorFromKong0Response(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Post("/upstreams/").
+		Reply(400).
+		JSON(map[string]interface{}{
+			"slots": "number of slots must be between 10 and 65536",
+		})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, newServicesModeConfig())
+
+	_, err := gateway.createUpstreamObj(context.Background(), &upstream{Name: "user.api.jormugandr.org", Slots: 1})
+	kongErr, ok := err.(*KongError)
+	if !ok {
+		t.Fatalf("Expected *KongError, got %v", err)
+	}
+	if !kongErr.IsValidation() {
+		t.Fatalf("Expected a validation error, got %d", kongErr.StatusCode)
+	}
+	if kongErr.Fields["slots"] != "number of slots must be between 10 and 65536" {
+		t.Fatalf("Kong error body not parsed: %+v", kongErr)
+	}
+}
+
+func TestSelfRegisterContextRetriesOnOutage(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(503).
+		JSON(map[string]string{"message": "Service Unavailable"})
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"name":  "user.api.jormugandr.org",
+			"slots": 10,
+			"tags":  []string{"user-microservice"},
+		})
+
+	gock.New("http://kong:8001").
+		Put("/services/user-microservice").
+		Reply(200).
+		JSON(map[string]interface{}{"name": "user-microservice"})
+
+	gock.New("http://kong:8001").
+		Put("/services/user-microservice/routes/user-microservice").
+		Reply(200).
+		JSON(map[string]interface{}{"name": "user-microservice"})
+
+	gock.New("http://kong:8001").
+		Post("/upstreams/user.api.jormugandr.org/targets").
+		Reply(201).
+		JSON(map[string]interface{}{"weight": 10})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, newServicesModeConfig())
+	gateway.Retry = &RetryConfig{
+		MaxAttempts:     3,
+		InitialInterval: time.Millisecond,
+	}
+
+	if err := gateway.SelfRegisterContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if gock.IsPending() {
+		for _, mock := range gock.GetAll() {
+			t.Error("Mock:", mock.Request())
+		}
+		t.Fatal("Expected all HTTP calls to be made")
+	}
+}
+
+func TestSelfRegisterContextDoesNotRetryValidationErrors(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(400).
+		JSON(map[string]string{"message": "bad request"})
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(200).
+		JSON(map[string]interface{}{"name": "user.api.jormugandr.org"})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, newServicesModeConfig())
+	gateway.Retry = &RetryConfig{
+		MaxAttempts:     3,
+		InitialInterval: time.Millisecond,
+	}
+
+	err := gateway.SelfRegisterContext(context.Background())
+	if kongErr, ok := err.(*KongError); !ok || !kongErr.IsValidation() {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	if len(gock.GetAll()) != 1 {
+		t.Fatal("Expected the registration not to be retried")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	retry := RetryConfig{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+	}
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+	}
+	for i, wait := range expected {
+		if backoff := retry.backoff(i + 1); backoff != wait {
+			t.Errorf("Attempt %d: expected %s, got %s", i+1, wait, backoff)
+		}
+	}
+}
+
+func TestIsRetryableWrappedErrors(t *testing.T) {
+	networkErr := &url.Error{Op: "Get", URL: "http://kong:8001/", Err: errors.New("connection refused")}
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{fmt.Errorf("upstream: %w", &KongError{StatusCode: 503}), true},
+		{fmt.Errorf("upstream: %w", &KongError{StatusCode: 409}), false},
+		{fmt.Errorf("upstream: %w", networkErr), true},
+		{fmt.Errorf("upstream: %w", context.DeadlineExceeded), false},
+		{&url.Error{Op: "Get", URL: "http://kong:8001/", Err: context.Canceled}, false},
+	}
+	for _, c := range cases {
+		if retryable := isRetryable(c.err); retryable != c.retryable {
+			t.Errorf("%v: expected retryable %v, got %v", c.err, c.retryable, retryable)
+		}
+	}
+	if !isConflict(fmt.Errorf("target: %w", &KongError{StatusCode: 409})) {
+		t.Error("Expected a wrapped conflict to be recognized")
+	}
+}
+
+func TestListAllFollowsNextPage(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Get("/consumers/john/acls").
+		MatchParam("offset", "page-2").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{{"id": "2", "group": "users"}},
+			"next": nil,
+		})
+
+	gock.New("http://kong:8001").
+		Get("/consumers/john/acls").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{{"id": "1", "group": "admin"}},
+			"next": "/consumers/john/acls?offset=page-2",
+		})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, &MicroserviceConfig{})
+
+	groups, err := gateway.ACLGroups(context.Background(), "john")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Group != "admin" || groups[1].Group != "users" {
+		t.Fatalf("Expected the groups from both pages, got %+v", groups)
+	}
+}
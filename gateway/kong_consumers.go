@@ -0,0 +1,346 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Consumer is a structure that represents Kong's Consumer object.
+// See https://docs.konghq.com/gateway/latest/admin-api/#consumer-object
+type Consumer struct {
+	ID        string   `json:"id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	CustomID  string   `json:"custom_id,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt int      `json:"created_at,omitempty"`
+}
+
+// ConsumerGroup is a structure that represents Kong's Consumer Group object.
+// See https://docs.konghq.com/gateway/latest/admin-api/consumer-groups/reference/
+type ConsumerGroup struct {
+	ID        string   `json:"id,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt int      `json:"created_at,omitempty"`
+}
+
+// JWTCredential is a structure that represents a JWT credential of a Kong consumer (jwt plugin).
+// See https://docs.konghq.com/hub/kong-inc/jwt/
+type JWTCredential struct {
+	ID string `json:"id,omitempty"`
+
+	// Key is the unique key of the credential, matched against the 'iss' claim of the JWT by default.
+	Key string `json:"key,omitempty"`
+
+	// Secret is the secret used to verify the HS256, HS384 and HS512 signatures.
+	Secret string `json:"secret,omitempty"`
+
+	// Algorithm is the signing algorithm, for example "HS256" or "RS256".
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// RSAPublicKey is the PEM encoded public key used to verify the RS256, RS384, RS512 and ES256 signatures.
+	RSAPublicKey string `json:"rsa_public_key,omitempty"`
+
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt int      `json:"created_at,omitempty"`
+}
+
+// KeyAuthCredential is a structure that represents a key-auth credential (API key) of a Kong consumer.
+// See https://docs.konghq.com/hub/kong-inc/key-auth/
+type KeyAuthCredential struct {
+	ID        string   `json:"id,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt int      `json:"created_at,omitempty"`
+}
+
+// ACLGroup is a structure that represents the membership of a Kong consumer in an ACL group (acl plugin).
+// See https://docs.konghq.com/hub/kong-inc/acl/
+type ACLGroup struct {
+	ID        string   `json:"id,omitempty"`
+	Group     string   `json:"group,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	CreatedAt int      `json:"created_at,omitempty"`
+}
+
+// GetConsumer retrieves the consumer with the given username or ID from Kong.
+// Returns nil if there is no such consumer.
+func (kong *KongGateway) GetConsumer(ctx context.Context, usernameOrID string) (*Consumer, error) {
+	var consumer Consumer
+	err := kong.request(ctx, "GET", consumerPath(usernameOrID), nil, &consumer)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &consumer, nil
+}
+
+// UpsertConsumer creates the consumer on Kong if there is no consumer with the same username, or updates
+// the existing consumer if its custom ID or tags differ. Returns the consumer from Kong.
+func (kong *KongGateway) UpsertConsumer(ctx context.Context, consumerConf *Consumer) (*Consumer, error) {
+	if consumerConf.Username == "" {
+		return nil, fmt.Errorf("consumer username is required")
+	}
+	existing, err := kong.GetConsumer(ctx, consumerConf.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	var consumer Consumer
+	if existing == nil {
+		desired := *consumerConf
+		desired.ID = ""
+		if err := kong.request(ctx, "POST", "consumers/", &desired, &consumer); err != nil {
+			return nil, err
+		}
+		return &consumer, nil
+	}
+
+	if (consumerConf.CustomID == "" || consumerConf.CustomID == existing.CustomID) &&
+		(consumerConf.Tags == nil || sameStrings(consumerConf.Tags, existing.Tags)) {
+		return existing, nil
+	}
+	update := map[string]interface{}{}
+	if consumerConf.CustomID != "" {
+		update["custom_id"] = consumerConf.CustomID
+	}
+	if consumerConf.Tags != nil {
+		update["tags"] = consumerConf.Tags
+	}
+	if err := kong.request(ctx, "PATCH", consumerPath(existing.ID), update, &consumer); err != nil {
+		return nil, err
+	}
+	return &consumer, nil
+}
+
+// DeleteConsumer deletes the consumer with the given username or ID, together with its credentials.
+// It is not an error if the consumer does not exist.
+func (kong *KongGateway) DeleteConsumer(ctx context.Context, usernameOrID string) error {
+	return kong.deleteEntity(ctx, consumerPath(usernameOrID))
+}
+
+// UpsertConsumerGroup creates the consumer group on Kong if there is no group with the same name.
+// Returns the consumer group from Kong.
+func (kong *KongGateway) UpsertConsumerGroup(ctx context.Context, groupConf *ConsumerGroup) (*ConsumerGroup, error) {
+	if groupConf.Name == "" {
+		return nil, fmt.Errorf("consumer group name is required")
+	}
+	var group ConsumerGroup
+	err := kong.request(ctx, "GET", fmt.Sprintf("consumer_groups/%s", url.PathEscape(groupConf.Name)), nil, &group)
+	if err == nil {
+		return &group, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+	desired := *groupConf
+	desired.ID = ""
+	if err := kong.request(ctx, "POST", "consumer_groups/", &desired, &group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// ConsumerGroups retrieves the consumer groups of the consumer.
+func (kong *KongGateway) ConsumerGroups(ctx context.Context, consumer string) ([]ConsumerGroup, error) {
+	groups := []ConsumerGroup{}
+	if err := kong.listAll(ctx, fmt.Sprintf("%s/consumer_groups", consumerPath(consumer)), &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// AddConsumerToGroup adds the consumer to the consumer group, if it is not already a member of the group.
+func (kong *KongGateway) AddConsumerToGroup(ctx context.Context, consumer, group string) error {
+	groups, err := kong.ConsumerGroups(ctx, consumer)
+	if err != nil {
+		return err
+	}
+	for _, existing := range groups {
+		if existing.Name == group || existing.ID == group {
+			return nil
+		}
+	}
+	return kong.request(ctx, "POST", fmt.Sprintf("%s/consumer_groups", consumerPath(consumer)), map[string]string{"group": group}, nil)
+}
+
+// RemoveConsumerFromGroup removes the consumer from the consumer group.
+// It is not an error if the consumer is not a member of the group.
+func (kong *KongGateway) RemoveConsumerFromGroup(ctx context.Context, consumer, group string) error {
+	return kong.deleteEntity(ctx, fmt.Sprintf("%s/consumer_groups/%s", consumerPath(consumer), url.PathEscape(group)))
+}
+
+// JWTCredentials retrieves the JWT credentials of the consumer.
+func (kong *KongGateway) JWTCredentials(ctx context.Context, consumer string) ([]JWTCredential, error) {
+	credentials := []JWTCredential{}
+	if err := kong.listAll(ctx, fmt.Sprintf("%s/jwt", consumerPath(consumer)), &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// UpsertJWTCredential creates the JWT credential for the consumer if the consumer has no credential with the
+// same key, or updates the existing credential if its secret, algorithm or public key differ.
+// Returns the credential from Kong.
+func (kong *KongGateway) UpsertJWTCredential(ctx context.Context, consumer string, credentialConf *JWTCredential) (*JWTCredential, error) {
+	if credentialConf.Key == "" {
+		return nil, fmt.Errorf("JWT credential key is required")
+	}
+	credentials, err := kong.JWTCredentials(ctx, consumer)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := *credentialConf
+	desired.ID = ""
+	path := fmt.Sprintf("%s/jwt", consumerPath(consumer))
+	var credential JWTCredential
+
+	for _, existing := range credentials {
+		if existing.Key != credentialConf.Key {
+			continue
+		}
+		if (desired.Secret == "" || desired.Secret == existing.Secret) &&
+			(desired.Algorithm == "" || desired.Algorithm == existing.Algorithm) &&
+			(desired.RSAPublicKey == "" || desired.RSAPublicKey == existing.RSAPublicKey) {
+			return &existing, nil
+		}
+		if err := kong.request(ctx, "PATCH", fmt.Sprintf("%s/%s", path, existing.ID), &desired, &credential); err != nil {
+			return nil, err
+		}
+		return &credential, nil
+	}
+
+	if err := kong.request(ctx, "POST", path, &desired, &credential); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// DeleteJWTCredential deletes the JWT credential with the given key or ID from the consumer.
+// It is not an error if the credential does not exist.
+func (kong *KongGateway) DeleteJWTCredential(ctx context.Context, consumer, keyOrID string) error {
+	credentials, err := kong.JWTCredentials(ctx, consumer)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, credential := range credentials {
+		if credential.Key == keyOrID || credential.ID == keyOrID {
+			return kong.deleteEntity(ctx, fmt.Sprintf("%s/jwt/%s", consumerPath(consumer), credential.ID))
+		}
+	}
+	return nil
+}
+
+// KeyAuthCredentials retrieves the key-auth credentials (API keys) of the consumer.
+func (kong *KongGateway) KeyAuthCredentials(ctx context.Context, consumer string) ([]KeyAuthCredential, error) {
+	credentials := []KeyAuthCredential{}
+	if err := kong.listAll(ctx, fmt.Sprintf("%s/key-auth", consumerPath(consumer)), &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// UpsertKeyAuthCredential creates the key-auth credential for the consumer if the consumer has no credential
+// with the same key. Returns the credential from Kong.
+func (kong *KongGateway) UpsertKeyAuthCredential(ctx context.Context, consumer string, credentialConf *KeyAuthCredential) (*KeyAuthCredential, error) {
+	if credentialConf.Key == "" {
+		return nil, fmt.Errorf("key-auth credential key is required")
+	}
+	credentials, err := kong.KeyAuthCredentials(ctx, consumer)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range credentials {
+		if existing.Key == credentialConf.Key {
+			return &existing, nil
+		}
+	}
+
+	desired := *credentialConf
+	desired.ID = ""
+	var credential KeyAuthCredential
+	if err := kong.request(ctx, "POST", fmt.Sprintf("%s/key-auth", consumerPath(consumer)), &desired, &credential); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// DeleteKeyAuthCredential deletes the key-auth credential with the given key or ID from the consumer.
+// It is not an error if the credential does not exist.
+func (kong *KongGateway) DeleteKeyAuthCredential(ctx context.Context, consumer, keyOrID string) error {
+	credentials, err := kong.KeyAuthCredentials(ctx, consumer)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, credential := range credentials {
+		if credential.Key == keyOrID || credential.ID == keyOrID {
+			return kong.deleteEntity(ctx, fmt.Sprintf("%s/key-auth/%s", consumerPath(consumer), credential.ID))
+		}
+	}
+	return nil
+}
+
+// ACLGroups retrieves the ACL groups of the consumer.
+func (kong *KongGateway) ACLGroups(ctx context.Context, consumer string) ([]ACLGroup, error) {
+	groups := []ACLGroup{}
+	if err := kong.listAll(ctx, fmt.Sprintf("%s/acls", consumerPath(consumer)), &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// AddConsumerToACLGroup adds the consumer to the ACL group, if it is not already a member of the group.
+func (kong *KongGateway) AddConsumerToACLGroup(ctx context.Context, consumer, group string) error {
+	groups, err := kong.ACLGroups(ctx, consumer)
+	if err != nil {
+		return err
+	}
+	for _, existing := range groups {
+		if existing.Group == group {
+			return nil
+		}
+	}
+	return kong.request(ctx, "POST", fmt.Sprintf("%s/acls", consumerPath(consumer)), &ACLGroup{Group: group}, nil)
+}
+
+// RemoveConsumerFromACLGroup removes the consumer from the ACL group.
+// It is not an error if the consumer is not a member of the group.
+func (kong *KongGateway) RemoveConsumerFromACLGroup(ctx context.Context, consumer, group string) error {
+	groups, err := kong.ACLGroups(ctx, consumer)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, existing := range groups {
+		if existing.Group == group {
+			return kong.deleteEntity(ctx, fmt.Sprintf("%s/acls/%s", consumerPath(consumer), existing.ID))
+		}
+	}
+	return nil
+}
+
+// deleteEntity deletes the entity with the given Admin API path. It is not an error if the entity does not exist.
+func (kong *KongGateway) deleteEntity(ctx context.Context, path string) error {
+	err := kong.request(ctx, "DELETE", path, nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// consumerPath returns the Admin API path of the consumer with the given username or ID.
+func consumerPath(usernameOrID string) string {
+	return fmt.Sprintf("consumers/%s", url.PathEscape(usernameOrID))
+}
@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+func TestUpsertConsumer(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	gateway := NewKongGateway(kong.URL, &http.Client{}, &MicroserviceConfig{})
+	ctx := context.Background()
+
+	created, err := gateway.UpsertConsumer(ctx, &Consumer{Username: "john", CustomID: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.Username != "john" {
+		t.Fatalf("Unexpected consumer: %+v", created)
+	}
+
+	same, err := gateway.UpsertConsumer(ctx, &Consumer{Username: "john", CustomID: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.ID != created.ID || len(kong.List("consumers")) != 1 {
+		t.Fatal("Expected the existing consumer to be reused")
+	}
+
+	updated, err := gateway.UpsertConsumer(ctx, &Consumer{Username: "john", CustomID: "user-2", Tags: []string{"jwt-issuer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ID != created.ID || updated.CustomID != "user-2" || len(updated.Tags) != 1 {
+		t.Fatalf("Expected the consumer to be updated, got %+v", updated)
+	}
+
+	if _, err := gateway.UpsertConsumer(ctx, &Consumer{CustomID: "user-3"}); err == nil {
+		t.Fatal("Expected an error when the username is missing")
+	}
+
+	if err := gateway.DeleteConsumer(ctx, "john"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gateway.DeleteConsumer(ctx, "john"); err != nil {
+		t.Fatal("Expected no error when deleting a missing consumer", err)
+	}
+	if consumer, err := gateway.GetConsumer(ctx, "john"); err != nil || consumer != nil {
+		t.Fatalf("Expected no consumer, got %v, %v", consumer, err)
+	}
+}
+
+func TestConsumerCredentials(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	gateway := NewKongGateway(kong.URL, &http.Client{}, &MicroserviceConfig{})
+	ctx := context.Background()
+
+	if _, err := gateway.UpsertConsumer(ctx, &Consumer{Username: "jwt-issuer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	jwt, err := gateway.UpsertJWTCredential(ctx, "jwt-issuer", &JWTCredential{Key: "issuer", Algorithm: "RS256", RSAPublicKey: "key-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := gateway.UpsertJWTCredential(ctx, "jwt-issuer", &JWTCredential{Key: "issuer", Algorithm: "RS256", RSAPublicKey: "key-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ID != jwt.ID || rotated.RSAPublicKey != "key-2" {
+		t.Fatalf("Expected the JWT credential to be updated, got %+v", rotated)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := gateway.UpsertKeyAuthCredential(ctx, "jwt-issuer", &KeyAuthCredential{Key: "secret-api-key"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := gateway.AddConsumerToACLGroup(ctx, "jwt-issuer", "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(kong.Children("consumers", "jwt-issuer", "jwt")) != 1 ||
+		len(kong.Children("consumers", "jwt-issuer", "key-auth")) != 1 ||
+		len(kong.Children("consumers", "jwt-issuer", "acls")) != 1 {
+		t.Fatal("Expected exactly one credential of each kind")
+	}
+
+	if err := gateway.RemoveConsumerFromACLGroup(ctx, "jwt-issuer", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gateway.DeleteKeyAuthCredential(ctx, "jwt-issuer", "secret-api-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gateway.DeleteJWTCredential(ctx, "jwt-issuer", "issuer"); err != nil {
+		t.Fatal(err)
+	}
+	if len(kong.List("jwt"))+len(kong.List("key-auth"))+len(kong.List("acls")) != 0 {
+		t.Fatal("Expected all credentials to be removed")
+	}
+}
+
+func TestConsumerGroups(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	gateway := NewKongGateway(kong.URL, &http.Client{}, &MicroserviceConfig{})
+	ctx := context.Background()
+
+	if _, err := gateway.UpsertConsumer(ctx, &Consumer{Username: "john"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := gateway.UpsertConsumerGroup(ctx, &ConsumerGroup{Name: "premium"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := gateway.AddConsumerToGroup(ctx, "john", "premium"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := gateway.ConsumerGroups(ctx, "john")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "premium" {
+		t.Fatalf("Expected the consumer to be in the premium group, got %+v", groups)
+	}
+
+	if err := gateway.RemoveConsumerFromGroup(ctx, "john", "premium"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gateway.RemoveConsumerFromGroup(ctx, "john", "premium"); err != nil {
+		t.Fatal("Expected no error when the consumer is not in the group", err)
+	}
+	if groups, _ := gateway.ConsumerGroups(ctx, "john"); len(groups) != 0 {
+		t.Fatalf("Expected no groups, got %+v", groups)
+	}
+}
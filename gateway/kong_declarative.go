@@ -0,0 +1,412 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DeclarativeEntity is a Kong entity in the declarative configuration. The entities that belong to it are nested
+// in it by the collection name, for example the "routes" and "plugins" of a service, or the "targets" of an upstream.
+type DeclarativeEntity map[string]interface{}
+
+// DeclarativeConfig is the Kong declarative configuration, the format of the kong.yml file used to bootstrap
+// DB-less Kong.
+// See https://docs.konghq.com/gateway/latest/production/deployment-topologies/db-less-and-declarative-config/
+type DeclarativeConfig struct {
+	FormatVersion string              `yaml:"_format_version" json:"_format_version"`
+	Services      []DeclarativeEntity `yaml:"services,omitempty" json:"services,omitempty"`
+	Upstreams     []DeclarativeEntity `yaml:"upstreams,omitempty" json:"upstreams,omitempty"`
+	Consumers     []DeclarativeEntity `yaml:"consumers,omitempty" json:"consumers,omitempty"`
+
+	// Certificates and CACertificates keep their IDs, because the Services reference them by ID.
+	Certificates   []DeclarativeEntity `yaml:"certificates,omitempty" json:"certificates,omitempty"`
+	CACertificates []DeclarativeEntity `yaml:"ca_certificates,omitempty" json:"ca_certificates,omitempty"`
+}
+
+// consumerCredentials maps the collections of the consumer credentials in the declarative configuration
+// to the Admin API endpoints and the field by which the credential is matched.
+var consumerCredentials = []struct {
+	collection string
+	endpoint   string
+	key        string
+}{
+	{collection: "jwt_secrets", endpoint: "jwt", key: "key"},
+	{collection: "keyauth_credentials", endpoint: "key-auth", key: "key"},
+	{collection: "acls", endpoint: "acls", key: "group"},
+}
+
+// ParseDeclarativeConfig parses the declarative configuration from YAML (or JSON).
+func ParseDeclarativeConfig(data []byte) (*DeclarativeConfig, error) {
+	config := &DeclarativeConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	for _, entities := range [][]DeclarativeEntity{config.Services, config.Upstreams, config.Consumers, config.Certificates, config.CACertificates} {
+		for i, entity := range entities {
+			entities[i] = jsonValue(map[string]interface{}(entity)).(map[string]interface{})
+		}
+	}
+	return config, nil
+}
+
+// YAML returns the declarative configuration as YAML.
+func (c *DeclarativeConfig) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+// Export reads all objects owned by this microservice from Kong (the Services, Upstreams and Consumers tagged with
+// the MicroserviceName, together with their Routes, Plugins, Targets and credentials) into a declarative configuration.
+// The certificates tagged with the MicroserviceName are exported too. The IDs (except of the certificates) and
+// timestamps are left out, so the configuration can be applied to a different Kong.
+// Export requires the services Kong mode, because tags are supported by Kong 1.1 and newer.
+func (kong *KongGateway) Export(ctx context.Context) (*DeclarativeConfig, error) {
+	if kong.config.KongMode != KongModeServices {
+		return nil, fmt.Errorf("export is supported only in the %q Kong mode", KongModeServices)
+	}
+	query := fmt.Sprintf("?tags=%s", url.QueryEscape(kong.config.MicroserviceName))
+	config := &DeclarativeConfig{FormatVersion: kong.declarativeFormatVersion(ctx)}
+
+	for _, collection := range []struct {
+		name     string
+		entities *[]DeclarativeEntity
+	}{{"certificates", &config.Certificates}, {"ca_certificates", &config.CACertificates}} {
+		certificates := []map[string]interface{}{}
+		if err := kong.listAll(ctx, collection.name+query, &certificates); err != nil {
+			return nil, err
+		}
+		for _, certificate := range certificates {
+			entity := exportEntity(certificate)
+			entity["id"] = certificate["id"]
+			*collection.entities = append(*collection.entities, entity)
+		}
+	}
+
+	services := []map[string]interface{}{}
+	if err := kong.listAll(ctx, "services"+query, &services); err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		entity := exportEntity(service)
+		routes := []map[string]interface{}{}
+		if err := kong.listAll(ctx, fmt.Sprintf("services/%s/routes", service["id"]), &routes); err != nil {
+			return nil, err
+		}
+		for _, route := range routes {
+			routeEntity := exportEntity(route, "service")
+			if err := kong.exportChildren(ctx, routeEntity, "plugins", fmt.Sprintf("routes/%s/plugins", route["id"]), "route", "service", "consumer"); err != nil {
+				return nil, err
+			}
+			appendChild(entity, "routes", routeEntity)
+		}
+		if err := kong.exportChildren(ctx, entity, "plugins", fmt.Sprintf("services/%s/plugins", service["id"]), "route", "service", "consumer"); err != nil {
+			return nil, err
+		}
+		config.Services = append(config.Services, entity)
+	}
+
+	upstreams := []map[string]interface{}{}
+	if err := kong.listAll(ctx, "upstreams"+query, &upstreams); err != nil {
+		return nil, err
+	}
+	for _, upstream := range upstreams {
+		entity := exportEntity(upstream)
+		if err := kong.exportChildren(ctx, entity, "targets", fmt.Sprintf("upstreams/%s/targets", upstream["id"]), "upstream"); err != nil {
+			return nil, err
+		}
+		config.Upstreams = append(config.Upstreams, entity)
+	}
+
+	consumers := []map[string]interface{}{}
+	if err := kong.listAll(ctx, "consumers"+query, &consumers); err != nil {
+		return nil, err
+	}
+	for _, consumer := range consumers {
+		entity := exportEntity(consumer)
+		for _, credential := range consumerCredentials {
+			path := fmt.Sprintf("consumers/%s/%s", consumer["id"], credential.endpoint)
+			if err := kong.exportChildren(ctx, entity, credential.collection, path, "consumer"); err != nil {
+				return nil, err
+			}
+		}
+		config.Consumers = append(config.Consumers, entity)
+	}
+
+	return config, nil
+}
+
+// ExportToFile exports the objects owned by this microservice into a declarative configuration YAML file.
+func (kong *KongGateway) ExportToFile(ctx context.Context, file string) error {
+	config, err := kong.Export(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := config.YAML()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, data)
+}
+
+// Import applies the declarative configuration through the Admin API. The Services, Routes, Upstreams and Consumers
+// are upserted by name (username for Consumers), the Plugins by name, the Targets by address and the credentials by
+// key (or group). The certificates are upserted by ID. Objects on Kong that are not in the configuration are left untouched.
+func (kong *KongGateway) Import(ctx context.Context, config *DeclarativeConfig) error {
+	for collection, certificates := range map[string][]DeclarativeEntity{"certificates": config.Certificates, "ca_certificates": config.CACertificates} {
+		for _, certificate := range certificates {
+			id, err := entityKey(certificate, "certificate", "id")
+			if err != nil {
+				return err
+			}
+			if err := kong.request(ctx, "PUT", fmt.Sprintf("%s/%s", collection, url.PathEscape(id)), withoutChildren(certificate, "id"), nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, upstream := range config.Upstreams {
+		name, err := entityKey(upstream, "upstream", "name")
+		if err != nil {
+			return err
+		}
+		if err := kong.request(ctx, "PUT", fmt.Sprintf("upstreams/%s", url.PathEscape(name)), withoutChildren(upstream, "targets"), nil); err != nil {
+			return err
+		}
+		if err := kong.upsertChildren(ctx, fmt.Sprintf("upstreams/%s/targets", url.PathEscape(name)), "target", children(upstream, "targets")); err != nil {
+			return err
+		}
+	}
+
+	for _, service := range config.Services {
+		name, err := entityKey(service, "service", "name")
+		if err != nil {
+			return err
+		}
+		if err := kong.request(ctx, "PUT", fmt.Sprintf("services/%s", url.PathEscape(name)), withoutChildren(service, "routes", "plugins"), nil); err != nil {
+			return err
+		}
+		for _, route := range children(service, "routes") {
+			routeName, err := entityKey(route, "route", "name")
+			if err != nil {
+				return err
+			}
+			path := fmt.Sprintf("services/%s/routes/%s", url.PathEscape(name), url.PathEscape(routeName))
+			if err := kong.request(ctx, "PUT", path, withoutChildren(route, "plugins"), nil); err != nil {
+				return err
+			}
+			if err := kong.upsertChildren(ctx, fmt.Sprintf("routes/%s/plugins", url.PathEscape(routeName)), "name", children(route, "plugins")); err != nil {
+				return err
+			}
+		}
+		if err := kong.upsertChildren(ctx, fmt.Sprintf("services/%s/plugins", url.PathEscape(name)), "name", children(service, "plugins")); err != nil {
+			return err
+		}
+	}
+
+	for _, consumer := range config.Consumers {
+		username, err := entityKey(consumer, "consumer", "username")
+		if err != nil {
+			return err
+		}
+		update := withoutChildren(consumer, "jwt_secrets", "keyauth_credentials", "acls")
+		if _, err := kong.UpsertConsumer(ctx, &Consumer{Username: username, CustomID: stringValue(update["custom_id"]), Tags: stringValues(update["tags"])}); err != nil {
+			return err
+		}
+		for _, credential := range consumerCredentials {
+			path := fmt.Sprintf("%s/%s", consumerPath(username), credential.endpoint)
+			if err := kong.upsertChildren(ctx, path, credential.key, children(consumer, credential.collection)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ImportFromFile applies the declarative configuration YAML file through the Admin API.
+func (kong *KongGateway) ImportFromFile(ctx context.Context, file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	config, err := ParseDeclarativeConfig(data)
+	if err != nil {
+		return err
+	}
+	return kong.Import(ctx, config)
+}
+
+// declarativeFormatVersion returns the format version of the declarative configuration for the version of Kong:
+// "3.0" for Kong 3.x and newer, and "1.1" otherwise.
+func (kong *KongGateway) declarativeFormatVersion(ctx context.Context) string {
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := kong.request(ctx, "GET", "", nil, &info); err == nil {
+		if major, err := strconv.Atoi(strings.SplitN(info.Version, ".", 2)[0]); err == nil && major >= 3 {
+			return "3.0"
+		}
+	}
+	return "1.1"
+}
+
+// exportChildren lists the entities from the given path, and nests them into the parent entity under the collection name.
+func (kong *KongGateway) exportChildren(ctx context.Context, parent DeclarativeEntity, collection, path string, foreignKeys ...string) error {
+	entities := []map[string]interface{}{}
+	if err := kong.listAll(ctx, path, &entities); err != nil {
+		return err
+	}
+	for _, entity := range entities {
+		appendChild(parent, collection, exportEntity(entity, foreignKeys...))
+	}
+	return nil
+}
+
+// upsertChildren updates the entities from the list endpoint with the given path, that have the same value of the
+// key field, and creates the rest of the entities.
+func (kong *KongGateway) upsertChildren(ctx context.Context, path, key string, entities []DeclarativeEntity) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	existing := []map[string]interface{}{}
+	if err := kong.listAll(ctx, path, &existing); err != nil {
+		return err
+	}
+	for _, entity := range entities {
+		id := ""
+		for _, current := range existing {
+			if current[key] == entity[key] {
+				id = stringValue(current["id"])
+			}
+		}
+		var err error
+		if id != "" {
+			err = kong.request(ctx, "PATCH", fmt.Sprintf("%s/%s", path, id), entity, nil)
+		} else {
+			err = kong.request(ctx, "POST", path, entity, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportEntity removes the IDs, timestamps, references to the parent (foreign keys) and null values from the entity.
+func exportEntity(entity map[string]interface{}, foreignKeys ...string) DeclarativeEntity {
+	result := DeclarativeEntity(withoutNulls(entity).(map[string]interface{}))
+	for _, field := range append([]string{"id", "created_at", "updated_at"}, foreignKeys...) {
+		delete(result, field)
+	}
+	return result
+}
+
+// withoutNulls removes the null values from the JSON value, recursively.
+func withoutNulls(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			if item != nil {
+				result[key] = withoutNulls(item)
+			}
+		}
+		return result
+	case []interface{}:
+		result := []interface{}{}
+		for _, item := range v {
+			result = append(result, withoutNulls(item))
+		}
+		return result
+	}
+	return value
+}
+
+// jsonValue converts the maps decoded from YAML (with interface{} keys) into JSON objects (with string keys).
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = jsonValue(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[key] = jsonValue(item)
+		}
+		return result
+	case []interface{}:
+		result := []interface{}{}
+		for _, item := range v {
+			result = append(result, jsonValue(item))
+		}
+		return result
+	}
+	return value
+}
+
+func appendChild(parent DeclarativeEntity, collection string, child DeclarativeEntity) {
+	list, _ := parent[collection].([]interface{})
+	parent[collection] = append(list, map[string]interface{}(child))
+}
+
+// children returns the entities nested in the parent entity under the collection name.
+func children(parent DeclarativeEntity, collection string) []DeclarativeEntity {
+	result := []DeclarativeEntity{}
+	list, _ := parent[collection].([]interface{})
+	for _, item := range list {
+		switch child := item.(type) {
+		case map[string]interface{}:
+			result = append(result, child)
+		case DeclarativeEntity:
+			result = append(result, child)
+		}
+	}
+	return result
+}
+
+// withoutChildren returns a copy of the entity without the nested entities.
+func withoutChildren(entity DeclarativeEntity, collections ...string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key, value := range entity {
+		result[key] = value
+	}
+	for _, collection := range collections {
+		delete(result, collection)
+	}
+	return result
+}
+
+// entityKey returns the value of the key field of the entity, or an error if it is not set.
+func entityKey(entity DeclarativeEntity, kind, field string) (string, error) {
+	key := stringValue(entity[field])
+	if key == "" {
+		return "", fmt.Errorf("%s without %s cannot be imported", kind, field)
+	}
+	return key, nil
+}
+
+func stringValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return ""
+}
+
+func stringValues(value interface{}) []string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	result := []string{}
+	for _, item := range list {
+		result = append(result, fmt.Sprintf("%v", item))
+	}
+	return result
+}
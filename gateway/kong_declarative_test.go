@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+func TestExportAndImport(t *testing.T) {
+	source := kongtest.NewServer()
+	defer source.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.Plugins = []PluginConfig{{Name: "rate-limiting", Config: map[string]interface{}{"minute": 100}}}
+	gateway := NewKongGateway(source.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	source.Add("services", kongtest.Object{"name": "other-microservice", "host": "other", "tags": []interface{}{"other-microservice"}})
+
+	dir, err := ioutil.TempDir("", "kong-declarative")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "kong.yml")
+
+	if err := gateway.ExportToFile(context.Background(), file); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := ParseDeclarativeConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.FormatVersion != "1.1" || len(exported.Services) != 1 || len(exported.Upstreams) != 1 {
+		t.Fatalf("Unexpected export:\n%s", data)
+	}
+	if strings.Contains(string(data), "created_at") || strings.Contains(string(data), "other-microservice") {
+		t.Fatalf("Expected only the owned objects without IDs and timestamps, got:\n%s", data)
+	}
+
+	target := kongtest.NewServer()
+	defer target.Close()
+	importer := NewKongGateway(target.URL, &http.Client{}, config)
+	for i := 0; i < 2; i++ {
+		if err := importer.ImportFromFile(context.Background(), file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if service := target.Get("services", "user-microservice"); service == nil || service["host"] != "user.api.jormugandr.org" {
+		t.Fatalf("Unexpected service: %v", service)
+	}
+	if route := target.Get("routes", "user-microservice"); route == nil || route["strip_path"] != true {
+		t.Fatalf("Unexpected route: %v", route)
+	}
+	plugins := target.Children("routes", "user-microservice", "plugins")
+	if len(plugins) != 1 || plugins[0]["name"] != "rate-limiting" {
+		t.Fatalf("Expected the plugin to be imported once, got %v", plugins)
+	}
+	targets := target.Children("upstreams", "user.api.jormugandr.org", "targets")
+	if len(targets) != 1 || targets[0]["target"] != "10.0.0.5:8080" {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+
+	plan, err := NewKongGateway(target.URL, &http.Client{}, config).Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.HasChanges() {
+		t.Fatalf("Expected no changes after the import, got:\n%s", plan)
+	}
+}
+
+func TestExportRequiresServicesMode(t *testing.T) {
+	gateway := NewKongGateway("http://kong:8001", &http.Client{}, &MicroserviceConfig{MicroserviceName: "users"})
+	if _, err := gateway.Export(context.Background()); err == nil {
+		t.Fatal("Expected an error in the apis Kong mode")
+	}
+}
+
+func TestImportRequiresNames(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config, err := ParseDeclarativeConfig([]byte("_format_version: \"1.1\"\nservices:\n- host: users\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := NewKongGateway(kong.URL, &http.Client{}, newServicesModeConfig())
+	if err := gateway.Import(context.Background(), config); err == nil || !strings.Contains(err.Error(), "without name") {
+		t.Fatalf("Expected an error for the service without a name, got %v", err)
+	}
+}
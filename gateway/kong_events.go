@@ -0,0 +1,186 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EventType is the type of a registration Event.
+type EventType string
+
+const (
+	// EventRequest is emitted before a request is sent to the Kong Admin API.
+	EventRequest EventType = "request"
+
+	// EventResponse is emitted when the Kong Admin API responded, or the request failed. StatusCode is zero
+	// if no response was received.
+	EventResponse EventType = "response"
+
+	// EventCreated is emitted when an object was created on Kong.
+	EventCreated EventType = "created"
+
+	// EventUpdated is emitted when an object was updated on Kong.
+	EventUpdated EventType = "updated"
+
+	// EventDeleted is emitted when an object was deleted from Kong.
+	EventDeleted EventType = "deleted"
+
+	// EventRetry is emitted when a failed registration (or unregistration) is about to be retried.
+	EventRetry EventType = "retry"
+
+	// EventRegister is emitted when an attempt to register (or reconcile) the instance has finished.
+	EventRegister EventType = "register"
+
+	// EventUnregister is emitted when an attempt to unregister the instance has finished.
+	EventUnregister EventType = "unregister"
+)
+
+const (
+	// OperationRegister is the Operation of the events emitted while registering the instance.
+	OperationRegister = "register"
+
+	// OperationUnregister is the Operation of the events emitted while unregistering the instance.
+	OperationUnregister = "unregister"
+
+	// OperationReconcile is the Operation of the EventRegister events emitted when the registration is reconciled.
+	OperationReconcile = "reconcile"
+)
+
+// Event is a structured event emitted by KongGateway to its Observer.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+
+	// Time is the time when the event was emitted.
+	Time time.Time
+
+	// Service is the name of the microservice.
+	Service string
+
+	// Operation is the operation of the EventRetry, EventRegister and EventUnregister events
+	// (OperationRegister, OperationUnregister or OperationReconcile).
+	Operation string
+
+	// Method is the HTTP method of the request to the Kong Admin API.
+	Method string
+
+	// Path is the path of the request to the Kong Admin API (the object for the created, updated and deleted events).
+	Path string
+
+	// StatusCode is the HTTP status code of the response from the Kong Admin API.
+	StatusCode int
+
+	// Attempt is the number of the failed attempt (starting from 1) of the EventRetry event.
+	Attempt int
+
+	// Duration is the duration of the request (EventResponse) or of the attempt (EventRegister, EventUnregister),
+	// or the wait time before the next attempt (EventRetry).
+	Duration time.Duration
+
+	// Err is the error of the failed request or attempt.
+	Err error
+}
+
+// String returns a human readable description of the event.
+func (e Event) String() string {
+	parts := []string{string(e.Type)}
+	if e.Service != "" {
+		parts = append(parts, fmt.Sprintf("service=%s", e.Service))
+	}
+	if e.Operation != "" {
+		parts = append(parts, fmt.Sprintf("operation=%s", e.Operation))
+	}
+	if e.Method != "" {
+		parts = append(parts, fmt.Sprintf("request=%q", e.Method+" "+e.Path))
+	} else if e.Path != "" {
+		parts = append(parts, fmt.Sprintf("path=%s", e.Path))
+	}
+	if e.StatusCode != 0 {
+		parts = append(parts, fmt.Sprintf("status=%d", e.StatusCode))
+	}
+	if e.Attempt != 0 {
+		parts = append(parts, fmt.Sprintf("attempt=%d", e.Attempt))
+	}
+	if e.Duration != 0 {
+		parts = append(parts, fmt.Sprintf("duration=%s", e.Duration))
+	}
+	if e.Err != nil {
+		parts = append(parts, fmt.Sprintf("error=%q", e.Err.Error()))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Observer receives the events of the registration, for example to log them, to collect metrics or to trace
+// the requests. The context is the context of the registration call. The events are delivered synchronously,
+// so the observer must not block.
+type Observer interface {
+	Observe(ctx context.Context, event Event)
+}
+
+// ObserverFunc is a function that implements Observer.
+type ObserverFunc func(ctx context.Context, event Event)
+
+// Observe calls the function.
+func (f ObserverFunc) Observe(ctx context.Context, event Event) {
+	f(ctx, event)
+}
+
+// Observers returns an Observer that delivers the events to all given observers, in order.
+func Observers(observers ...Observer) Observer {
+	return ObserverFunc(func(ctx context.Context, event Event) {
+		for _, observer := range observers {
+			observer.Observe(ctx, event)
+		}
+	})
+}
+
+// LogObserver returns an Observer that logs the events to the given logger. The request and response events
+// are logged only if verbose is set. If logger is nil, the standard logger is used.
+func LogObserver(logger *log.Logger, verbose bool) Observer {
+	return ObserverFunc(func(ctx context.Context, event Event) {
+		if !verbose && (event.Type == EventRequest || event.Type == EventResponse) {
+			return
+		}
+		if logger == nil {
+			log.Printf("gateway: %s", event)
+			return
+		}
+		logger.Printf("gateway: %s", event)
+	})
+}
+
+// emit delivers the event to the Observer, if one is set.
+func (kong *KongGateway) emit(ctx context.Context, event Event) {
+	if kong.Observer == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.Service = kong.config.MicroserviceName
+	kong.Observer.Observe(ctx, event)
+}
+
+// emitChange emits the created, updated or deleted event for a successful request that changed an object on Kong.
+func (kong *KongGateway) emitChange(ctx context.Context, method, path string, statusCode int) {
+	event := Event{Method: method, Path: path, StatusCode: statusCode}
+	switch method {
+	case "POST":
+		event.Type = EventCreated
+	case "PUT":
+		// PUT creates the object if it does not exist (201), or replaces it (200).
+		event.Type = EventUpdated
+		if statusCode == http.StatusCreated {
+			event.Type = EventCreated
+		}
+	case "PATCH":
+		event.Type = EventUpdated
+	case "DELETE":
+		event.Type = EventDeleted
+	default:
+		return
+	}
+	kong.emit(ctx, event)
+}
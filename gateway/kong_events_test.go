@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+// recordEvents returns an Observer that appends the events to the given slice.
+func recordEvents(events *[]Event) Observer {
+	return ObserverFunc(func(ctx context.Context, event Event) {
+		*events = append(*events, event)
+	})
+}
+
+func countEvents(events []Event, eventType EventType) int {
+	count := 0
+	for _, event := range events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRegistrationEvents(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	events := []Event{}
+	metrics := NewMetrics()
+	gateway.Observer = Observers(recordEvents(&events), metrics)
+
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if countEvents(events, EventRequest) == 0 || countEvents(events, EventRequest) != countEvents(events, EventResponse) {
+		t.Fatalf("Expected a response event for every request event, got %v", events)
+	}
+	if countEvents(events, EventCreated) == 0 {
+		t.Fatalf("Expected created events, got %v", events)
+	}
+	last := events[len(events)-1]
+	if last.Type != EventRegister || last.Err != nil || last.Service != "user-microservice" || last.Duration <= 0 {
+		t.Fatalf("Expected a successful register event last, got %v", last)
+	}
+
+	events = events[:0]
+	if err := gateway.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if countEvents(events, EventDeleted) != 1 || events[len(events)-1].Type != EventUnregister {
+		t.Fatalf("Expected the target to be deleted, got %v", events)
+	}
+
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`gateway_registration_attempts_total{service="user-microservice",operation="register"} 1`,
+		`gateway_registration_attempts_total{service="user-microservice",operation="unregister"} 1`,
+		`gateway_registration_duration_seconds_count{service="user-microservice",operation="register"} 1`,
+		`gateway_admin_requests_total{service="user-microservice",method="DELETE",code="204"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("Expected the metric %s, got:\n%s", line, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "gateway_registration_failures_total{") {
+		t.Fatalf("Expected no failures, got:\n%s", buf.String())
+	}
+}
+
+func TestRetryEvents(t *testing.T) {
+	kong := kongtest.NewServer()
+	kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	gateway.Retry = &RetryConfig{MaxAttempts: 2, InitialInterval: time.Millisecond}
+	events := []Event{}
+	metrics := &Metrics{}
+	gateway.Observer = Observers(recordEvents(&events), metrics)
+
+	if err := gateway.SelfRegisterContext(context.Background()); err == nil {
+		t.Fatal("Expected an error when Kong is not reachable")
+	}
+	if countEvents(events, EventRetry) != 1 || countEvents(events, EventRegister) != 2 {
+		t.Fatalf("Expected one retry of the registration, got %v", events)
+	}
+	for _, event := range events {
+		if event.Type == EventRetry && (event.Attempt != 1 || event.Operation != OperationRegister || event.Err == nil) {
+			t.Fatalf("Unexpected retry event: %v", event)
+		}
+	}
+
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	for _, line := range []string{
+		`gateway_registration_failures_total{service="user-microservice",operation="register"} 2`,
+		`gateway_registration_retries_total{service="user-microservice",operation="register"} 1`,
+		`gateway_admin_requests_total{service="user-microservice",method="GET",code="error"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("Expected the metric %s, got:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestReconcileEvents(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	metrics := &Metrics{Buckets: []float64{1}}
+	gateway.Observer = metrics
+
+	if _, err := gateway.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Changing the buckets after the first observation has no effect.
+	metrics.Buckets = []float64{0.1, 0.5, 1, 5}
+	if _, err := gateway.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`gateway_registration_attempts_total{service="user-microservice",operation="reconcile"} 2`,
+		`gateway_registration_duration_seconds_bucket{service="user-microservice",operation="reconcile",le="1"} 2`,
+		`gateway_registration_duration_seconds_count{service="user-microservice",operation="reconcile"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("Expected the metric %s, got:\n%s", line, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), `le="0.1"`) {
+		t.Fatalf("Expected only the initial buckets, got:\n%s", buf.String())
+	}
+}
+
+func TestMetricsBuckets(t *testing.T) {
+	metrics := &Metrics{Buckets: []float64{1, 0.5, 1}}
+	metrics.Observe(context.Background(), Event{Type: EventRegister, Service: "user-microservice", Operation: OperationRegister, Duration: 700 * time.Millisecond})
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Unexpected response: %d %v", recorder.Code, recorder.Header())
+	}
+	body := recorder.Body.String()
+	expected := `gateway_registration_duration_seconds_bucket{service="user-microservice",operation="register",le="0.5"} 0
+gateway_registration_duration_seconds_bucket{service="user-microservice",operation="register",le="1"} 1
+gateway_registration_duration_seconds_bucket{service="user-microservice",operation="register",le="+Inf"} 1
+`
+	if !strings.Contains(body, expected) {
+		t.Fatalf("Expected the sorted buckets without duplicates, got:\n%s", body)
+	}
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+func TestServicesModeFlow(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.Plugins = []PluginConfig{{Name: "rate-limiting", Config: map[string]interface{}{"minute": 100}}}
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+
+	for i := 0; i < 2; i++ {
+		if err := gateway.SelfRegister(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if service := kong.Get("services", "user-microservice"); service == nil || service["host"] != "user.api.jormugandr.org" {
+		t.Fatalf("Unexpected service: %v", service)
+	}
+	if route := kong.Get("routes", "user-microservice"); route == nil || route["strip_path"] != true {
+		t.Fatalf("Unexpected route: %v", route)
+	}
+	if upstream := kong.Get("upstreams", "user.api.jormugandr.org"); upstream == nil || upstream["slots"] != float64(10) {
+		t.Fatalf("Unexpected upstream: %v", upstream)
+	}
+	plugins := kong.Children("routes", "user-microservice", "plugins")
+	if len(plugins) != 1 || plugins[0]["name"] != "rate-limiting" {
+		t.Fatalf("Unexpected plugins: %v", plugins)
+	}
+	targets := kong.Children("upstreams", "user.api.jormugandr.org", "targets")
+	if len(targets) != 1 || targets[0]["target"] != "10.0.0.5:8080" || targets[0]["weight"] != float64(10) {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+
+	if err := gateway.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if target := kong.Children("upstreams", "user.api.jormugandr.org", "targets")[0]; target["weight"] != float64(0) {
+		t.Fatalf("Expected the target to be drained, got %v", target)
+	}
+
+	if err := gateway.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if targets := kong.Children("upstreams", "user.api.jormugandr.org", "targets"); len(targets) != 0 {
+		t.Fatalf("Expected the target to be removed, got %v", targets)
+	}
+}
+
+func TestAPIsModeFlow(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.KongMode = ""
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+
+	for i := 0; i < 2; i++ {
+		if err := gateway.SelfRegister(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apis := kong.List("apis")
+	if len(apis) != 1 || apis[0]["upstream_url"] != "http://user.api.jormugandr.org:8080" {
+		t.Fatalf("Unexpected APIs: %v", apis)
+	}
+	if uris := apis[0]["uris"].([]interface{}); len(uris) != 1 || uris[0] != "/users" {
+		t.Fatalf("Unexpected uris: %v", uris)
+	}
+	if targets := kong.Children("upstreams", "user.api.jormugandr.org", "targets"); len(targets) != 1 {
+		t.Fatalf("Expected one target, got %v", targets)
+	}
+}
+
+func TestReconcileRestoresDeletedRoute(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("DELETE", kong.URL+"/routes/user-microservice", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	changed, err := gateway.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "route" {
+		t.Fatalf("Expected only the route to be restored, got %v", changed)
+	}
+	if kong.Get("routes", "user-microservice") == nil {
+		t.Fatal("Expected the route to be restored")
+	}
+}
+
+func TestSelfRegisterContextRetriesUnavailableKong(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+	kong.FailNext(2, http.StatusServiceUnavailable)
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	gateway.Retry = &RetryConfig{MaxAttempts: 3, InitialInterval: time.Millisecond}
+
+	if err := gateway.SelfRegisterContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(kong.Children("upstreams", "user.api.jormugandr.org", "targets")) != 1 {
+		t.Fatal("Expected the target to be registered after the retries")
+	}
+}
@@ -0,0 +1,97 @@
+package gateway
+
+// DefaultHealthCheckPath is the HTTP path probed by the active health checks when no path is configured.
+// This is the endpoint of the healthcheck middleware (see utils/healthcheck).
+const DefaultHealthCheckPath = "/healthcheck"
+
+// HealthChecks is the configuration of the health checks of the upstream on Kong.
+// See https://docs.konghq.com/gateway/latest/how-kong-works/health-checks/
+type HealthChecks struct {
+	// Active health checks periodically probe the targets of the upstream.
+	Active *ActiveHealthCheck `json:"active,omitempty"`
+
+	// Passive health checks (circuit breaker) watch the proxied traffic to the targets.
+	Passive *PassiveHealthCheck `json:"passive,omitempty"`
+}
+
+// ActiveHealthCheck is the configuration of the active health checks.
+type ActiveHealthCheck struct {
+	// Type is the type of the probe: "http" (default), "https" or "tcp".
+	Type string `json:"type,omitempty"`
+
+	// HTTPPath is the path probed on every target. Defaults to DefaultHealthCheckPath.
+	HTTPPath string `json:"http_path,omitempty"`
+
+	// Timeout is the probe timeout in seconds.
+	Timeout int `json:"timeout,omitempty"`
+
+	// Concurrency is the number of targets probed concurrently.
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Healthy holds the thresholds for considering an unhealthy target healthy again.
+	Healthy *HealthyThresholds `json:"healthy,omitempty"`
+
+	// Unhealthy holds the thresholds for considering a target unhealthy.
+	Unhealthy *UnhealthyThresholds `json:"unhealthy,omitempty"`
+}
+
+// PassiveHealthCheck is the configuration of the passive health checks (circuit breaker).
+type PassiveHealthCheck struct {
+	// Type is the type of the checked traffic: "http" (default), "https" or "tcp".
+	Type string `json:"type,omitempty"`
+
+	// Healthy holds the thresholds for considering a target healthy.
+	Healthy *HealthyThresholds `json:"healthy,omitempty"`
+
+	// Unhealthy holds the thresholds for considering a target unhealthy.
+	Unhealthy *UnhealthyThresholds `json:"unhealthy,omitempty"`
+}
+
+// HealthyThresholds holds the thresholds for considering a target healthy.
+type HealthyThresholds struct {
+	// Interval is the interval between active probes of healthy targets in seconds. Zero disables the probes,
+	// while nil leaves the interval on Kong unchanged. Used by the active health checks only.
+	Interval *int `json:"interval,omitempty"`
+
+	// HTTPStatuses is the list of HTTP statuses considered a success.
+	HTTPStatuses []int `json:"http_statuses,omitempty"`
+
+	// Successes is the number of successes after which a target is considered healthy.
+	Successes int `json:"successes,omitempty"`
+}
+
+// UnhealthyThresholds holds the thresholds for considering a target unhealthy.
+type UnhealthyThresholds struct {
+	// Interval is the interval between active probes of unhealthy targets in seconds. Zero disables the probes,
+	// while nil leaves the interval on Kong unchanged. Used by the active health checks only.
+	Interval *int `json:"interval,omitempty"`
+
+	// HTTPStatuses is the list of HTTP statuses considered a failure.
+	HTTPStatuses []int `json:"http_statuses,omitempty"`
+
+	// TCPFailures is the number of TCP failures after which a target is considered unhealthy.
+	TCPFailures int `json:"tcp_failures,omitempty"`
+
+	// Timeouts is the number of timeouts after which a target is considered unhealthy.
+	Timeouts int `json:"timeouts,omitempty"`
+
+	// HTTPFailures is the number of HTTP failures after which a target is considered unhealthy.
+	HTTPFailures int `json:"http_failures,omitempty"`
+}
+
+// withDefaults returns a copy of the health checks configuration with the defaults filled in.
+// The active HTTP probe path defaults to DefaultHealthCheckPath.
+func (h *HealthChecks) withDefaults() *HealthChecks {
+	if h == nil {
+		return nil
+	}
+	healthChecks := *h
+	if h.Active != nil {
+		active := *h.Active
+		if active.HTTPPath == "" && active.Type != "tcp" {
+			active.HTTPPath = DefaultHealthCheckPath
+		}
+		healthChecks.Active = &active
+	}
+	return &healthChecks
+}
@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func newHealthChecksConfig() *HealthChecks {
+	return &HealthChecks{
+		Active: &ActiveHealthCheck{
+			Healthy: &HealthyThresholds{
+				Interval:  intPtr(5),
+				Successes: 2,
+			},
+			Unhealthy: &UnhealthyThresholds{
+				Interval:     intPtr(5),
+				HTTPFailures: 3,
+			},
+		},
+		Passive: &PassiveHealthCheck{
+			Unhealthy: &UnhealthyThresholds{
+				HTTPFailures: 5,
+				Timeouts:     3,
+			},
+		},
+	}
+}
+
+func TestCreateUpstreamWithHealthChecks(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(404).
+		JSON(map[string]string{"message": "Not Found"})
+
+	gock.New("http://kong:8001").
+		Post("/upstreams/").
+		SetMatcher(NewJSONMatcher(t).
+			Field("name", "user.api.jormugandr.org").
+			Field("healthchecks", map[string]interface{}{
+				"active": map[string]interface{}{
+					"http_path": "/healthcheck",
+					"healthy":   map[string]interface{}{"interval": 5, "successes": 2},
+					"unhealthy": map[string]interface{}{"interval": 5, "http_failures": 3},
+				},
+				"passive": map[string]interface{}{
+					"unhealthy": map[string]interface{}{"http_failures": 5, "timeouts": 3},
+				},
+			}).
+			Matcher).
+		Reply(201).
+		JSON(map[string]interface{}{"name": "user.api.jormugandr.org"})
+
+	gock.InterceptClient(client)
+
+	config := newServicesModeConfig()
+	config.HealthChecks = newHealthChecksConfig()
+	gateway := NewKongGateway("http://kong:8001", client, config)
+
+	changed, err := gateway.createOrUpdateUpstream(context.Background(), gateway.desiredUpstream())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("Expected the upstream to be created")
+	}
+	if config.HealthChecks.Active.HTTPPath != "" {
+		t.Fatal("The configuration must not be modified")
+	}
+}
+
+func TestUpstreamHealthChecksDrift(t *testing.T) {
+	desired := &upstream{
+		Name:         "user.api.jormugandr.org",
+		Slots:        10,
+		Healthchecks: newHealthChecksConfig().withDefaults(),
+	}
+
+	// Kong returns the complete health checks configuration, with the defaults filled in.
+	current := &upstream{
+		Name:  "user.api.jormugandr.org",
+		Slots: 10,
+		Healthchecks: &HealthChecks{
+			Active: &ActiveHealthCheck{
+				Type:        "http",
+				HTTPPath:    "/healthcheck",
+				Timeout:     1,
+				Concurrency: 10,
+				Healthy: &HealthyThresholds{
+					Interval:     intPtr(5),
+					Successes:    2,
+					HTTPStatuses: []int{200, 302},
+				},
+				Unhealthy: &UnhealthyThresholds{
+					Interval:     intPtr(5),
+					HTTPFailures: 3,
+					TCPFailures:  0,
+				},
+			},
+			Passive: &PassiveHealthCheck{
+				Type: "http",
+				Unhealthy: &UnhealthyThresholds{
+					HTTPFailures: 5,
+					Timeouts:     3,
+				},
+			},
+		},
+	}
+	if upstreamDiffers(desired, current) {
+		t.Fatal("Expected no drift when only defaults differ")
+	}
+
+	current.Healthchecks.Passive.Unhealthy.Timeouts = 10
+	if !upstreamDiffers(desired, current) {
+		t.Fatal("Expected drift in passive health checks")
+	}
+}
+
+func TestDisableActiveHealthChecks(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.HealthChecks = newHealthChecksConfig()
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.HealthChecks.Active.Healthy.Interval = intPtr(0)
+	config.HealthChecks.Active.Unhealthy.Interval = intPtr(0)
+	changed, err := gateway.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "upstream" {
+		t.Fatalf("Expected the upstream to be updated, got %v", changed)
+	}
+	active := kong.Get("upstreams", "user.api.jormugandr.org")["healthchecks"].(map[string]interface{})["active"].(map[string]interface{})
+	for _, thresholds := range []string{"healthy", "unhealthy"} {
+		if interval := active[thresholds].(map[string]interface{})["interval"]; interval != float64(0) {
+			t.Fatalf("Expected the %s probes to be disabled, got interval %v", thresholds, interval)
+		}
+	}
+}
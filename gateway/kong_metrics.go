@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets are the upper bounds (in seconds) of the buckets of the registration latency histogram.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics is an Observer that counts the registration attempts, failures and retries, the requests to the
+// Kong Admin API, and the latency of the registration. The metrics are exposed in the Prometheus text format,
+// so Metrics can be mounted directly as the "/metrics" handler of the microservice:
+//
+//	metrics := gateway.NewMetrics()
+//	kong.Observer = metrics
+//	http.Handle("/metrics", metrics)
+//
+// The following metrics are exposed, labeled with the service name and the operation (register, unregister or reconcile):
+//
+//	gateway_registration_attempts_total
+//	gateway_registration_failures_total
+//	gateway_registration_retries_total
+//	gateway_registration_duration_seconds (histogram)
+//	gateway_admin_requests_total (labeled with the service, the method and the status code)
+type Metrics struct {
+	// Buckets are the upper bounds (in seconds) of the latency histogram buckets. Defaults to DefaultLatencyBuckets.
+	// The buckets are read (sorted and without duplicates) on the first use of the Metrics, later changes have no effect.
+	Buckets []float64
+
+	mutex     sync.Mutex
+	buckets   []float64
+	attempts  map[string]float64
+	failures  map[string]float64
+	retries   map[string]float64
+	requests  map[string]float64
+	latencies map[string]*histogram
+}
+
+type histogram struct {
+	counts []float64
+	sum    float64
+	count  float64
+}
+
+// NewMetrics creates a new Metrics with the default latency buckets.
+func NewMetrics() *Metrics {
+	return &Metrics{Buckets: DefaultLatencyBuckets}
+}
+
+// init creates the counters on the first use, so the zero Metrics is ready to use.
+func (m *Metrics) init() {
+	if m.attempts != nil {
+		return
+	}
+	buckets := m.Buckets
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	// The bucket bounds must be increasing and unique in the Prometheus histogram.
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	m.buckets = []float64{}
+	for _, bound := range sorted {
+		if len(m.buckets) == 0 || m.buckets[len(m.buckets)-1] != bound {
+			m.buckets = append(m.buckets, bound)
+		}
+	}
+	m.attempts = map[string]float64{}
+	m.failures = map[string]float64{}
+	m.retries = map[string]float64{}
+	m.requests = map[string]float64{}
+	m.latencies = map[string]*histogram{}
+}
+
+// Observe counts the event.
+func (m *Metrics) Observe(ctx context.Context, event Event) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.init()
+
+	operation := metricLabels("service", event.Service, "operation", event.Operation)
+	switch event.Type {
+	case EventRegister, EventUnregister:
+		m.attempts[operation]++
+		if event.Err != nil {
+			m.failures[operation]++
+		}
+		latency, ok := m.latencies[operation]
+		if !ok {
+			latency = &histogram{counts: make([]float64, len(m.buckets))}
+			m.latencies[operation] = latency
+		}
+		seconds := event.Duration.Seconds()
+		for i, bound := range m.buckets {
+			if seconds <= bound {
+				latency.counts[i]++
+			}
+		}
+		latency.sum += seconds
+		latency.count++
+	case EventRetry:
+		m.retries[operation]++
+	case EventResponse:
+		code := "error"
+		if event.StatusCode != 0 {
+			code = fmt.Sprintf("%d", event.StatusCode)
+		}
+		m.requests[metricLabels("service", event.Service, "method", event.Method, "code", code)]++
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.init()
+
+	var buf bytes.Buffer
+	writeCounter(&buf, "gateway_registration_attempts_total", "Number of attempts to register or unregister the instance.", m.attempts)
+	writeCounter(&buf, "gateway_registration_failures_total", "Number of failed attempts to register or unregister the instance.", m.failures)
+	writeCounter(&buf, "gateway_registration_retries_total", "Number of retries of the registration or unregistration.", m.retries)
+	writeCounter(&buf, "gateway_admin_requests_total", "Number of requests to the Kong Admin API, by status code.", m.requests)
+
+	fmt.Fprintln(&buf, "# HELP gateway_registration_duration_seconds Duration of the attempts to register or unregister the instance.")
+	fmt.Fprintln(&buf, "# TYPE gateway_registration_duration_seconds histogram")
+	for _, labels := range histogramKeys(m.latencies) {
+		latency := m.latencies[labels]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(&buf, "gateway_registration_duration_seconds_bucket{%s,le=\"%g\"} %g\n", labels, bound, latency.counts[i])
+		}
+		fmt.Fprintf(&buf, "gateway_registration_duration_seconds_bucket{%s,le=\"+Inf\"} %g\n", labels, latency.count)
+		fmt.Fprintf(&buf, "gateway_registration_duration_seconds_sum{%s} %g\n", labels, latency.sum)
+		fmt.Fprintf(&buf, "gateway_registration_duration_seconds_count{%s} %g\n", labels, latency.count)
+	}
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// writeCounter writes a counter with its values per label set.
+func writeCounter(buf *bytes.Buffer, name, help string, values map[string]float64) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s counter\n", name)
+	keys := make([]string, 0, len(values))
+	for labels := range values {
+		keys = append(keys, labels)
+	}
+	sort.Strings(keys)
+	for _, labels := range keys {
+		fmt.Fprintf(buf, "%s{%s} %g\n", name, labels, values[labels])
+	}
+}
+
+// histogramKeys returns the sorted label sets of the histograms.
+func histogramKeys(histograms map[string]*histogram) []string {
+	keys := make([]string, 0, len(histograms))
+	for labels := range histograms {
+		keys = append(keys, labels)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricLabels formats the label name and value pairs as a Prometheus label set.
+func metricLabels(pairs ...string) string {
+	labels := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], value))
+	}
+	return strings.Join(labels, ",")
+}
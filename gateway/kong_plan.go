@@ -0,0 +1,459 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ChangeAction is the action planned for a Kong object.
+type ChangeAction string
+
+const (
+	// ActionCreate means that the object does not exist on Kong and will be created.
+	ActionCreate ChangeAction = "create"
+
+	// ActionUpdate means that the object exists on Kong, but some of its fields will be changed.
+	ActionUpdate ChangeAction = "update"
+
+	// ActionDelete means that the object exists on Kong and will be deleted.
+	ActionDelete ChangeAction = "delete"
+)
+
+// FieldChange is a planned change of a single field of a Kong object.
+// Nested fields are separated with dots, for example "healthchecks.active.http_path".
+type FieldChange struct {
+	Field   string      `json:"field"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+// Change is a planned change of a Kong object.
+type Change struct {
+	Action ChangeAction `json:"action"`
+
+	// Kind is the kind of the Kong object: "upstream", "certificate", "ca_certificate", "api", "service", "route",
+	// "plugin" or "target".
+	Kind string `json:"kind"`
+
+	// Name is the name of the object (the address for targets).
+	Name string `json:"name"`
+
+	// Fields holds the changed fields. For created objects these are all configured fields.
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// Plan is the list of changes that SelfRegister would make on Kong.
+type Plan struct {
+	Changes []Change `json:"changes"`
+}
+
+// HasChanges checks whether there are any planned changes.
+func (p *Plan) HasChanges() bool {
+	return len(p.Changes) > 0
+}
+
+// JSON returns the plan as indented JSON.
+func (p *Plan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// String returns the plan as human readable text, one object per line ("+" create, "~" update, "-" delete),
+// followed by the changed fields.
+func (p *Plan) String() string {
+	if !p.HasChanges() {
+		return "No changes.\n"
+	}
+	var buf bytes.Buffer
+	counts := map[ChangeAction]int{}
+	symbols := map[ChangeAction]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-"}
+	for _, change := range p.Changes {
+		counts[change.Action]++
+		fmt.Fprintf(&buf, "%s %s %s\n", symbols[change.Action], change.Kind, change.Name)
+		for _, field := range change.Fields {
+			if change.Action == ActionCreate {
+				fmt.Fprintf(&buf, "    %s: %s\n", field.Field, planValue(field.Desired))
+				continue
+			}
+			fmt.Fprintf(&buf, "    %s: %s -> %s\n", field.Field, planValue(field.Current), planValue(field.Desired))
+		}
+	}
+	fmt.Fprintf(&buf, "Plan: %d to create, %d to update, %d to delete.\n", counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete])
+	return buf.String()
+}
+
+// Plan compares the registration of this microservice instance with the objects on Kong and returns the changes
+// that SelfRegister would make: the upstream, the certificates of the upstream TLS, the API (or Service and Route),
+// the plugins and the target for this instance. Nothing is changed on Kong.
+func (kong *KongGateway) Plan(ctx context.Context) (*Plan, error) {
+	switch kong.config.KongMode {
+	case "", KongModeAPIs, KongModeServices:
+	default:
+		return nil, fmt.Errorf("unsupported Kong mode: %s", kong.config.KongMode)
+	}
+	if err := kong.validateUpstreamTLS(); err != nil {
+		return nil, err
+	}
+	if err := kong.validateRoutes(); err != nil {
+		return nil, err
+	}
+	plan := &Plan{Changes: []Change{}}
+
+	desiredUpstream := kong.desiredUpstream()
+	upstreamObj := map[string]interface{}{"name": desiredUpstream.Name}
+	if desiredUpstream.Slots > 0 {
+		upstreamObj["slots"] = desiredUpstream.Slots
+	}
+	if desiredUpstream.Healthchecks != nil {
+		upstreamObj["healthchecks"] = desiredUpstream.Healthchecks
+	}
+	if desiredUpstream.Tags != nil {
+		upstreamObj["tags"] = desiredUpstream.Tags
+	}
+	if desiredUpstream.HostHeader != "" {
+		upstreamObj["host_header"] = desiredUpstream.HostHeader
+	}
+	if err := kong.planObject(ctx, plan, "upstream", desiredUpstream.Name, fmt.Sprintf("upstreams/%s", desiredUpstream.Name), upstreamObj); err != nil {
+		return nil, err
+	}
+
+	if kong.config.KongMode == KongModeServices {
+		service := kong.desiredService()
+		if err := kong.planUpstreamTLS(ctx, plan, service); err != nil {
+			return nil, err
+		}
+		err := kong.planObject(ctx, plan, "service", service.Name, fmt.Sprintf("services/%s", service.Name), map[string]interface{}{
+			"name":               service.Name,
+			"host":               service.Host,
+			"port":               service.Port,
+			"protocol":           service.Protocol,
+			"tags":               service.Tags,
+			"tls_verify":         service.TLSVerify,
+			"tls_verify_depth":   service.TLSVerifyDepth,
+			"client_certificate": service.ClientCertificate,
+			"ca_certificates":    service.CACertificates,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range kong.desiredRoutes() {
+			desired := map[string]interface{}{
+				"name":          route.Name,
+				"hosts":         route.Hosts,
+				"paths":         route.Paths,
+				"methods":       route.Methods,
+				"strip_path":    route.StripPath,
+				"preserve_host": route.PreserveHost,
+				"tags":          route.Tags,
+			}
+			if route.Protocols != nil {
+				desired["protocols"] = route.Protocols
+			}
+			if route.Headers != nil {
+				desired["headers"] = route.Headers
+			}
+			if err := kong.planObject(ctx, plan, "route", route.Name, fmt.Sprintf("routes/%s", route.Name), desired); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for _, api := range kong.desiredAPIs() {
+			err := kong.planObject(ctx, plan, "api", api.Name, fmt.Sprintf("apis/%s", api.Name), map[string]interface{}{
+				"name":          api.Name,
+				"hosts":         api.Hosts,
+				"uris":          api.URIs,
+				"methods":       api.Methods,
+				"upstream_url":  api.UpstreamURL,
+				"strip_uri":     api.StripURI,
+				"preserve_host": api.PreserveHost,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := kong.planStaleRoutes(ctx, plan); err != nil {
+		return nil, err
+	}
+
+	if err := kong.planPlugins(ctx, plan); err != nil {
+		return nil, err
+	}
+	if err := kong.planTarget(ctx, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planObject adds the change of the object with the given Admin API path to the plan, if the object is missing
+// or differs from the desired values.
+func (kong *KongGateway) planObject(ctx context.Context, plan *Plan, kind, name, path string, desired map[string]interface{}) error {
+	var current map[string]interface{}
+	err := kong.request(ctx, "GET", path, nil, &current)
+	if isNotFound(err) {
+		plan.add(kind, name, nil, desired)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	plan.add(kind, name, current, desired)
+	return nil
+}
+
+// knownAfterUpload stands in the plan for the ID of a certificate that is not uploaded to Kong yet.
+const knownAfterUpload = "(known after upload)"
+
+// planUpstreamTLS adds the uploads of the client certificate and the CA certificate to the plan, the same way as
+// applyUpstreamTLS applies them, and sets the references to the certificates on the Service. The certificates and
+// the key are shown by their SHA-256 fingerprints, so the plan does not reveal the private key.
+func (kong *KongGateway) planUpstreamTLS(ctx context.Context, plan *Plan, service *Service) error {
+	certificates, err := kong.upstreamCertificates()
+	if err != nil {
+		return err
+	}
+	for _, certificate := range certificates {
+		existing, err := kong.findTaggedCertificate(ctx, certificate.collection, certificate.tag)
+		if err != nil {
+			return err
+		}
+		desired := pemFingerprints(certificate.values)
+		desired["tags"] = append(kong.ownerTags(), certificate.tag)
+		id := knownAfterUpload
+		var current map[string]interface{}
+		if existing != nil {
+			id = stringValue(existing["id"])
+			current = pemFingerprints(existing)
+		}
+		plan.add(strings.TrimSuffix(certificate.collection, "s"), certificate.tag, current, desired)
+		setServiceCertificate(service, certificate.collection, id)
+	}
+	return nil
+}
+
+// pemFingerprints returns a copy of the certificate values with the PEM encoded certificate and key replaced
+// by their SHA-256 fingerprints.
+func pemFingerprints(values map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key, value := range values {
+		if pem, ok := value.(string); ok && (key == "cert" || key == "key") {
+			sum := sha256.Sum256([]byte(pem))
+			value = "sha256:" + hex.EncodeToString(sum[:])[:16]
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// planStaleRoutes adds the deletion of the Routes (or APIs) that are not configured anymore to the plan.
+func (kong *KongGateway) planStaleRoutes(ctx context.Context, plan *Plan) error {
+	collection, stale, err := kong.staleRouteNames(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Kind: strings.TrimSuffix(collection, "s"), Name: name})
+	}
+	return nil
+}
+
+// planPlugins adds the changes of the plugins to the plan, the same way as syncPlugins applies them.
+// The plugins are named "<route>/<plugin>" in the plan if multiple routes are configured.
+func (kong *KongGateway) planPlugins(ctx context.Context, plan *Plan) error {
+	for _, route := range kong.routes() {
+		if route.config.Plugins == nil {
+			continue
+		}
+		prefix := ""
+		if kong.config.Routes != nil {
+			prefix = route.name + "/"
+		}
+		existing := []map[string]interface{}{}
+		if err := kong.listAll(ctx, kong.pluginsPath(route.name), &existing); err != nil && !isNotFound(err) {
+			return err
+		}
+		byName := map[string]map[string]interface{}{}
+		for _, plugin := range existing {
+			byName[fmt.Sprintf("%v", plugin["name"])] = plugin
+		}
+
+		for _, pluginConf := range route.config.Plugins {
+			desired := map[string]interface{}{"name": pluginConf.Name}
+			if pluginConf.Enabled != nil {
+				desired["enabled"] = *pluginConf.Enabled
+			}
+			if pluginConf.Config != nil {
+				desired["config"] = pluginConf.Config
+			}
+			if tags := kong.ownerTags(); tags != nil {
+				desired["tags"] = tags
+			}
+			current := byName[pluginConf.Name]
+			delete(byName, pluginConf.Name)
+			plan.add("plugin", prefix+pluginConf.Name, current, desired)
+		}
+
+		names := []string{}
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Kind: "plugin", Name: prefix + name})
+		}
+	}
+	return nil
+}
+
+// planTarget adds the change of the target for this instance to the plan, if the target is missing from
+// the upstream or has a different weight.
+func (kong *KongGateway) planTarget(ctx context.Context, plan *Plan) error {
+	self, err := kong.selfTarget(kong.config.MicroservicePort)
+	if err != nil {
+		return err
+	}
+	weight, err := kong.instanceWeight(ctx)
+	if err != nil {
+		return err
+	}
+	targets := []map[string]interface{}{}
+	err = kong.listAll(ctx, fmt.Sprintf("upstreams/%s/targets", kong.config.VirtualHost), &targets)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	var current map[string]interface{}
+	for _, target := range targets {
+		if target["target"] == self {
+			current = target
+		}
+	}
+	desired := map[string]interface{}{
+		"target": self,
+		"weight": weight,
+	}
+	if tags := kong.targetTags(); tags != nil {
+		desired["tags"] = tags
+	}
+	plan.add("target", self, current, desired)
+	return nil
+}
+
+// add adds a create change if current is nil, or an update change if any of the desired fields differ.
+func (p *Plan) add(kind, name string, current, desired map[string]interface{}) {
+	desiredJSON, _ := normalizeJSON(desired).(map[string]interface{})
+	if current == nil {
+		change := Change{Action: ActionCreate, Kind: kind, Name: name}
+		for _, key := range sortedKeys(desiredJSON) {
+			if !isEmptyValue(desiredJSON[key]) {
+				change.Fields = append(change.Fields, FieldChange{Field: key, Desired: desiredJSON[key]})
+			}
+		}
+		p.Changes = append(p.Changes, change)
+		return
+	}
+	currentJSON, _ := normalizeJSON(current).(map[string]interface{})
+	if fields := diffFields("", currentJSON, desiredJSON); len(fields) > 0 {
+		p.Changes = append(p.Changes, Change{Action: ActionUpdate, Kind: kind, Name: name, Fields: fields})
+	}
+}
+
+// diffFields compares the desired values with the current values and returns the fields that differ.
+// Only the desired fields are compared, because Kong fills in the defaults for the rest of the values.
+func diffFields(prefix string, current, desired map[string]interface{}) []FieldChange {
+	fields := []FieldChange{}
+	for _, key := range sortedKeys(desired) {
+		desiredValue := desired[key]
+		if desiredValue == nil {
+			continue
+		}
+		currentValue := current[key]
+		desiredMap, desiredIsMap := desiredValue.(map[string]interface{})
+		currentMap, currentIsMap := currentValue.(map[string]interface{})
+		if desiredIsMap && currentIsMap {
+			fields = append(fields, diffFields(prefix+key+".", currentMap, desiredMap)...)
+			continue
+		}
+		if key == "tags" && prefix == "" {
+			// The tags set by others are kept on update, so only the missing tags are changes.
+			currentTags, _ := stringList(currentValue)
+			desiredTags, _ := stringList(desiredValue)
+			if containsStrings(currentTags, desiredTags) {
+				continue
+			}
+		}
+		if !sameValue(currentValue, desiredValue) {
+			fields = append(fields, FieldChange{Field: prefix + key, Current: currentValue, Desired: desiredValue})
+		}
+	}
+	return fields
+}
+
+// sameValue compares two JSON values. Empty and null values are the same, and lists of strings are
+// compared regardless of the order.
+func sameValue(current, desired interface{}) bool {
+	if isEmptyValue(current) && isEmptyValue(desired) {
+		return true
+	}
+	currentStrings, currentOK := stringList(current)
+	desiredStrings, desiredOK := stringList(desired)
+	if currentOK && desiredOK {
+		return sameStrings(currentStrings, desiredStrings)
+	}
+	return reflect.DeepEqual(current, desired)
+}
+
+// stringList converts a JSON list of strings into a string slice.
+func stringList(value interface{}) ([]string, bool) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	result := []string{}
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		result = append(result, s)
+	}
+	return result, true
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// planValue formats the value as JSON for the text output of the plan.
+func planValue(value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
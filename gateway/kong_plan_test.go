@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+func TestPlanCreatesEverythingOnEmptyKong(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.Plugins = []PluginConfig{{Name: "cors"}}
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+
+	plan, err := gateway.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := []string{}
+	for _, change := range plan.Changes {
+		if change.Action != ActionCreate {
+			t.Fatalf("Expected only creates, got %+v", change)
+		}
+		kinds = append(kinds, change.Kind)
+	}
+	if strings.Join(kinds, ",") != "upstream,service,route,plugin,target" {
+		t.Fatalf("Unexpected changes: %v", kinds)
+	}
+	for _, request := range kong.Requests() {
+		if !strings.HasPrefix(request, "GET ") {
+			t.Fatalf("Expected only GET requests, got %s", request)
+		}
+	}
+	if len(kong.List("upstreams")) != 0 {
+		t.Fatal("Expected nothing to be created on Kong")
+	}
+}
+
+func TestPlanShowsFieldChanges(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.Plugins = []PluginConfig{{Name: "cors"}, {Name: "rate-limiting", Config: map[string]interface{}{"minute": 100}}}
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := gateway.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.HasChanges() || plan.String() != "No changes.\n" {
+		t.Fatalf("Expected no changes after SelfRegister, got:\n%s", plan)
+	}
+
+	config.Paths = []string{"/users", "/v2/users"}
+	config.Weight = 20
+	config.Plugins = []PluginConfig{{Name: "rate-limiting", Config: map[string]interface{}{"minute": 50}}}
+
+	plan, err = gateway.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `~ route user-microservice
+    paths: ["/users"] -> ["/users","/v2/users"]
+~ plugin rate-limiting
+    config.minute: 100 -> 50
+- plugin cors
+~ target 10.0.0.5:8080
+    weight: 10 -> 20
+Plan: 0 to create, 3 to update, 1 to delete.
+`
+	if plan.String() != expected {
+		t.Fatalf("Expected plan:\n%s\ngot:\n%s", expected, plan)
+	}
+
+	data, err := plan.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Plan{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Changes) != 4 || decoded.Changes[0].Fields[0].Field != "paths" {
+		t.Fatalf("Unexpected JSON plan: %s", data)
+	}
+}
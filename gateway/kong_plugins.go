@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+)
+
+// PluginConfig is the configuration of a Kong plugin bound to the microservice API (or Route).
+type PluginConfig struct {
+	// Name is the name of the Kong plugin, for example "rate-limiting", "cors", "request-size-limiting" or "jwt".
+	Name string `json:"name"`
+
+	// Config holds the plugin configuration. Only the values set here are managed, the rest are left to
+	// the Kong defaults.
+	Config map[string]interface{} `json:"config,omitempty"`
+
+	// Enabled signals whether the plugin is enabled. Defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+// Plugin is a structure that represents Kong's Plugin object.
+// See https://docs.konghq.com/gateway/latest/admin-api/#plugin-object
+type Plugin struct {
+	ID        string                 `json:"id,omitempty"`
+	CreatedAt int                    `json:"created_at,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+	Enabled   *bool                  `json:"enabled,omitempty"`
+	Tags      []string               `json:"tags,omitempty"`
+}
+
+// pluginsPath returns the path of the plugins endpoint of the API (or Route) with the given name.
+func (kong *KongGateway) pluginsPath(name string) string {
+	if kong.config.KongMode == KongModeServices {
+		return fmt.Sprintf("routes/%s/plugins", name)
+	}
+	return fmt.Sprintf("apis/%s/plugins", name)
+}
+
+// listPlugins retrieves the plugins bound to the API (or Route) with the given name, from all pages.
+func (kong *KongGateway) listPlugins(ctx context.Context, name string) ([]Plugin, error) {
+	plugins := []Plugin{}
+	if err := kong.listAll(ctx, kong.pluginsPath(name), &plugins); err != nil {
+		return nil, err
+	}
+	return plugins, nil
+}
+
+// syncPlugins synchronizes the plugins of every API (or Route) of the microservice (see syncRoutePlugins).
+// Returns true if any plugin was changed on Kong.
+func (kong *KongGateway) syncPlugins(ctx context.Context) (bool, error) {
+	changed := false
+	for _, route := range kong.routes() {
+		routeChanged, err := kong.syncRoutePlugins(ctx, route.name, route.config.Plugins)
+		changed = changed || routeChanged
+		if err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// syncRoutePlugins creates the configured plugins that are missing on the API (or Route) with the given name,
+// updates the plugins whose configuration differs, and deletes the plugins that are not configured.
+// Plugins are matched by name. Nothing is done if no plugins are configured (pluginConfs is nil).
+// Returns true if any plugin was changed on Kong.
+func (kong *KongGateway) syncRoutePlugins(ctx context.Context, name string, pluginConfs []PluginConfig) (bool, error) {
+	if pluginConfs == nil {
+		return false, nil
+	}
+	path := kong.pluginsPath(name)
+	existing, err := kong.listPlugins(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	byName := map[string]Plugin{}
+	for _, plugin := range existing {
+		byName[plugin.Name] = plugin
+	}
+
+	changed := false
+	for _, pluginConf := range pluginConfs {
+		desired := Plugin{
+			Name:    pluginConf.Name,
+			Config:  pluginConf.Config,
+			Enabled: pluginConf.Enabled,
+			Tags:    kong.ownerTags(),
+		}
+		current, ok := byName[pluginConf.Name]
+		delete(byName, pluginConf.Name)
+
+		if !ok {
+			if err := kong.request(ctx, "POST", path, desired, nil); err != nil {
+				return changed, err
+			}
+			changed = true
+			continue
+		}
+		if !pluginDiffers(&desired, &current) {
+			continue
+		}
+		if err := kong.request(ctx, "PATCH", fmt.Sprintf("%s/%s", path, current.ID), desired, nil); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	for _, plugin := range byName {
+		err := kong.request(ctx, "DELETE", fmt.Sprintf("%s/%s", path, plugin.ID), nil, nil)
+		if err != nil && !isNotFound(err) {
+			return changed, err
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// pluginDiffers checks whether the plugin on Kong differs from the desired plugin.
+// Only the configuration values set in the desired plugin are compared, because Kong
+// fills in the defaults for the rest of the values.
+func pluginDiffers(desired, current *Plugin) bool {
+	desiredEnabled := desired.Enabled == nil || *desired.Enabled
+	currentEnabled := current.Enabled == nil || *current.Enabled
+	if desiredEnabled != currentEnabled || !containsStrings(current.Tags, desired.Tags) {
+		return true
+	}
+	return !containsJSON(current.Config, desired.Config)
+}
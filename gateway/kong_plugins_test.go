@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestSyncPluginsCreatesUpdatesAndDeletes(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	// The plugins are listed on two pages.
+	gock.New("http://kong:8001").
+		Get("/routes/user-microservice/plugins").
+		MatchParam("offset", "page2").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{
+				{
+					"id":      "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+					"name":    "key-auth",
+					"enabled": true,
+				},
+				{
+					"id":      "5b6c7d8e-9f0a-4b1c-8d2e-3f4a5b6c7d8e",
+					"name":    "jwt",
+					"enabled": true,
+					"tags":    []string{"user-microservice"},
+				},
+			},
+			"next": nil,
+		})
+
+	gock.New("http://kong:8001").
+		Get("/routes/user-microservice/plugins").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{
+				{
+					"id":      "7e4e2c1d-59f5-4c6a-8d5c-7f0f3a0c9b11",
+					"name":    "rate-limiting",
+					"enabled": true,
+					"config":  map[string]interface{}{"minute": 10, "policy": "local"},
+				},
+				{
+					"id":      "f2d2a4b8-3c4e-4f1a-9a6e-0b5a1c2d3e4f",
+					"name":    "cors",
+					"enabled": true,
+					"config":  map[string]interface{}{"origins": []string{"*"}, "credentials": false},
+					"tags":    []string{"user-microservice"},
+				},
+			},
+			"next": "/routes/user-microservice/plugins?offset=page2",
+		})
+
+	gock.New("http://kong:8001").
+		Patch("/routes/user-microservice/plugins/7e4e2c1d-59f5-4c6a-8d5c-7f0f3a0c9b11").
+		SetMatcher(NewJSONMatcher(t).
+			Field("name", "rate-limiting").
+			Field("config", map[string]interface{}{"minute": 100}).
+			Matcher).
+		Reply(200).
+		JSON(map[string]interface{}{"name": "rate-limiting"})
+
+	gock.New("http://kong:8001").
+		Post("/routes/user-microservice/plugins").
+		SetMatcher(NewJSONMatcher(t).
+			Field("name", "acl").
+			Field("tags", []string{"user-microservice"}).
+			Matcher).
+		Reply(201).
+		JSON(map[string]interface{}{"name": "acl"})
+
+	gock.New("http://kong:8001").
+		Delete("/routes/user-microservice/plugins/0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d").
+		Reply(204)
+
+	gock.InterceptClient(client)
+
+	config := newServicesModeConfig()
+	config.Plugins = []PluginConfig{
+		{Name: "rate-limiting", Config: map[string]interface{}{"minute": 100}},
+		{Name: "cors", Config: map[string]interface{}{"origins": []string{"*"}}},
+		{Name: "jwt"},
+		{Name: "acl"},
+	}
+	gateway := NewKongGateway("http://kong:8001", client, config)
+
+	changed, err := gateway.syncPlugins(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("Expected plugins to be changed")
+	}
+
+	if gock.IsPending() {
+		for _, mock := range gock.GetAll() {
+			t.Error("Mock:", mock.Request())
+		}
+		t.Fatal("Expected all HTTP calls to be made")
+	}
+}
+
+func TestSyncPluginsNotConfigured(t *testing.T) {
+	gateway := NewKongGateway("http://kong:8001", &http.Client{}, newServicesModeConfig())
+
+	changed, err := gateway.syncPlugins(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Fatal("Expected no changes when no plugins are configured")
+	}
+}
+
+func TestPluginsPathLegacyAPI(t *testing.T) {
+	config := newServicesModeConfig()
+	config.KongMode = ""
+	gateway := NewKongGateway("http://kong:8001", &http.Client{}, config)
+
+	if path := gateway.pluginsPath("user-microservice"); path != "apis/user-microservice/plugins" {
+		t.Fatalf("Unexpected plugins path: %s", path)
+	}
+}
@@ -0,0 +1,301 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Reconcile compares the registration of this microservice instance with the objects on Kong and
+// re-applies only the objects that are missing or differ from the configuration: the upstream,
+// the API (or Service and Route), the plugins and the target for this instance.
+// Returns the list of re-applied objects, for example "upstream", "api", "service", "route", "plugins" and "target".
+// Every run is reported to the Observer as an EventRegister event with the OperationReconcile operation.
+func (kong *KongGateway) Reconcile(ctx context.Context) (changed []string, err error) {
+	start := time.Now()
+	defer func() {
+		kong.emit(ctx, Event{Type: EventRegister, Operation: OperationReconcile, Duration: time.Since(start), Err: err})
+	}()
+
+	changed = []string{}
+
+	switch kong.config.KongMode {
+	case "", KongModeAPIs, KongModeServices:
+	default:
+		return nil, fmt.Errorf("unsupported Kong mode: %s", kong.config.KongMode)
+	}
+	if err := kong.validateUpstreamTLS(); err != nil {
+		return nil, err
+	}
+	if err := kong.validateRoutes(); err != nil {
+		return nil, err
+	}
+
+	upstreamChanged, err := kong.createOrUpdateUpstream(ctx, kong.desiredUpstream())
+	if err != nil {
+		return changed, err
+	}
+	if upstreamChanged {
+		changed = append(changed, "upstream")
+	}
+
+	if kong.config.KongMode == KongModeServices {
+		changed, err = kong.reconcileServiceAndRoute(ctx, changed)
+	} else {
+		changed, err = kong.reconcileAPI(ctx, changed)
+	}
+	if err != nil {
+		return changed, err
+	}
+
+	pluginsChanged, err := kong.syncPlugins(ctx)
+	if err != nil {
+		return changed, err
+	}
+	if pluginsChanged {
+		changed = append(changed, "plugins")
+	}
+
+	return kong.reconcileTarget(ctx, changed)
+}
+
+// reconcileAPI re-applies the (legacy) API objects that differ from the configuration, and deletes the APIs
+// of the removed routes.
+func (kong *KongGateway) reconcileAPI(ctx context.Context, changed []string) ([]string, error) {
+	apiChanged := false
+	for _, desired := range kong.desiredAPIs() {
+		api, err := kong.getAPI(ctx, desired.Name)
+		if err != nil {
+			return changed, err
+		}
+		if api != nil && api.UpstreamURL == desired.UpstreamURL &&
+			api.StripURI == desired.StripURI &&
+			api.PreserveHost == desired.PreserveHost &&
+			sameStrings(api.Hosts, desired.Hosts) &&
+			sameStrings(api.URIs, desired.URIs) &&
+			sameStrings(api.Methods, desired.Methods) {
+			continue
+		}
+		if api != nil {
+			desired.ID = api.ID
+		}
+		if _, err = kong.createOrUpdateKongAPI(ctx, desired); err != nil {
+			return changed, err
+		}
+		apiChanged = true
+	}
+	deleted, err := kong.deleteStaleRoutes(ctx)
+	if err != nil {
+		return changed, err
+	}
+	if apiChanged || deleted {
+		changed = append(changed, "api")
+	}
+	return changed, nil
+}
+
+// reconcileServiceAndRoute re-applies the Service and the Route objects if they differ from the configuration.
+func (kong *KongGateway) reconcileServiceAndRoute(ctx context.Context, changed []string) ([]string, error) {
+	desiredService := kong.desiredService()
+	if err := kong.applyUpstreamTLS(ctx, desiredService); err != nil {
+		return changed, err
+	}
+	service, err := kong.getService(ctx, desiredService.Name)
+	if err != nil {
+		return changed, err
+	}
+	if service == nil || service.Host != desiredService.Host ||
+		service.Port != desiredService.Port ||
+		service.Protocol != desiredService.Protocol ||
+		service.Path != desiredService.Path ||
+		!containsStrings(service.Tags, desiredService.Tags) ||
+		serviceTLSDiffers(desiredService, service) {
+		if _, err = kong.createOrUpdateService(ctx, desiredService); err != nil {
+			return changed, err
+		}
+		changed = append(changed, "service")
+	}
+
+	routeChanged := false
+	for _, desiredRoute := range kong.desiredRoutes() {
+		route, err := kong.getRoute(ctx, desiredRoute.Name)
+		if err != nil {
+			return changed, err
+		}
+		if route != nil && route.StripPath == desiredRoute.StripPath &&
+			route.PreserveHost == desiredRoute.PreserveHost &&
+			sameStrings(route.Hosts, desiredRoute.Hosts) &&
+			sameStrings(route.Paths, desiredRoute.Paths) &&
+			sameStrings(route.Methods, desiredRoute.Methods) &&
+			containsStrings(route.Tags, desiredRoute.Tags) &&
+			(desiredRoute.Protocols == nil || sameStrings(route.Protocols, desiredRoute.Protocols)) &&
+			sameHeaders(route.Headers, desiredRoute.Headers) {
+			continue
+		}
+		if _, err = kong.createOrUpdateRoute(ctx, desiredService.Name, desiredRoute); err != nil {
+			return changed, err
+		}
+		routeChanged = true
+	}
+	deleted, err := kong.deleteStaleRoutes(ctx)
+	if err != nil {
+		return changed, err
+	}
+	if routeChanged || deleted {
+		changed = append(changed, "route")
+	}
+	return changed, nil
+}
+
+// sameHeaders checks whether the header values matched by two routes are the same, regardless of the order
+// of the values.
+func sameHeaders(a, b map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, values := range a {
+		if !sameStrings(values, b[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// reconcileTarget re-adds the target for this instance if it is missing from the upstream, or updates it if it has
+// a different weight or tags.
+func (kong *KongGateway) reconcileTarget(ctx context.Context, changed []string) ([]string, error) {
+	self, err := kong.selfTarget(kong.config.MicroservicePort)
+	if err != nil {
+		return changed, err
+	}
+	weight, err := kong.instanceWeight(ctx)
+	if err != nil {
+		return changed, err
+	}
+	targets, err := kong.listTargets(ctx, kong.config.VirtualHost)
+	if err != nil {
+		return changed, err
+	}
+	var current *upstreamTarget
+	for i := range targets {
+		if targets[i].Target == self {
+			current = &targets[i]
+		}
+	}
+	if current != nil && current.Weight == weight && containsStrings(current.Tags, kong.targetTags()) {
+		return changed, nil
+	}
+	if current != nil && kong.config.KongMode == KongModeServices {
+		// Kong 3.x rejects a second target with the same address, so the existing target is updated in place.
+		body := map[string]interface{}{"weight": weight}
+		if tags := kong.targetTags(); tags != nil {
+			body["tags"] = unionStrings(current.Tags, tags)
+		}
+		err = kong.request(ctx, "PATCH", fmt.Sprintf("upstreams/%s/targets/%s", kong.config.VirtualHost, url.PathEscape(self)), body, nil)
+	} else {
+		_, err = kong.addSelfAsTarget(ctx, kong.config.VirtualHost, kong.config.MicroservicePort, weight)
+	}
+	if err != nil {
+		return changed, err
+	}
+	return append(changed, "target"), nil
+}
+
+// listTargets retrieves the active targets of the upstream with the given name, from all pages.
+func (kong *KongGateway) listTargets(ctx context.Context, upstream string) ([]upstreamTarget, error) {
+	targets := []upstreamTarget{}
+	if err := kong.listAll(ctx, fmt.Sprintf("upstreams/%s/targets", upstream), &targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// sameStrings checks whether two lists contain the same values, regardless of the order.
+// Nil and empty lists are considered the same.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sa := append([]string{}, a...)
+	sb := append([]string{}, b...)
+	sort.Strings(sa)
+	sort.Strings(sb)
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// containsStrings checks whether all desired values are in the actual list.
+func containsStrings(actual, desired []string) bool {
+	for _, value := range desired {
+		found := false
+		for _, a := range actual {
+			if a == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// unionStrings returns the actual values followed by the desired values that are not in the actual list.
+func unionStrings(actual, desired []string) []string {
+	result := append([]string{}, actual...)
+	for _, value := range desired {
+		if !containsStrings(result, []string{value}) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// containsJSON checks whether all values set in desired are set to the same values in actual, when both are
+// represented as JSON. Objects are compared recursively, so actual may contain additional values (for example
+// defaults filled in by Kong), while all other values must be the same. Null (unset) desired values are ignored.
+func containsJSON(actual, desired interface{}) bool {
+	return containsNormalized(normalizeJSON(actual), normalizeJSON(desired))
+}
+
+func containsNormalized(actual, desired interface{}) bool {
+	if desired == nil {
+		return true
+	}
+	desiredMap, ok := desired.(map[string]interface{})
+	if !ok {
+		return reflect.DeepEqual(actual, desired)
+	}
+	actualMap, ok := actual.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for key, value := range desiredMap {
+		if !containsNormalized(actualMap[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeJSON converts the value to its generic JSON representation (maps, slices, float64, string, bool).
+func normalizeJSON(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
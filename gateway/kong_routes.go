@@ -0,0 +1,255 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// RouteConfig is the configuration of a single route of the microservice on the gateway. Every route is
+// registered as a separate Kong Route (or API, in the apis Kong mode), so the endpoints of the same microservice
+// can have different hosts and policies, for example public endpoints with rate limiting and internal endpoints
+// without it.
+type RouteConfig struct {
+	// Name is the name of the route, unique within the microservice. The route is registered on Kong
+	// as "<MicroserviceName>-<Name>".
+	Name string `json:"name"`
+
+	// Paths is a list of URL paths (prefixes) on the gateway that are proxied to the microservice by this route.
+	Paths []string `json:"paths,omitempty"`
+
+	// Methods is a list of HTTP methods matched by this route. If empty, all methods are matched.
+	Methods []string `json:"methods,omitempty"`
+
+	// Hosts is a list of hosts matched by this route. If not set, the Hosts of the microservice are used.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// StripPath signals whether the gateway should strip the matched path prefix from the upstream request URL.
+	// If not set, the StripPath of the microservice is used.
+	StripPath *bool `json:"strip_path,omitempty"`
+
+	// PreserveHost signals whether the gateway should pass the original Host header to the microservice.
+	// If not set, the PreserveHost of the microservice is used.
+	PreserveHost *bool `json:"preserve_host,omitempty"`
+
+	// Protocols is a list of protocols ("http", "https") matched by this route. If empty, the Kong default
+	// (both) is used. Used only in the services Kong mode.
+	Protocols []string `json:"protocols,omitempty"`
+
+	// Headers holds the values of the request headers matched by this route (Kong 1.3 and newer).
+	// Used only in the services Kong mode.
+	Headers map[string][]string `json:"headers,omitempty"`
+
+	// Plugins is a list of Kong plugins bound to this route. If not set, the Plugins of the microservice are used.
+	// If set (even to an empty list), the plugins bound to the route that are not in the list are removed.
+	Plugins []PluginConfig `json:"plugins,omitempty"`
+}
+
+// kongRoute is a route of the microservice, as registered on Kong.
+type kongRoute struct {
+	// name is the name of the Route (or API) on Kong.
+	name string
+
+	config RouteConfig
+}
+
+// routes returns the routes of the microservice: one for every configured route, or a single route named after
+// the microservice, built from the Hosts, Paths and Methods of the microservice, if no Routes are configured.
+// The unset values of the configured routes are taken from the microservice.
+func (kong *KongGateway) routes() []kongRoute {
+	stripPath := kong.config.StripPath
+	preserveHost := kong.config.PreserveHost
+	if kong.config.Routes == nil {
+		return []kongRoute{{
+			name: kong.config.MicroserviceName,
+			config: RouteConfig{
+				Paths:        kong.config.Paths,
+				Methods:      kong.config.Methods,
+				Hosts:        kong.config.Hosts,
+				StripPath:    &stripPath,
+				PreserveHost: &preserveHost,
+				Plugins:      kong.config.Plugins,
+			},
+		}}
+	}
+	routes := []kongRoute{}
+	for _, routeConf := range kong.config.Routes {
+		if routeConf.Hosts == nil {
+			routeConf.Hosts = kong.config.Hosts
+		}
+		if routeConf.StripPath == nil {
+			routeConf.StripPath = &stripPath
+		}
+		if routeConf.PreserveHost == nil {
+			routeConf.PreserveHost = &preserveHost
+		}
+		if routeConf.Plugins == nil {
+			routeConf.Plugins = kong.config.Plugins
+		}
+		routes = append(routes, kongRoute{
+			name:   fmt.Sprintf("%s-%s", kong.config.MicroserviceName, routeConf.Name),
+			config: routeConf,
+		})
+	}
+	return routes
+}
+
+// validateRoutes checks that the routes have unique names and match the requests by at least one value.
+// The protocols and headers are not supported in the (legacy) apis Kong mode.
+func (kong *KongGateway) validateRoutes() error {
+	if kong.config.Routes == nil {
+		return nil
+	}
+	if len(kong.config.Routes) == 0 {
+		return fmt.Errorf("routes are configured, but the list is empty")
+	}
+	names := map[string]bool{}
+	for _, routeConf := range kong.config.Routes {
+		if routeConf.Name == "" {
+			return fmt.Errorf("route name is empty")
+		}
+		if names[routeConf.Name] {
+			return fmt.Errorf("duplicate route name: %s", routeConf.Name)
+		}
+		names[routeConf.Name] = true
+		if len(routeConf.Paths) == 0 && len(routeConf.Methods) == 0 && len(routeConf.Hosts) == 0 &&
+			len(kong.config.Hosts) == 0 && len(routeConf.Headers) == 0 {
+			return fmt.Errorf("route %s must match at least one of the paths, methods, hosts or headers", routeConf.Name)
+		}
+		if kong.config.KongMode != KongModeServices && (len(routeConf.Protocols) > 0 || len(routeConf.Headers) > 0) {
+			return fmt.Errorf("route %s: protocols and headers are supported only in the %q Kong mode", routeConf.Name, KongModeServices)
+		}
+	}
+	return nil
+}
+
+// desiredRoutes maps the routes of the microservice onto Kong Route objects.
+func (kong *KongGateway) desiredRoutes() []*Route {
+	routes := []*Route{}
+	for _, route := range kong.routes() {
+		routeConf := NewRouteConf()
+		routeConf.Name = route.name
+		routeConf.Hosts = route.config.Hosts
+		routeConf.Paths = route.config.Paths
+		routeConf.Methods = route.config.Methods
+		routeConf.StripPath = *route.config.StripPath
+		routeConf.PreserveHost = *route.config.PreserveHost
+		routeConf.Headers = route.config.Headers
+		if len(route.config.Protocols) > 0 {
+			routeConf.Protocols = route.config.Protocols
+		}
+		routeConf.Tags = kong.ownerTags()
+		routes = append(routes, routeConf)
+	}
+	return routes
+}
+
+// desiredAPIs maps the routes of the microservice onto (legacy) Kong API objects.
+func (kong *KongGateway) desiredAPIs() []*API {
+	apis := []*API{}
+	for _, route := range kong.routes() {
+		apiConf := NewAPIConf()
+		apiConf.Name = route.name
+		apiConf.Hosts = route.config.Hosts
+		// The host part of the upstream URL is the name of the upstream, so Kong balances the requests between the targets.
+		apiConf.UpstreamURL = fmt.Sprintf("%s://%s:%d", kong.upstreamProtocol(), kong.config.VirtualHost, kong.config.MicroservicePort)
+		apiConf.URIs = route.config.Paths
+		if len(route.config.Methods) > 0 {
+			apiConf.Methods = route.config.Methods
+		}
+		apiConf.StripURI = *route.config.StripPath
+		apiConf.PreserveHost = *route.config.PreserveHost
+		apis = append(apis, apiConf)
+	}
+	return apis
+}
+
+// staleRoutes returns the names of the Routes of the Service on Kong that are not routes of the microservice,
+// for example the routes removed from the configuration. Nothing is stale if no Routes are configured,
+// because the Routes on Kong are then not managed, except the one named after the microservice.
+func (kong *KongGateway) staleRoutes(ctx context.Context) ([]string, error) {
+	if kong.config.Routes == nil {
+		return nil, nil
+	}
+	existing := []Route{}
+	err := kong.listAll(ctx, fmt.Sprintf("services/%s/routes", url.PathEscape(kong.config.MicroserviceName)), &existing)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, route := range existing {
+		// The Routes without a name were not registered by the microservice.
+		if route.Name != "" {
+			names = append(names, route.Name)
+		}
+	}
+	return kong.staleNames(names), nil
+}
+
+// staleAPIs returns the names of the (legacy) APIs on Kong that proxy to the upstream of the microservice and
+// are named after it, but are not routes of the microservice. Nothing is stale if no Routes are configured.
+func (kong *KongGateway) staleAPIs(ctx context.Context) ([]string, error) {
+	if kong.config.Routes == nil {
+		return nil, nil
+	}
+	existing := []API{}
+	if err := kong.listAll(ctx, "apis", &existing); err != nil {
+		return nil, err
+	}
+	upstreamURL := kong.desiredAPIs()[0].UpstreamURL
+	names := []string{}
+	for _, api := range existing {
+		if api.UpstreamURL != upstreamURL {
+			continue
+		}
+		if api.Name == kong.config.MicroserviceName || strings.HasPrefix(api.Name, kong.config.MicroserviceName+"-") {
+			names = append(names, api.Name)
+		}
+	}
+	return kong.staleNames(names), nil
+}
+
+// staleNames returns the names that are not names of the routes of the microservice.
+func (kong *KongGateway) staleNames(names []string) []string {
+	desired := []string{}
+	for _, route := range kong.routes() {
+		desired = append(desired, route.name)
+	}
+	stale := []string{}
+	for _, name := range names {
+		if !containsStrings(desired, []string{name}) {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
+
+// staleRouteNames returns the collection ("routes" or "apis", depending on the Kong mode) and the names of
+// the stale Routes (or APIs) in it.
+func (kong *KongGateway) staleRouteNames(ctx context.Context) (string, []string, error) {
+	if kong.config.KongMode == KongModeServices {
+		stale, err := kong.staleRoutes(ctx)
+		return "routes", stale, err
+	}
+	stale, err := kong.staleAPIs(ctx)
+	return "apis", stale, err
+}
+
+// deleteStaleRoutes deletes the Routes (or APIs) of the microservice on Kong that are not configured anymore.
+// Returns true if any Route (or API) was deleted.
+func (kong *KongGateway) deleteStaleRoutes(ctx context.Context) (bool, error) {
+	collection, stale, err := kong.staleRouteNames(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range stale {
+		if err := kong.deleteEntity(ctx, fmt.Sprintf("%s/%s", collection, url.PathEscape(name))); err != nil {
+			return false, err
+		}
+	}
+	return len(stale) > 0, nil
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+// routePlugins returns the names of the plugins bound to the route with the given ID on the fake Kong.
+func routePlugins(kong *kongtest.Server, routeID interface{}) []string {
+	names := []string{}
+	for _, plugin := range kong.List("plugins") {
+		if route, ok := plugin["route"].(map[string]interface{}); ok && route["id"] == routeID {
+			names = append(names, plugin["name"].(string))
+		}
+	}
+	return names
+}
+
+func newRoutesConfig() *MicroserviceConfig {
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.Routes = []RouteConfig{
+		{
+			Name:    "public",
+			Paths:   []string{"/users"},
+			Methods: []string{"GET"},
+			Plugins: []PluginConfig{{Name: "rate-limiting", Config: map[string]interface{}{"minute": 100}}},
+		},
+		{
+			Name:      "internal",
+			Paths:     []string{"/users/admin"},
+			Hosts:     []string{"users.internal"},
+			Protocols: []string{"https"},
+			Headers:   map[string][]string{"X-Internal": {"true"}},
+			Plugins:   []PluginConfig{},
+		},
+	}
+	return config
+}
+
+func TestSelfRegisterRoutes(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	// Register the microservice with the flat configuration first.
+	config := newRoutesConfig()
+	routes := config.Routes
+	config.Routes = nil
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if kong.Get("routes", "user-microservice") == nil {
+		t.Fatal("Expected the route of the microservice to be registered")
+	}
+
+	config.Routes = routes
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if kong.Get("routes", "user-microservice") != nil {
+		t.Fatal("Expected the route of the flat configuration to be deleted")
+	}
+	public := kong.Get("routes", "user-microservice-public")
+	if public == nil || !sameStrings(toStrings(public["hosts"]), config.Hosts) || !sameStrings(toStrings(public["methods"]), []string{"GET"}) {
+		t.Fatalf("Expected the public route with the hosts of the microservice, got %v", public)
+	}
+	if plugins := routePlugins(kong, public["id"]); len(plugins) != 1 || plugins[0] != "rate-limiting" {
+		t.Fatalf("Expected rate limiting on the public route, got %v", plugins)
+	}
+	internal := kong.Get("routes", "user-microservice-internal")
+	if internal == nil || !sameStrings(toStrings(internal["hosts"]), []string{"users.internal"}) ||
+		!sameStrings(toStrings(internal["protocols"]), []string{"https"}) || internal["headers"] == nil {
+		t.Fatalf("Expected the internal route with its own hosts, protocols and headers, got %v", internal)
+	}
+	if plugins := routePlugins(kong, internal["id"]); len(plugins) != 0 {
+		t.Fatalf("Expected no plugins on the internal route, got %v", plugins)
+	}
+
+	changed, err := gateway.Reconcile(context.Background())
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("Expected no changes after the registration, got %v (%v)", changed, err)
+	}
+	plan, err := gateway.Plan(context.Background())
+	if err != nil || plan.HasChanges() {
+		t.Fatalf("Expected an empty plan after the registration, got %v (%v)", plan, err)
+	}
+
+	config.Routes = routes[:1]
+	plan, err = gateway.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Action != ActionDelete || plan.Changes[0].Name != "user-microservice-internal" {
+		t.Fatalf("Expected the internal route to be deleted, got %v", plan)
+	}
+	changed, err = gateway.Reconcile(context.Background())
+	if err != nil || strings.Join(changed, ",") != "route" {
+		t.Fatalf("Expected the route to be re-applied, got %v (%v)", changed, err)
+	}
+	if kong.Get("routes", "user-microservice-internal") != nil || kong.Get("routes", "user-microservice-public") == nil {
+		t.Fatalf("Expected only the public route to remain, got %v", kong.List("routes"))
+	}
+}
+
+func TestSelfRegisterRoutesLegacyAPI(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newRoutesConfig()
+	config.KongMode = KongModeAPIs
+	config.Routes[1].Protocols = nil
+	config.Routes[1].Headers = nil
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if kong.Get("apis", "user-microservice-public") == nil || kong.Get("apis", "user-microservice-internal") == nil {
+		t.Fatalf("Expected an API for every route, got %v", kong.List("apis"))
+	}
+
+	config.Routes = config.Routes[1:]
+	if plan, err := gateway.Plan(context.Background()); err != nil || len(plan.Changes) != 1 || plan.Changes[0].Kind != "api" {
+		t.Fatalf("Expected the public API to be deleted, got %v (%v)", plan, err)
+	}
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if apis := kong.List("apis"); len(apis) != 1 || apis[0]["name"] != "user-microservice-internal" {
+		t.Fatalf("Expected the public API to be deleted, got %v", apis)
+	}
+	for _, request := range kong.Requests() {
+		if strings.Contains(request, "services") {
+			t.Fatalf("Expected no requests for the services in the apis mode, got %s", request)
+		}
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	config := newRoutesConfig()
+	gateway := NewKongGateway("http://kong:8001", &http.Client{}, config)
+	if err := gateway.validateRoutes(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Routes[1].Name = "public"
+	if err := gateway.validateRoutes(); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Fatalf("Expected an error for the duplicate route names, got %v", err)
+	}
+
+	config.Routes[1].Name = "internal"
+	config.KongMode = KongModeAPIs
+	if err := gateway.validateRoutes(); err == nil {
+		t.Fatal("Expected an error for the headers in the apis Kong mode")
+	}
+
+	config.Routes = []RouteConfig{}
+	if err := gateway.validateRoutes(); err == nil {
+		t.Fatal("Expected an error for the empty list of routes")
+	}
+}
+
+func toStrings(value interface{}) []string {
+	values, _ := stringList(value)
+	return values
+}
@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+)
+
+// Service is a structure that represents Kong's Service object (Kong 1.x and newer).
+// See https://docs.konghq.com/gateway/latest/admin-api/#service-object
+type Service struct {
+	ID             string   `json:"id,omitempty"`
+	CreatedAt      int      `json:"created_at,omitempty"`
+	UpdatedAt      int      `json:"updated_at,omitempty"`
+	Name           string   `json:"name,omitempty"`
+	Protocol       string   `json:"protocol,omitempty"`
+	Host           string   `json:"host,omitempty"`
+	Port           int      `json:"port,omitempty"`
+	Path           string   `json:"path,omitempty"`
+	Retries        int      `json:"retries,omitempty"`
+	ConnectTimeout int      `json:"connect_timeout,omitempty"`
+	WriteTimeout   int      `json:"write_timeout,omitempty"`
+	ReadTimeout    int      `json:"read_timeout,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+
+	// ClientCertificate is the certificate presented by Kong to the upstream (Kong 1.3 and newer).
+	ClientCertificate *ObjectRef `json:"client_certificate,omitempty"`
+
+	// TLSVerify enables the verification of the upstream certificate (Kong 2.2 and newer).
+	TLSVerify *bool `json:"tls_verify,omitempty"`
+
+	// TLSVerifyDepth is the maximal depth of the upstream certificate chain (Kong 2.2 and newer).
+	TLSVerifyDepth *int `json:"tls_verify_depth,omitempty"`
+
+	// CACertificates holds the IDs of the CA certificates used to verify the upstream certificate (Kong 2.2 and newer).
+	CACertificates []string `json:"ca_certificates,omitempty"`
+}
+
+// Route is a structure that represents Kong's Route object (Kong 1.x and newer).
+// See https://docs.konghq.com/gateway/latest/admin-api/#route-object
+type Route struct {
+	ID           string     `json:"id,omitempty"`
+	CreatedAt    int        `json:"created_at,omitempty"`
+	UpdatedAt    int        `json:"updated_at,omitempty"`
+	Name         string     `json:"name,omitempty"`
+	Protocols    []string   `json:"protocols,omitempty"`
+	Methods      []string   `json:"methods,omitempty"`
+	Hosts        []string   `json:"hosts,omitempty"`
+	Paths        []string   `json:"paths,omitempty"`
+	StripPath    bool       `json:"strip_path"`
+	PreserveHost bool       `json:"preserve_host"`
+	Service      *ObjectRef `json:"service,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+
+	// Headers holds the values of the request headers matched by the Route (Kong 1.3 and newer).
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+// ObjectRef is a reference to another Kong object (foreign key), by ID or by name.
+type ObjectRef struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// NewServiceConf creates new Service object with sensible defaults.
+func NewServiceConf() *Service {
+	return &Service{
+		Protocol:       "http",
+		Retries:        5,
+		ConnectTimeout: 60000,
+		WriteTimeout:   60000,
+		ReadTimeout:    60000,
+	}
+}
+
+// NewRouteConf creates new Route object with sensible defaults.
+func NewRouteConf() *Route {
+	return &Route{
+		Protocols:    []string{"http", "https"},
+		StripPath:    false,
+		PreserveHost: false,
+	}
+}
+
+// registerServiceAndRoute creates or updates the Kong Service and the Route objects (one per route) for the microservice.
+func (kong *KongGateway) registerServiceAndRoute(ctx context.Context) error {
+	desired := kong.desiredService()
+	if err := kong.applyUpstreamTLS(ctx, desired); err != nil {
+		return err
+	}
+	service, err := kong.createOrUpdateService(ctx, desired)
+	if err != nil {
+		return err
+	}
+	for _, route := range kong.desiredRoutes() {
+		if _, err := kong.createOrUpdateRoute(ctx, service.Name, route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// desiredService maps the microservice configuration onto a Kong Service object.
+// The host of the Service is the name of the upstream, so Kong balances the requests between the targets.
+func (kong *KongGateway) desiredService() *Service {
+	serviceConf := NewServiceConf()
+	serviceConf.Name = kong.config.MicroserviceName
+	serviceConf.Protocol = kong.upstreamProtocol()
+	serviceConf.Host = kong.config.VirtualHost
+	serviceConf.Port = kong.config.MicroservicePort
+	serviceConf.Tags = kong.ownerTags()
+	if tlsConfig := kong.config.UpstreamTLS; tlsConfig != nil {
+		if tlsConfig.Verify != nil {
+			verify := *tlsConfig.Verify
+			serviceConf.TLSVerify = &verify
+		}
+		if tlsConfig.VerifyDepth > 0 {
+			depth := tlsConfig.VerifyDepth
+			serviceConf.TLSVerifyDepth = &depth
+		}
+	}
+	return serviceConf
+}
+
+// createOrUpdateService creates or updates (upserts) a Service object on Kong by its name.
+// Returns the Service object as stored on Kong.
+func (kong *KongGateway) createOrUpdateService(ctx context.Context, serviceConf *Service) (*Service, error) {
+	if serviceConf.Name == "" {
+		return nil, fmt.Errorf("service name is empty")
+	}
+	var service Service
+	if err := kong.request(ctx, "PUT", fmt.Sprintf("services/%s", serviceConf.Name), serviceConf, &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
+// createOrUpdateRoute creates or updates (upserts) a Route object by its name, bound to the Service with the given name.
+// Returns the Route object as stored on Kong.
+func (kong *KongGateway) createOrUpdateRoute(ctx context.Context, serviceName string, routeConf *Route) (*Route, error) {
+	if routeConf.Name == "" {
+		return nil, fmt.Errorf("route name is empty")
+	}
+	var route Route
+	if err := kong.request(ctx, "PUT", fmt.Sprintf("services/%s/routes/%s", serviceName, routeConf.Name), routeConf, &route); err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+// getService retrieves the Service object from Kong with the given name.
+// Returns the Service object if found, or nil if no such object exists on Kong.
+func (kong *KongGateway) getService(ctx context.Context, name string) (*Service, error) {
+	var service Service
+	err := kong.request(ctx, "GET", fmt.Sprintf("services/%s", name), nil, &service)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
+// getRoute retrieves the Route object from Kong with the given name.
+// Returns the Route object if found, or nil if no such object exists on Kong.
+func (kong *KongGateway) getRoute(ctx context.Context, name string) (*Route, error) {
+	var route Route
+	err := kong.request(ctx, "GET", fmt.Sprintf("routes/%s", name), nil, &route)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
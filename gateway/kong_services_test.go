@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+// MatchJSONField matches the requests with the given value of the JSON body field. The mismatches are logged
+// to the test.
+func MatchJSONField(t *testing.T, name string, value interface{}) gock.MatchFunc {
+	return func(req *http.Request, greq *gock.Request) (bool, error) {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return false, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			return false, err
+		}
+		expected, err := json.Marshal(value)
+		if err != nil {
+			return false, err
+		}
+		var expectedValue interface{}
+		if err := json.Unmarshal(expected, &expectedValue); err != nil {
+			return false, err
+		}
+		if !reflect.DeepEqual(body[name], expectedValue) {
+			t.Logf("%s %s: [%s]: [%v] does not match [%v]", req.Method, req.URL.Path, name, body[name], expectedValue)
+			return false, nil
+		}
+		return true, nil
+	}
+}
+
+type JSONMatcher struct {
+	Matcher *gock.MockMatcher
+	t       *testing.T
+}
+
+func (jm *JSONMatcher) Field(name string, value interface{}) *JSONMatcher {
+	jm.Matcher.Add(MatchJSONField(jm.t, name, value))
+	return jm
+}
+
+func NewJSONMatcher(t *testing.T) *JSONMatcher {
+	jm := JSONMatcher{
+		Matcher: gock.NewBasicMatcher(),
+		t:       t,
+	}
+	return &jm
+}
+
+func newServicesModeConfig() *MicroserviceConfig {
+	return &MicroserviceConfig{
+		MicroserviceName: "user-microservice",
+		MicroservicePort: 8080,
+		ServicesMaxSlots: 10,
+		VirtualHost:      "user.api.jormugandr.org",
+		Weight:           10,
+		Hosts:            []string{"localhost", "user.api.jormugandr.org"},
+		Paths:            []string{"/users"},
+		Methods:          []string{"GET", "POST"},
+		StripPath:        true,
+		KongMode:         KongModeServices,
+	}
+}
+
+func TestSelfRegisterServiceAndRoute(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":    "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"name":  "user.api.jormugandr.org",
+			"slots": 10,
+			"tags":  []string{"user-microservice"},
+		})
+
+	gock.New("http://kong:8001").
+		Put("/services/user-microservice").
+		SetMatcher(NewJSONMatcher(t).
+			Field("name", "user-microservice").
+			Field("host", "user.api.jormugandr.org").
+			Field("port", 8080).
+			Field("protocol", "http").
+			Matcher).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":       "0d4c5e2e-1e0e-4d3c-9a77-0f14c1b7c4c1",
+			"name":     "user-microservice",
+			"host":     "user.api.jormugandr.org",
+			"port":     8080,
+			"protocol": "http",
+		})
+
+	gock.New("http://kong:8001").
+		Put("/services/user-microservice/routes/user-microservice").
+		SetMatcher(NewJSONMatcher(t).
+			Field("name", "user-microservice").
+			Field("hosts", []string{"localhost", "user.api.jormugandr.org"}).
+			Field("paths", []string{"/users"}).
+			Field("methods", []string{"GET", "POST"}).
+			Field("strip_path", true).
+			Field("preserve_host", false).
+			Matcher).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":   "a8a14dd7-f0ad-4ba4-9f0a-7b5ba4d9d4e6",
+			"name": "user-microservice",
+		})
+
+	gock.New("http://kong:8001").
+		Post("/upstreams/user.api.jormugandr.org/targets").
+		SetMatcher(NewJSONMatcher(t).
+			Field("weight", 10).
+			Field("tags", []string{"user-microservice", "instance:user-1"}).
+			Matcher).
+		Reply(201).
+		JSON(map[string]interface{}{
+			"id":     "4661f55e-95c2-4011-8fd6-c5c56df1c9db",
+			"target": "1.2.3.4:8080",
+			"weight": 10,
+		})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, newServicesModeConfig())
+	gateway.InstanceID = "user-1"
+
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	if gock.IsPending() {
+		for _, mock := range gock.GetAll() {
+			t.Error("Mock:", mock.Request())
+		}
+		t.Fatal("Expected all HTTP calls to be made")
+	}
+}
+
+func TestSelfRegisterServiceError(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":    "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"name":  "user.api.jormugandr.org",
+			"slots": 10,
+			"tags":  []string{"user-microservice"},
+		})
+
+	gock.New("http://kong:8001").
+		Put("/services/user-microservice").
+		Reply(400).
+		JSON(map[string]interface{}{"message": "schema violation"})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, newServicesModeConfig())
+
+	if err := gateway.SelfRegister(); err == nil {
+		t.Fatal("Expected an error when Kong rejects the service")
+	}
+}
+
+func TestSelfRegisterUnsupportedMode(t *testing.T) {
+	config := newServicesModeConfig()
+	config.KongMode = "unknown"
+	gateway := NewKongGateway("http://kong:8001", &http.Client{}, config)
+
+	if err := gateway.SelfRegister(); err == nil {
+		t.Fatal("Expected an error for unsupported Kong mode")
+	}
+}
+
+func TestAddSelfAsTargetUpdatesExistingTarget(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	// Kong 3.x rejects a target that is already on the upstream.
+	gock.New("http://kong:8001").
+		Post("/upstreams/user.api.jormugandr.org/targets").
+		Reply(409).
+		JSON(map[string]string{"message": "UNIQUE violation detected on '{target=\"10.0.0.5:8080\"}'"})
+
+	gock.New("http://kong:8001").
+		Patch("/upstreams/user.api.jormugandr.org/targets/10.0.0.5:8080").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":     "a3395f66-2af6-4c79-bea2-1b6933764f80",
+			"target": "10.0.0.5:8080",
+			"weight": 10,
+		})
+
+	gock.InterceptClient(client)
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway("http://kong:8001", client, config)
+
+	target, err := gateway.addSelfAsTarget(context.Background(), config.VirtualHost, config.MicroservicePort, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.ID != "a3395f66-2af6-4c79-bea2-1b6933764f80" || target.Weight != 10 {
+		t.Fatalf("Unexpected target: %+v", target)
+	}
+	if !gock.IsDone() {
+		t.Fatal("Expected the existing target to be updated")
+	}
+}
@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// LivenessProbe checks whether the instance with the given target address (host:port) is alive.
+type LivenessProbe interface {
+	Alive(ctx context.Context, target string) bool
+}
+
+// LivenessProbeFunc is a function that implements LivenessProbe.
+type LivenessProbeFunc func(ctx context.Context, target string) bool
+
+// Alive calls the function.
+func (f LivenessProbeFunc) Alive(ctx context.Context, target string) bool {
+	return f(ctx, target)
+}
+
+// LiveTargets returns a LivenessProbe that considers alive only the given target addresses (host:port),
+// for example the addresses of the running containers reported by the orchestrator.
+func LiveTargets(targets ...string) LivenessProbe {
+	live := map[string]bool{}
+	for _, target := range targets {
+		live[target] = true
+	}
+	return LivenessProbeFunc(func(ctx context.Context, target string) bool {
+		return live[target]
+	})
+}
+
+// TCPProbe returns a LivenessProbe that considers the instance alive if it accepts a TCP connection
+// within the given timeout.
+func TCPProbe(timeout time.Duration) LivenessProbe {
+	return LivenessProbeFunc(func(ctx context.Context, target string) bool {
+		dialer := &net.Dialer{Timeout: timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", target)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+}
+
+// HTTPProbe returns a LivenessProbe that sends a GET request to the given path (for example "/healthcheck")
+// on the instance, and considers the instance alive if it responds with a status below 500.
+// If client is nil, http.DefaultClient is used. The request is sent over plain HTTP, use KongGateway.HTTPProbe
+// for the microservices with UpstreamTLS.
+func HTTPProbe(client *http.Client, path string) LivenessProbe {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return httpProbe(client, "http", path)
+}
+
+// HTTPProbe returns a LivenessProbe like the HTTPProbe function, that sends the request over HTTPS if UpstreamTLS
+// is configured. If client is nil, the certificate of the instance is verified only if UpstreamTLS.Verify is true,
+// with the SNI as server name, so an instance with a certificate unknown to this instance is not considered dead.
+func (kong *KongGateway) HTTPProbe(client *http.Client, path string) LivenessProbe {
+	tlsConfig := kong.config.UpstreamTLS
+	if client == nil && tlsConfig != nil {
+		client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					ServerName:         tlsConfig.SNI,
+					InsecureSkipVerify: !tlsConfig.verifies(),
+				},
+			},
+		}
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return httpProbe(client, kong.upstreamProtocol(), path)
+}
+
+// httpProbe returns a LivenessProbe that sends a GET request to the given path over the given scheme.
+func httpProbe(client *http.Client, scheme, path string) LivenessProbe {
+	return LivenessProbeFunc(func(ctx context.Context, target string) bool {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", scheme, target, path), nil)
+		if err != nil {
+			return false
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode < 500
+	})
+}
+
+// SweepTargets removes the targets of this microservice that are not alive according to the probe, for example
+// the targets left on Kong by crashed containers that never ran Unregister. The target of this instance is never
+// removed. In the services Kong mode only the targets tagged with the MicroserviceName are checked, so the targets
+// added by hand (or by older versions) are left untouched. In the apis mode, all targets of the upstream are checked.
+// Returns the addresses of the removed targets, or an error if the probe is nil.
+func (kong *KongGateway) SweepTargets(ctx context.Context, probe LivenessProbe) ([]string, error) {
+	if probe == nil {
+		return nil, fmt.Errorf("no liveness probe to sweep the targets of %s", kong.config.VirtualHost)
+	}
+	self, err := kong.selfTarget(kong.config.MicroservicePort)
+	if err != nil {
+		return nil, err
+	}
+	targets := []upstreamTarget{}
+	err = kong.listAll(ctx, fmt.Sprintf("upstreams/%s/targets", kong.config.VirtualHost), &targets)
+	if isNotFound(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	errs := []error{}
+	for _, target := range targets {
+		if target.Target == self || !containsStrings(target.Tags, kong.ownerTags()) {
+			continue
+		}
+		if probe.Alive(ctx, target.Target) {
+			continue
+		}
+		path := fmt.Sprintf("upstreams/%s/targets/%s", kong.config.VirtualHost, url.PathEscape(target.Target))
+		if err := kong.request(ctx, "DELETE", path, nil, nil); err != nil && !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("%s: %s", target.Target, err.Error()))
+			continue
+		}
+		removed = append(removed, target.Target)
+	}
+	if len(errs) > 0 {
+		return removed, &MultiError{Errors: errs}
+	}
+	return removed, nil
+}
+
+// SweepStatus holds the outcome of the last sweep.
+type SweepStatus struct {
+	// LastSweep is the time of the last sweep.
+	LastSweep time.Time
+
+	// Removed is the list of targets removed by the last sweep.
+	Removed []string
+
+	// Error is the error of the last sweep, or nil if it was successful.
+	Error error
+}
+
+// Sweeper periodically removes the stale targets of the microservice from Kong (see KongGateway.SweepTargets),
+// so the upstream does not fill up with the targets of the instances that are gone.
+// It is enough to run a Sweeper in one of the instances, but running it in all of them is safe.
+type Sweeper struct {
+	// Gateway is the Kong gateway of the microservice.
+	Gateway *KongGateway
+
+	// Probe checks whether the instances are alive.
+	Probe LivenessProbe
+
+	// Interval is the time between two sweeps.
+	Interval time.Duration
+
+	mutex  sync.RWMutex
+	status SweepStatus
+}
+
+// NewSweeper creates a Sweeper for the given Kong gateway and liveness probe that runs on the given interval.
+func NewSweeper(kong *KongGateway, probe LivenessProbe, interval time.Duration) *Sweeper {
+	return &Sweeper{
+		Gateway:  kong,
+		Probe:    probe,
+		Interval: interval,
+	}
+}
+
+// Run sweeps the stale targets immediately and then on every interval, until the context is done.
+// Returns an error if the Interval is not positive, or the context error once the context is done.
+func (s *Sweeper) Run(ctx context.Context) error {
+	return runEvery(ctx, s.Interval, func(ctx context.Context) {
+		s.Sweep(ctx)
+	})
+}
+
+// Sweep performs a single sweep with the given context and records its status.
+func (s *Sweeper) Sweep(ctx context.Context) ([]string, error) {
+	removed, err := s.Gateway.SweepTargets(ctx, s.Probe)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.status = SweepStatus{
+		LastSweep: time.Now(),
+		Removed:   removed,
+		Error:     err,
+	}
+	return removed, err
+}
+
+// Status returns the status of the last sweep.
+func (s *Sweeper) Status() SweepStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.status
+}
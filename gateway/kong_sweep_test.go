@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+func TestSweepTargetsRemovesDeadTargets(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	gateway.InstanceID = "user-1"
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	self := kong.Children("upstreams", "user.api.jormugandr.org", "targets")[0]
+	if tags := self["tags"].([]interface{}); len(tags) != 2 || tags[1] != "instance:user-1" {
+		t.Fatalf("Expected the target to be tagged with the instance ID, got %v", tags)
+	}
+
+	for _, instance := range []string{"10.0.0.6", "10.0.0.7"} {
+		otherConfig := *config
+		otherConfig.AdvertiseAddress = instance
+		if err := NewKongGateway(kong.URL, &http.Client{}, &otherConfig).SelfRegister(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kong.Add("targets", kongtest.Object{
+		"target":   "10.0.0.8:8080",
+		"upstream": kongtest.Object{"id": kong.Get("upstreams", "user.api.jormugandr.org")["id"]},
+	})
+
+	removed, err := gateway.SweepTargets(context.Background(), LiveTargets("10.0.0.6:8080"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "10.0.0.7:8080" {
+		t.Fatalf("Expected only the dead owned target to be removed, got %v", removed)
+	}
+
+	targets := []string{}
+	for _, target := range kong.Children("upstreams", "user.api.jormugandr.org", "targets") {
+		targets = append(targets, target["target"].(string))
+	}
+	if !sameStrings(targets, []string{"10.0.0.5:8080", "10.0.0.6:8080", "10.0.0.8:8080"}) {
+		t.Fatalf("Unexpected targets after the sweep: %v", targets)
+	}
+}
+
+func TestSweeperRecordsStatus(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	sweeper := NewSweeper(NewKongGateway(kong.URL, &http.Client{}, config), LiveTargets(), time.Minute)
+
+	if _, err := sweeper.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := sweeper.Status(); status.Error != nil || status.LastSweep.IsZero() || len(status.Removed) != 0 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+
+	kong.FailNext(1, 500)
+	if _, err := sweeper.Sweep(context.Background()); err == nil {
+		t.Fatal("Expected the sweep to fail")
+	}
+	if status := sweeper.Status(); status.Error == nil {
+		t.Fatalf("Expected the error in the status, got %+v", status)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/healthcheck" {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	live := strings.TrimPrefix(server.URL, "http://")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := listener.Addr().String()
+	listener.Close()
+
+	ctx := context.Background()
+	if !TCPProbe(time.Second).Alive(ctx, live) || TCPProbe(time.Second).Alive(ctx, dead) {
+		t.Fatal("Unexpected result of the TCP probe")
+	}
+	if !HTTPProbe(nil, "/healthcheck").Alive(ctx, live) || HTTPProbe(nil, "/other").Alive(ctx, live) {
+		t.Fatal("Unexpected result of the HTTP probe")
+	}
+
+	tlsServer := httptest.NewTLSServer(server.Config.Handler)
+	defer tlsServer.Close()
+	config := newServicesModeConfig()
+	config.UpstreamTLS = &UpstreamTLSConfig{SNI: "users.internal"}
+	gateway := NewKongGateway("http://kong:8001", &http.Client{}, config)
+	if !gateway.HTTPProbe(nil, "/healthcheck").Alive(ctx, strings.TrimPrefix(tlsServer.URL, "https://")) {
+		t.Fatal("Expected the HTTPS probe of the instance with the upstream TLS to succeed")
+	}
+}
+
+func TestSweepTargetsWithoutProbe(t *testing.T) {
+	gateway := NewKongGateway("http://kong:8001", &http.Client{}, newServicesModeConfig())
+	if _, err := gateway.SweepTargets(context.Background(), nil); err == nil {
+		t.Fatal("Expected an error for the missing probe")
+	}
+}
@@ -56,6 +56,25 @@ func TestSelfRegisterNoUpstreamNoAPI(t *testing.T) {
 
 	defer gock.Off()
 
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(404).
+		JSON(map[string]string{"message": "Not Found"})
+
+	gock.New("http://kong:8001").
+		Post("/upstreams/").
+		SetMatcher(NewJSONMatcher(t).
+			Field("name", "user.api.jormugandr.org").
+			Field("slots", 10).
+			Matcher).
+		Reply(201).
+		JSON(map[string]interface{}{
+			"id":         "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"name":       "user.api.jormugandr.org",
+			"slots":      10,
+			"created_at": 1485521710265,
+		})
+
 	gock.New("http://kong:8001").
 		Get("/apis/user-microservice").
 		Reply(404).
@@ -96,6 +115,21 @@ func TestSelfRegisterNoUpstreamNoAPI(t *testing.T) {
 			"upstream_url":             "http://user.api.jormugandr.org:8080",
 		})
 
+	gock.New("http://kong:8001").
+		Post("/upstreams/user.api.jormugandr.org/targets").
+		SetMatcher(NewFormMatcher().
+			FormParamPattern("target", "\\d+\\.\\d+\\.\\d+\\.\\d+:8080").
+			FormParam("weight", "10").
+			Matcher).
+		Reply(201).
+		JSON(map[string]interface{}{
+			"id":          "4661f55e-95c2-4011-8fd6-c5c56df1c9db",
+			"target":      "1.2.3.4:8080",
+			"weight":      10,
+			"upstream_id": "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"created_at":  1485523507446,
+		})
+
 	gock.InterceptClient(client)
 
 	config := &MicroserviceConfig{
@@ -128,6 +162,16 @@ func TestSelfRegisterNoAPI(t *testing.T) {
 
 	defer gock.Off()
 
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":         "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"name":       "user.api.jormugandr.org",
+			"slots":      10,
+			"created_at": 1485521710265,
+		})
+
 	gock.New("http://kong:8001").
 		Get("/apis/user-microservice").
 		Reply(404).
@@ -168,6 +212,21 @@ func TestSelfRegisterNoAPI(t *testing.T) {
 			"upstream_url":             "http://user.api.jormugandr.org:8080",
 		})
 
+	gock.New("http://kong:8001").
+		Post("/upstreams/user.api.jormugandr.org/targets").
+		SetMatcher(NewFormMatcher().
+			FormParamPattern("target", "\\d+\\.\\d+\\.\\d+\\.\\d+:8080").
+			FormParam("weight", "10").
+			Matcher).
+		Reply(201).
+		JSON(map[string]interface{}{
+			"id":          "4661f55e-95c2-4011-8fd6-c5c56df1c9db",
+			"target":      "1.2.3.4:8080",
+			"weight":      10,
+			"upstream_id": "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"created_at":  1485523507446,
+		})
+
 	gock.InterceptClient(client)
 
 	config := &MicroserviceConfig{
@@ -200,6 +259,16 @@ func TestSelfRegisterWithAPIAndUpstream(t *testing.T) {
 
 	defer gock.Off()
 
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":         "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"name":       "user.api.jormugandr.org",
+			"slots":      10,
+			"created_at": 1485521710265,
+		})
+
 	gock.New("http://kong:8001").
 		Get("/apis/user-microservice").
 		Reply(200).
@@ -243,6 +312,21 @@ func TestSelfRegisterWithAPIAndUpstream(t *testing.T) {
 			"upstream_url":             "http://user.api.jormugandr.org:8080",
 		})
 
+	gock.New("http://kong:8001").
+		Post("/upstreams/user.api.jormugandr.org/targets").
+		SetMatcher(NewFormMatcher().
+			FormParamPattern("target", "\\d+\\.\\d+\\.\\d+\\.\\d+:8080").
+			FormParam("weight", "10").
+			Matcher).
+		Reply(201).
+		JSON(map[string]interface{}{
+			"id":          "4661f55e-95c2-4011-8fd6-c5c56df1c9db",
+			"target":      "1.2.3.4:8080",
+			"weight":      10,
+			"upstream_id": "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"created_at":  1485523507446,
+		})
+
 	gock.InterceptClient(client)
 
 	config := &MicroserviceConfig{
@@ -266,7 +350,7 @@ func TestSelfRegisterWithAPIAndUpstream(t *testing.T) {
 			t.Error("Mock:", mock.Request())
 		}
 
-		panic(fmt.Sprintf("Expected 3 HTTP calls to be made, but there are %d still pending", len(all)))
+		panic(fmt.Sprintf("Expected 4 HTTP calls to be made, but there are %d still pending", len(all)))
 	}
 }
 
@@ -331,19 +415,8 @@ func TestUnregister(t *testing.T) {
 	defer gock.Off()
 
 	gock.New("http://kong:8001").
-		Post("/upstreams/user.api.jormugandr.org/targets").
-		SetMatcher(NewFormMatcher().
-			FormParamPattern("target", "\\d+\\.\\d+\\.\\d+\\.\\d+:\\d+").
-			FormParam("weight", "0").
-			Matcher).
-		Reply(201).
-		JSON(map[string]interface{}{
-			"id":          "4661f55e-95c2-4011-8fd6-c5c56df1c9db",
-			"target":      "1.2.3.4:80",
-			"weight":      10,
-			"upstream_id": "ee3310c1-6789-40ac-9386-f79c0cb58432",
-			"created_at":  1485523507446,
-		})
+		Delete("/upstreams/user.api.jormugandr.org/targets/\\d+\\.\\d+\\.\\d+\\.\\d+:8080").
+		Reply(204)
 
 	gock.InterceptClient(client)
 
@@ -368,6 +441,104 @@ func TestUnregister(t *testing.T) {
 			t.Error("Mock:", mock.Request())
 		}
 
-		panic(fmt.Sprintf("Expected an HTTP call to upstream/{}/targets"))
+		panic(fmt.Sprintf("Expected a DELETE HTTP call to upstream/{}/targets/{}"))
+	}
+}
+
+func TestUnregisterTargetNotFound(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Delete("/upstreams/user.api.jormugandr.org/targets/.+").
+		Reply(404).
+		JSON(map[string]string{"message": "Not Found"})
+
+	gock.InterceptClient(client)
+
+	config := &MicroserviceConfig{
+		MicroserviceName: "user-microservice",
+		MicroservicePort: 8080,
+		VirtualHost:      "user.api.jormugandr.org",
+	}
+	gateway := NewKongGateway("http://kong:8001", client, config)
+
+	if err := gateway.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelfRegisterUpdatesUpstreamSlots(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":    "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"name":  "user.api.jormugandr.org",
+			"slots": 100,
+		})
+
+	gock.New("http://kong:8001").
+		Patch("/upstreams/user.api.jormugandr.org").
+		SetMatcher(NewJSONMatcher(t).
+			Field("slots", 10).
+			Matcher).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"id":    "ee3310c1-6789-40ac-9386-f79c0cb58432",
+			"name":  "user.api.jormugandr.org",
+			"slots": 10,
+		})
+
+	gock.New("http://kong:8001").
+		Get("/apis/user-microservice").
+		Reply(404).
+		JSON(map[string]string{"message": "Not Found"})
+
+	gock.New("http://kong:8001").
+		Post("/apis/").
+		Reply(201).
+		JSON(map[string]interface{}{
+			"id":   "6378122c-a0a1-438d-a5c6-efabae9fb969",
+			"name": "user-microservice",
+		})
+
+	gock.New("http://kong:8001").
+		Post("/upstreams/user.api.jormugandr.org/targets").
+		SetMatcher(NewFormMatcher().
+			FormParam("weight", "100").
+			Matcher).
+		Reply(201).
+		JSON(map[string]interface{}{
+			"id":     "4661f55e-95c2-4011-8fd6-c5c56df1c9db",
+			"target": "1.2.3.4:8080",
+			"weight": 100,
+		})
+
+	gock.InterceptClient(client)
+
+	config := &MicroserviceConfig{
+		MicroserviceName: "user-microservice",
+		MicroservicePort: 8080,
+		ServicesMaxSlots: 10,
+		VirtualHost:      "user.api.jormugandr.org",
+		Hosts:            []string{"user.api.jormugandr.org"},
+	}
+	gateway := NewKongGateway("http://kong:8001", client, config)
+
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	if gock.IsPending() {
+		for _, mock := range gock.GetAll() {
+			t.Error("Mock:", mock.Request())
+		}
+		t.Fatal("Expected 5 HTTP calls to be made")
 	}
 }
@@ -0,0 +1,228 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// UpstreamTLSConfig is the configuration of the TLS connection from the gateway to the microservice.
+// If set, the gateway proxies the requests to the microservice over HTTPS.
+type UpstreamTLSConfig struct {
+	// ClientCertFile is the location of the PEM encoded client certificate that the gateway presents to the
+	// microservice (mutual TLS). The certificate is uploaded to the Kong certificate store. Requires Kong 1.3 or newer.
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+
+	// ClientKeyFile is the location of the PEM encoded private key of the client certificate.
+	ClientKeyFile string `json:"client_key_file,omitempty"`
+
+	// CACertFile is the location of the PEM encoded CA certificate used to verify the certificate of the microservice.
+	// The certificate is uploaded to the Kong CA certificate store. Requires Kong 2.2 or newer.
+	CACertFile string `json:"ca_cert_file,omitempty"`
+
+	// Verify enables (true) or disables (false) the verification of the certificate of the microservice.
+	// Requires Kong 2.2 or newer. If not set, the global setting of Kong (nginx_proxy_proxy_ssl_verify) is used.
+	Verify *bool `json:"verify,omitempty"`
+
+	// VerifyDepth is the maximal depth of the certificate chain of the microservice when verifying it.
+	VerifyDepth int `json:"verify_depth,omitempty"`
+
+	// SNI is the server name sent to the microservice in the TLS handshake (and in the Host header).
+	// If not set, the VirtualHost is used.
+	SNI string `json:"sni,omitempty"`
+}
+
+// verifies reports whether the verification of the certificate of the microservice is enabled.
+func (c *UpstreamTLSConfig) verifies() bool {
+	return c.Verify != nil && *c.Verify
+}
+
+// Certificate is a structure that represents Kong's Certificate object (Kong 1.x and newer).
+// See https://docs.konghq.com/gateway/latest/admin-api/#certificate-object
+type Certificate struct {
+	ID        string   `json:"id,omitempty"`
+	CreatedAt int      `json:"created_at,omitempty"`
+	Cert      string   `json:"cert,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	SNIs      []string `json:"snis,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// CACertificate is a structure that represents Kong's CA Certificate object (Kong 1.3 and newer).
+// See https://docs.konghq.com/gateway/latest/admin-api/#ca-certificate-object
+type CACertificate struct {
+	ID        string   `json:"id,omitempty"`
+	CreatedAt int      `json:"created_at,omitempty"`
+	Cert      string   `json:"cert,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+const (
+	// upstreamClientCertTag marks the client certificate of the microservice on Kong.
+	upstreamClientCertTag = "upstream-client-certificate"
+
+	// upstreamCACertTag marks the CA certificate of the microservice on Kong.
+	upstreamCACertTag = "upstream-ca-certificate"
+)
+
+// upstreamProtocol returns the protocol used by the gateway to reach the microservice: "https" if the upstream TLS
+// is configured, and "http" otherwise.
+func (kong *KongGateway) upstreamProtocol() string {
+	if kong.config.UpstreamTLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// validateUpstreamTLS checks that only HTTPS is configured in the (legacy) apis Kong mode, because Kong 0.x
+// does not support client certificates, CA certificates or certificate verification of the upstream.
+func (kong *KongGateway) validateUpstreamTLS() error {
+	tlsConfig := kong.config.UpstreamTLS
+	if tlsConfig == nil || kong.config.KongMode == KongModeServices {
+		return nil
+	}
+	if tlsConfig.ClientCertFile != "" || tlsConfig.CACertFile != "" || tlsConfig.verifies() || tlsConfig.SNI != "" {
+		return fmt.Errorf("upstream certificates, verification and SNI are supported only in the %q Kong mode", KongModeServices)
+	}
+	return nil
+}
+
+// upstreamCertificate is a certificate of the microservice that is uploaded to the Kong certificate store.
+type upstreamCertificate struct {
+	// collection is the Kong collection of the certificate, "certificates" or "ca_certificates".
+	collection string
+
+	// tag marks the certificate on Kong, together with the owner tags.
+	tag string
+
+	// values holds the PEM encoded certificate (and key) as sent to Kong.
+	values map[string]interface{}
+}
+
+// upstreamCertificates reads the client certificate and the CA certificate of the microservice, and checks that
+// the client certificate matches its key.
+func (kong *KongGateway) upstreamCertificates() ([]upstreamCertificate, error) {
+	tlsConfig := kong.config.UpstreamTLS
+	certificates := []upstreamCertificate{}
+	if tlsConfig == nil {
+		return certificates, nil
+	}
+	if tlsConfig.ClientCertFile != "" {
+		if tlsConfig.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client key file is required for the upstream client certificate")
+		}
+		if _, err := tls.LoadX509KeyPair(tlsConfig.ClientCertFile, tlsConfig.ClientKeyFile); err != nil {
+			return nil, err
+		}
+		cert, err := ioutil.ReadFile(tlsConfig.ClientCertFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ioutil.ReadFile(tlsConfig.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, upstreamCertificate{
+			collection: "certificates",
+			tag:        upstreamClientCertTag,
+			values:     map[string]interface{}{"cert": string(cert), "key": string(key)},
+		})
+	}
+	if tlsConfig.CACertFile != "" {
+		cert, err := ioutil.ReadFile(tlsConfig.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, upstreamCertificate{
+			collection: "ca_certificates",
+			tag:        upstreamCACertTag,
+			values:     map[string]interface{}{"cert": string(cert)},
+		})
+	}
+	return certificates, nil
+}
+
+// applyUpstreamTLS uploads the client certificate and the CA certificate of the microservice to Kong,
+// and sets the references to them on the Service.
+func (kong *KongGateway) applyUpstreamTLS(ctx context.Context, service *Service) error {
+	certificates, err := kong.upstreamCertificates()
+	if err != nil {
+		return err
+	}
+	for _, certificate := range certificates {
+		id, err := kong.upsertTaggedCertificate(ctx, certificate.collection, certificate.tag, certificate.values)
+		if err != nil {
+			return err
+		}
+		setServiceCertificate(service, certificate.collection, id)
+	}
+	return nil
+}
+
+// setServiceCertificate sets the reference to the certificate with the given ID from the collection on the Service.
+func setServiceCertificate(service *Service, collection, id string) {
+	if collection == "ca_certificates" {
+		service.CACertificates = []string{id}
+		return
+	}
+	service.ClientCertificate = &ObjectRef{ID: id}
+}
+
+// findTaggedCertificate returns the certificate in the collection ("certificates" or "ca_certificates") tagged with
+// the MicroserviceName and the given tag, or nil if there is no such certificate.
+func (kong *KongGateway) findTaggedCertificate(ctx context.Context, collection, tag string) (map[string]interface{}, error) {
+	existing := []map[string]interface{}{}
+	query := url.QueryEscape(strings.Join(append(kong.ownerTags(), tag), ","))
+	if err := kong.listAll(ctx, fmt.Sprintf("%s?tags=%s", collection, query), &existing); err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		return nil, nil
+	}
+	return existing[0], nil
+}
+
+// upsertTaggedCertificate finds the certificate in the collection ("certificates" or "ca_certificates") tagged with
+// the MicroserviceName and the given tag. The certificate is updated if its values differ, or created if it does
+// not exist. Returns the ID of the certificate.
+func (kong *KongGateway) upsertTaggedCertificate(ctx context.Context, collection, tag string, values map[string]interface{}) (string, error) {
+	existing, err := kong.findTaggedCertificate(ctx, collection, tag)
+	if err != nil {
+		return "", err
+	}
+	values["tags"] = append(kong.ownerTags(), tag)
+
+	if existing != nil {
+		id := stringValue(existing["id"])
+		if containsJSON(existing, values) {
+			return id, nil
+		}
+		if err := kong.request(ctx, "PATCH", fmt.Sprintf("%s/%s", collection, id), values, nil); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	var created map[string]interface{}
+	if err := kong.request(ctx, "POST", collection, values, &created); err != nil {
+		return "", err
+	}
+	return stringValue(created["id"]), nil
+}
+
+// serviceTLSDiffers checks whether the TLS settings of the Service on Kong differ from the desired settings.
+func serviceTLSDiffers(desired, current *Service) bool {
+	if desired.ClientCertificate != nil && (current.ClientCertificate == nil || current.ClientCertificate.ID != desired.ClientCertificate.ID) {
+		return true
+	}
+	if desired.CACertificates != nil && !sameStrings(current.CACertificates, desired.CACertificates) {
+		return true
+	}
+	if desired.TLSVerify != nil && (current.TLSVerify == nil || *current.TLSVerify != *desired.TLSVerify) {
+		return true
+	}
+	return desired.TLSVerifyDepth != nil && (current.TLSVerifyDepth == nil || *current.TLSVerifyDepth != *desired.TLSVerifyDepth)
+}
@@ -0,0 +1,228 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+func boolPtr(value bool) *bool {
+	return &value
+}
+
+// writeCertificate generates a self-signed certificate with the given common name and writes it, with its key,
+// as PEM into the directory. Returns the locations of the certificate and the key.
+func writeCertificate(t *testing.T, dir, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", certDER)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	dir, err := ioutil.TempDir("", "upstream-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCertificate(t, dir, "gateway")
+	caFile, _ := writeCertificate(t, dir, "ca")
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.UpstreamTLS = &UpstreamTLSConfig{
+		ClientCertFile: certFile,
+		ClientKeyFile:  keyFile,
+		CACertFile:     caFile,
+		Verify:         boolPtr(true),
+		VerifyDepth:    2,
+		SNI:            "users.internal",
+	}
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	for i := 0; i < 2; i++ {
+		if err := gateway.SelfRegister(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	certificates := kong.List("certificates")
+	caCertificates := kong.List("ca_certificates")
+	if len(certificates) != 1 || len(caCertificates) != 1 {
+		t.Fatalf("Expected the certificates to be uploaded once, got %v and %v", certificates, caCertificates)
+	}
+	service := kong.Get("services", "user-microservice")
+	if service["protocol"] != "https" || service["tls_verify"] != true || service["tls_verify_depth"] != float64(2) {
+		t.Fatalf("Unexpected service: %v", service)
+	}
+	if ref := service["client_certificate"].(map[string]interface{}); ref["id"] != certificates[0]["id"] {
+		t.Fatalf("Expected the service to reference the client certificate, got %v", ref)
+	}
+	if ids := service["ca_certificates"].([]interface{}); len(ids) != 1 || ids[0] != caCertificates[0]["id"] {
+		t.Fatalf("Expected the service to reference the CA certificate, got %v", ids)
+	}
+	if upstream := kong.Get("upstreams", "user.api.jormugandr.org"); upstream["host_header"] != "users.internal" {
+		t.Fatalf("Expected the SNI to be set on the upstream, got %v", upstream)
+	}
+
+	if changed, err := gateway.Reconcile(context.Background()); err != nil || len(changed) != 0 {
+		t.Fatalf("Expected no changes, got %v (%v)", changed, err)
+	}
+
+	certFile, keyFile = writeCertificate(t, dir, "gateway")
+	if _, err := gateway.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	certificates = kong.List("certificates")
+	cert, _ := ioutil.ReadFile(certFile)
+	if len(certificates) != 1 || certificates[0]["cert"] != string(cert) {
+		t.Fatalf("Expected the rotated client certificate to be updated, got %v", certificates)
+	}
+}
+
+func TestDisableUpstreamTLSVerification(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.UpstreamTLS = &UpstreamTLSConfig{Verify: boolPtr(true)}
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.UpstreamTLS.Verify = boolPtr(false)
+	plan, err := gateway.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Kind != "service" || !strings.Contains(plan.String(), "tls_verify: true -> false") {
+		t.Fatalf("Expected the verification to be disabled on the service, got:\n%s", plan)
+	}
+	changed, err := gateway.Reconcile(context.Background())
+	if err != nil || strings.Join(changed, ",") != "service" {
+		t.Fatalf("Expected the service to be re-applied, got %v (%v)", changed, err)
+	}
+	if service := kong.Get("services", "user-microservice"); service["tls_verify"] != false {
+		t.Fatalf("Expected the verification to be disabled on Kong, got %v", service)
+	}
+}
+
+func TestUpstreamTLSInAPIsMode(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.KongMode = KongModeAPIs
+	config.AdvertiseAddress = "10.0.0.5"
+	config.UpstreamTLS = &UpstreamTLSConfig{}
+	if err := NewKongGateway(kong.URL, &http.Client{}, config).SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if api := kong.Get("apis", "user-microservice"); api["upstream_url"] != "https://user.api.jormugandr.org:8080" {
+		t.Fatalf("Expected an HTTPS upstream URL, got %v", api)
+	}
+
+	config.UpstreamTLS.Verify = boolPtr(true)
+	if err := NewKongGateway(kong.URL, &http.Client{}, config).SelfRegister(); err == nil {
+		t.Fatal("Expected an error for the certificate verification in the apis mode")
+	}
+	if _, err := NewKongGateway(kong.URL, &http.Client{}, config).Plan(context.Background()); err == nil {
+		t.Fatal("Expected the plan to fail for the certificate verification in the apis mode")
+	}
+}
+
+func TestPlanUpstreamTLS(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	dir, err := ioutil.TempDir("", "upstream-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCertificate(t, dir, "gateway")
+	caFile, _ := writeCertificate(t, dir, "ca")
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	config.UpstreamTLS = &UpstreamTLSConfig{ClientCertFile: certFile, ClientKeyFile: keyFile, CACertFile: caFile, Verify: boolPtr(true)}
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+
+	plan, err := gateway.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{}
+	for _, change := range plan.Changes {
+		kinds = append(kinds, change.Kind)
+	}
+	if strings.Join(kinds, ",") != "upstream,certificate,ca_certificate,service,route,target" {
+		t.Fatalf("Unexpected changes: %v", kinds)
+	}
+	key, _ := ioutil.ReadFile(keyFile)
+	if text := plan.String(); strings.Contains(text, string(key)) || !strings.Contains(text, `client_certificate: {"id":"(known after upload)"}`) ||
+		!strings.Contains(text, "tls_verify: true") {
+		t.Fatalf("Expected the certificate references without the key, got:\n%s", text)
+	}
+	if len(kong.List("certificates")) != 0 {
+		t.Fatal("Expected nothing to be uploaded to Kong")
+	}
+
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := gateway.Plan(context.Background()); err != nil || plan.HasChanges() {
+		t.Fatalf("Expected no changes after the registration, got %v (%v)", plan, err)
+	}
+
+	writeCertificate(t, dir, "gateway")
+	plan, err = gateway.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Kind != "certificate" || plan.Changes[0].Action != ActionUpdate {
+		t.Fatalf("Expected only the rotated client certificate to be updated, got:\n%s", plan)
+	}
+
+	config.UpstreamTLS.ClientKeyFile = ""
+	if _, err := gateway.Plan(context.Background()); err == nil {
+		t.Fatal("Expected an error for the missing client key")
+	}
+}
@@ -0,0 +1,294 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// VersionTagPrefix is the prefix of the tag holding the version label on the target of the instance.
+	VersionTagPrefix = "version:"
+
+	// TrafficTagPrefix is the prefix of the upstream tags holding the traffic split between the versions,
+	// for example "traffic:v1:90" and "traffic:v2:10".
+	TrafficTagPrefix = "traffic:"
+
+	// TrafficWeightScale is the total weight of the targets of a version that receives 100% of the traffic.
+	// The weight is divided between the targets of the version.
+	TrafficWeightScale = 1000
+)
+
+// TrafficSplit maps the version labels of a microservice to the percentage of the traffic sent to that version.
+// The percentages must add up to 100.
+type TrafficSplit map[string]int
+
+// Validate checks that the percentages are between 0 and 100, and that they add up to 100.
+func (s TrafficSplit) Validate() error {
+	if len(s) == 0 {
+		return fmt.Errorf("traffic split without versions")
+	}
+	total := 0
+	for version, percent := range s {
+		if version == "" {
+			return fmt.Errorf("traffic split with an empty version")
+		}
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("traffic percentage of version %s must be between 0 and 100, got %d", version, percent)
+		}
+		total += percent
+	}
+	if total != 100 {
+		return fmt.Errorf("traffic percentages must add up to 100, got %d", total)
+	}
+	return nil
+}
+
+// String returns the traffic split as "v1=90% v2=10%", ordered by version.
+func (s TrafficSplit) String() string {
+	parts := []string{}
+	for _, version := range s.versions() {
+		parts = append(parts, fmt.Sprintf("%s=%d%%", version, s[version]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s TrafficSplit) versions() []string {
+	versions := []string{}
+	for version := range s {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// TrafficSplit returns the traffic split between the versions stored on the upstream of the microservice,
+// or nil if no split is set.
+func (kong *KongGateway) TrafficSplit(ctx context.Context) (TrafficSplit, error) {
+	up, err := kong.getUpstreamObj(ctx, kong.config.VirtualHost)
+	if err != nil || up == nil {
+		return nil, err
+	}
+	return parseTrafficSplit(up.Tags), nil
+}
+
+// SetTrafficSplit stores the traffic split on the upstream of the microservice, and sets the weights of the targets
+// of all versions so each version receives its percentage of the traffic. The weight of a version is divided equally
+// between its targets, and the targets of versions that are not in the split receive no traffic. The targets without
+// a version label are not changed.
+// The instances keep their weights in line with the split when they register or reconcile, so the Reconciler does
+// not undo the split. Requires the services Kong mode.
+func (kong *KongGateway) SetTrafficSplit(ctx context.Context, split TrafficSplit) error {
+	if kong.config.KongMode != KongModeServices {
+		return fmt.Errorf("traffic split is supported only in the %q Kong mode", KongModeServices)
+	}
+	if err := split.Validate(); err != nil {
+		return err
+	}
+	up, err := kong.getUpstreamObj(ctx, kong.config.VirtualHost)
+	if err != nil {
+		return err
+	}
+	if up == nil {
+		return fmt.Errorf("upstream %s not found", kong.config.VirtualHost)
+	}
+
+	tags := []string{}
+	for _, tag := range up.Tags {
+		if !strings.HasPrefix(tag, TrafficTagPrefix) {
+			tags = append(tags, tag)
+		}
+	}
+	for _, version := range split.versions() {
+		tags = append(tags, fmt.Sprintf("%s%s:%d", TrafficTagPrefix, version, split[version]))
+	}
+	if err := kong.request(ctx, "PATCH", fmt.Sprintf("upstreams/%s", kong.config.VirtualHost), map[string][]string{"tags": tags}, nil); err != nil {
+		return err
+	}
+
+	targets, err := kong.versionTargets(ctx)
+	if err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	for _, version := range targets {
+		counts[version]++
+	}
+	for target, version := range targets {
+		weight := versionWeight(split[version], counts[version])
+		path := fmt.Sprintf("upstreams/%s/targets/%s", kong.config.VirtualHost, url.PathEscape(target))
+		if err := kong.request(ctx, "PATCH", path, map[string]int{"weight": weight}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instanceWeight returns the weight of the target for this instance. If the instance has a version label and
+// a traffic split is set on the upstream, the weight is computed from the percentage of its version and the number
+// of targets of the version. Otherwise, the configured weight is used.
+func (kong *KongGateway) instanceWeight(ctx context.Context) (int, error) {
+	if kong.config.Version == "" || kong.config.KongMode != KongModeServices {
+		return kong.targetWeight(), nil
+	}
+	split, err := kong.TrafficSplit(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if split == nil {
+		return kong.targetWeight(), nil
+	}
+	self, err := kong.selfTarget(kong.config.MicroservicePort)
+	if err != nil {
+		return 0, err
+	}
+	targets, err := kong.versionTargets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	targets[self] = kong.config.Version
+	count := 0
+	for _, version := range targets {
+		if version == kong.config.Version {
+			count++
+		}
+	}
+	return versionWeight(split[kong.config.Version], count), nil
+}
+
+// versionTargets returns the version labels of the targets of the upstream, by target address.
+// The targets without a version label are left out.
+func (kong *KongGateway) versionTargets(ctx context.Context) (map[string]string, error) {
+	targets := []upstreamTarget{}
+	err := kong.listAll(ctx, fmt.Sprintf("upstreams/%s/targets", kong.config.VirtualHost), &targets)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	versions := map[string]string{}
+	for _, target := range targets {
+		for _, tag := range target.Tags {
+			if strings.HasPrefix(tag, VersionTagPrefix) {
+				versions[target.Target] = strings.TrimPrefix(tag, VersionTagPrefix)
+			}
+		}
+	}
+	return versions, nil
+}
+
+// versionWeight divides the weight of a version with the given traffic percentage between its targets.
+// Every target of a version that receives traffic has a weight of at least 1.
+func versionWeight(percent, targets int) int {
+	if percent <= 0 || targets <= 0 {
+		return 0
+	}
+	weight := percent * TrafficWeightScale / 100 / targets
+	if weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// parseTrafficSplit reads the traffic split from the upstream tags. Returns nil if there are no traffic tags.
+func parseTrafficSplit(tags []string) TrafficSplit {
+	var split TrafficSplit
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, TrafficTagPrefix) {
+			continue
+		}
+		value := strings.TrimPrefix(tag, TrafficTagPrefix)
+		i := strings.LastIndex(value, ":")
+		if i < 0 {
+			continue
+		}
+		percent, err := strconv.Atoi(value[i+1:])
+		if err != nil {
+			continue
+		}
+		if split == nil {
+			split = TrafficSplit{}
+		}
+		split[value[:i]] = percent
+	}
+	return split
+}
+
+// Rollout shifts the traffic of a microservice from one version to another in steps, for canary releases.
+// For a blue-green switch, use a single step of 100.
+type Rollout struct {
+	// Gateway is the Kong gateway of the microservice.
+	Gateway *KongGateway
+
+	// From is the version label of the current version.
+	From string
+
+	// To is the version label of the new version.
+	To string
+
+	// Steps are the percentages of the traffic sent to the new version in each step, for example 10, 25, 50, 100.
+	Steps []int
+
+	// Interval is the time to wait after each step, before the Check is run and the next step is made.
+	Interval time.Duration
+
+	// Check is called after each step. If it returns an error, the rollout is stopped and rolled back.
+	// If nil, all steps are made.
+	Check func(ctx context.Context, split TrafficSplit) error
+
+	previous TrafficSplit
+}
+
+// Run makes the steps of the rollout. If a step fails, the Check fails or the context is done, the traffic split
+// that was set before the rollout started is restored, and the error is returned.
+func (r *Rollout) Run(ctx context.Context) error {
+	if r.From == "" || r.To == "" || r.From == r.To {
+		return fmt.Errorf("rollout requires two different versions")
+	}
+	if len(r.Steps) == 0 {
+		return fmt.Errorf("rollout requires at least one step")
+	}
+	previous, err := r.Gateway.TrafficSplit(ctx)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		previous = TrafficSplit{r.From: 100}
+	}
+	r.previous = previous
+
+	for _, step := range r.Steps {
+		split := TrafficSplit{r.From: 100 - step, r.To: step}
+		if err := r.Gateway.SetTrafficSplit(ctx, split); err != nil {
+			return r.rollback(err)
+		}
+		if err := wait(ctx, r.Interval); err != nil {
+			return r.rollback(err)
+		}
+		if r.Check != nil {
+			if err := r.Check(ctx, split); err != nil {
+				return r.rollback(fmt.Errorf("check failed at %s: %s", split, err.Error()))
+			}
+		}
+	}
+	return nil
+}
+
+// Rollback restores the traffic split that was set before the rollout started.
+func (r *Rollout) Rollback(ctx context.Context) error {
+	if r.previous == nil {
+		return fmt.Errorf("rollout has not been started")
+	}
+	return r.Gateway.SetTrafficSplit(ctx, r.previous)
+}
+
+// rollback restores the previous traffic split after the rollout failed with the given error.
+// The context of the rollout may be done, so the split is restored with a new context.
+func (r *Rollout) rollback(err error) error {
+	if rollbackErr := r.Rollback(context.Background()); rollbackErr != nil {
+		return fmt.Errorf("rollout failed: %s; rollback: %s", err.Error(), rollbackErr.Error())
+	}
+	return fmt.Errorf("rollout rolled back: %s", err.Error())
+}
@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+)
+
+func registerVersion(t *testing.T, kong *kongtest.Server, address, version string) *KongGateway {
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = address
+	config.Version = version
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	gateway.InstanceID = address
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func targetWeights(kong *kongtest.Server) map[string]float64 {
+	weights := map[string]float64{}
+	for _, target := range kong.Children("upstreams", "user.api.jormugandr.org", "targets") {
+		weights[target["target"].(string)] = target["weight"].(float64)
+	}
+	return weights
+}
+
+func TestSetTrafficSplit(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	blue := registerVersion(t, kong, "10.0.0.5", "v1")
+	registerVersion(t, kong, "10.0.0.6", "v1")
+	green := registerVersion(t, kong, "10.0.0.7", "v2")
+	if weights := targetWeights(kong); weights["10.0.0.7:8080"] != 10 {
+		t.Fatalf("Expected the configured weight without a traffic split, got %v", weights)
+	}
+
+	if err := blue.SetTrafficSplit(context.Background(), TrafficSplit{"v1": 90, "v2": 10}); err != nil {
+		t.Fatal(err)
+	}
+	weights := targetWeights(kong)
+	if weights["10.0.0.5:8080"] != 450 || weights["10.0.0.6:8080"] != 450 || weights["10.0.0.7:8080"] != 100 {
+		t.Fatalf("Unexpected weights after the split: %v", weights)
+	}
+	split, err := green.TrafficSplit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if split.String() != "v1=90% v2=10%" {
+		t.Fatalf("Unexpected traffic split: %s", split)
+	}
+
+	registerVersion(t, kong, "10.0.0.8", "v1")
+	if weights := targetWeights(kong); weights["10.0.0.8:8080"] != 300 {
+		t.Fatalf("Expected the new instance to take its share of the version, got %v", weights)
+	}
+	changed, err := blue.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "target" || targetWeights(kong)["10.0.0.5:8080"] != 300 {
+		t.Fatalf("Expected only the target weight to be reconciled, got %v: %v", changed, targetWeights(kong))
+	}
+	if changed, err := green.Reconcile(context.Background()); err != nil || len(changed) != 0 {
+		t.Fatalf("Expected the split to be kept by the reconciliation, got %v (%v)", changed, err)
+	}
+	if tags := kong.Get("upstreams", "user.api.jormugandr.org")["tags"].([]interface{}); len(tags) != 3 {
+		t.Fatalf("Expected the owner and traffic tags on the upstream, got %v", tags)
+	}
+}
+
+func TestRolloutRollsBackOnFailedCheck(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	blue := registerVersion(t, kong, "10.0.0.5", "v1")
+	registerVersion(t, kong, "10.0.0.6", "v2")
+
+	checks := []string{}
+	rollout := &Rollout{
+		Gateway: blue,
+		From:    "v1",
+		To:      "v2",
+		Steps:   []int{10, 50, 100},
+		Check: func(ctx context.Context, split TrafficSplit) error {
+			checks = append(checks, split.String())
+			if split["v2"] == 50 {
+				return fmt.Errorf("error rate too high")
+			}
+			return nil
+		},
+	}
+	if err := rollout.Run(context.Background()); err == nil {
+		t.Fatal("Expected the rollout to fail")
+	}
+	if len(checks) != 2 {
+		t.Fatalf("Expected the rollout to stop after the failed check, got %v", checks)
+	}
+
+	split, err := blue.TrafficSplit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if split.String() != "v1=100%" {
+		t.Fatalf("Expected the traffic to be rolled back to v1, got %s", split)
+	}
+	if weights := targetWeights(kong); weights["10.0.0.5:8080"] != 1000 || weights["10.0.0.6:8080"] != 0 {
+		t.Fatalf("Unexpected weights after the rollback: %v", weights)
+	}
+
+	rollout.Check = nil
+	if err := rollout.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if weights := targetWeights(kong); weights["10.0.0.5:8080"] != 0 || weights["10.0.0.6:8080"] != 1000 {
+		t.Fatalf("Expected all traffic on v2, got %v", weights)
+	}
+}
+
+func TestTrafficSplitValidate(t *testing.T) {
+	for _, split := range []TrafficSplit{{}, {"v1": 90}, {"v1": 110, "v2": -10}, {"": 100}} {
+		if err := split.Validate(); err == nil {
+			t.Errorf("Expected %v to be invalid", split)
+		}
+	}
+	if err := (TrafficSplit{"v1": 0, "v2": 100}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,877 @@
+// Package kongtest provides an in-memory, stateful fake of the Kong Admin API for tests.
+//
+// The fake server keeps the Kong entities (apis, services, routes, upstreams, targets, plugins, consumers,
+// consumer groups and the jwt, key-auth and acl credentials of the consumers) in memory, so the tests can run
+// the real registration flows against it and then assert the resulting state of the gateway:
+//
+//	kong := kongtest.NewServer()
+//	defer kong.Close()
+//
+//	registration := gateway.NewKongGateway(kong.URL, &http.Client{}, config)
+//	registration.SelfRegister()
+//
+//	if route := kong.Get("routes", "user-microservice"); route == nil {
+//		t.Fatal("Expected the route to be registered")
+//	}
+package kongtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object is a Kong entity, as returned by the Kong Admin API.
+type Object map[string]interface{}
+
+// relation defines the parent of an entity, for example the service of a route.
+type relation struct {
+	// parent is the name of the parent collection.
+	parent string
+
+	// field is the field of the entity that references the parent. Fields ending with "_id" hold the
+	// ID of the parent (Kong 0.x), otherwise the field holds an object with the ID ({"id": "..."}).
+	field string
+
+	// cascade signals whether the entity is deleted with the parent. If false, the parent cannot be deleted
+	// while the entity references it.
+	cascade bool
+}
+
+// schema describes a collection of entities.
+type schema struct {
+	// unique is the list of fields by which the entity can be looked up, besides the ID.
+	unique []string
+
+	// scoped signals whether the unique fields are unique only within the parent entity.
+	scoped bool
+
+	// required is the list of required fields.
+	required []string
+
+	// arrays is the list of fields that are sent as comma separated values in form encoded requests.
+	arrays []string
+
+	// defaults holds the default values, as JSON.
+	defaults string
+
+	// generated is the list of fields that are generated by Kong when not set, for example the credential keys.
+	generated []string
+
+	// parents is the list of possible parents of the entity.
+	parents []relation
+}
+
+var schemas = map[string]*schema{
+	"apis": {
+		unique:   []string{"name"},
+		required: []string{"name", "upstream_url"},
+		arrays:   []string{"hosts", "uris", "methods"},
+		defaults: `{"retries":5,"upstream_connect_timeout":60000,"upstream_send_timeout":60000,"upstream_read_timeout":60000,
+			"strip_uri":true,"preserve_host":false,"https_only":false,"http_if_terminated":false}`,
+	},
+	"services": {
+		unique:   []string{"name"},
+		required: []string{"host"},
+		defaults: `{"protocol":"http","port":80,"retries":5,"connect_timeout":60000,"write_timeout":60000,"read_timeout":60000}`,
+	},
+	"routes": {
+		unique:   []string{"name"},
+		arrays:   []string{"hosts", "paths", "methods", "protocols"},
+		defaults: `{"protocols":["http","https"],"strip_path":true,"preserve_host":false,"regex_priority":0}`,
+		parents:  []relation{{parent: "services", field: "service"}},
+	},
+	"upstreams": {
+		unique:   []string{"name"},
+		required: []string{"name"},
+		defaults: `{"slots":10000}`,
+	},
+	"targets": {
+		unique:   []string{"target"},
+		scoped:   true,
+		required: []string{"target"},
+		defaults: `{"weight":100}`,
+		parents:  []relation{{parent: "upstreams", field: "upstream", cascade: true}},
+	},
+	"plugins": {
+		unique:   []string{"name"},
+		scoped:   true,
+		required: []string{"name"},
+		defaults: `{"enabled":true,"config":{}}`,
+		parents: []relation{
+			{parent: "apis", field: "api_id", cascade: true},
+			{parent: "services", field: "service", cascade: true},
+			{parent: "routes", field: "route", cascade: true},
+			{parent: "consumers", field: "consumer", cascade: true},
+		},
+	},
+	"certificates": {
+		required: []string{"cert", "key"},
+		arrays:   []string{"snis"},
+	},
+	"snis": {
+		unique:   []string{"name"},
+		required: []string{"name", "certificate"},
+		parents:  []relation{{parent: "certificates", field: "certificate", cascade: true}},
+	},
+	"ca_certificates": {
+		required: []string{"cert"},
+	},
+	"consumers": {
+		unique: []string{"username", "custom_id"},
+	},
+	"consumer_groups": {
+		unique:   []string{"name"},
+		required: []string{"name"},
+	},
+	"consumer_group_members": {
+		parents: []relation{
+			{parent: "consumers", field: "consumer", cascade: true},
+			{parent: "consumer_groups", field: "consumer_group", cascade: true},
+		},
+	},
+	"jwt": {
+		unique:    []string{"key"},
+		generated: []string{"key", "secret"},
+		defaults:  `{"algorithm":"HS256"}`,
+		parents:   []relation{{parent: "consumers", field: "consumer", cascade: true}},
+	},
+	"key-auth": {
+		unique:    []string{"key"},
+		generated: []string{"key"},
+		parents:   []relation{{parent: "consumers", field: "consumer", cascade: true}},
+	},
+	"acls": {
+		unique:   []string{"group"},
+		scoped:   true,
+		required: []string{"group"},
+		parents:  []relation{{parent: "consumers", field: "consumer", cascade: true}},
+	},
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Server is an in-memory Kong Admin API served with httptest.Server.
+// The server is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	// UpsertTargets makes the server update a target that is added again to the same upstream, like Kong 2.x
+	// and older. By default the server rejects the duplicate target with "409 Conflict", like Kong 3.x.
+	UpsertTargets bool
+
+	mu       sync.Mutex
+	entities map[string][]Object
+	requests []string
+	failures []int
+	nextID   int
+}
+
+// NewServer creates and starts a new fake Kong Admin API with no entities.
+// The Admin API URL is in the URL field of the server. Close the server when done.
+func NewServer() *Server {
+	s := &Server{
+		entities: map[string][]Object{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Get returns a copy of the entity with the given ID or name (or other unique field) from the collection,
+// or nil if there is no such entity.
+func (s *Server) Get(collection, idOrName string) Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj := s.find(collection, idOrName, nil)
+	if obj == nil {
+		return nil
+	}
+	return copyObject(obj)
+}
+
+// List returns copies of all entities in the collection, in the order of creation.
+func (s *Server) List(collection string) []Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list(collection, nil)
+}
+
+// Children returns copies of the entities from the child collection that belong to the parent entity,
+// for example the targets of an upstream: Children("upstreams", "user.api.example.org", "targets").
+func (s *Server) Children(parentCollection, parentIDOrName, collection string) []Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref, err := s.parentRef(parentCollection, parentIDOrName, collection)
+	if err != nil {
+		return nil
+	}
+	return s.list(collection, ref)
+}
+
+// Add adds an entity to the collection directly (without validation), for example to set up the
+// state of Kong before the test. Missing ID and creation time are generated. Returns a copy of the added entity.
+func (s *Server) Add(collection string, obj Object) Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj = copyObject(obj)
+	s.initialize(obj)
+	s.entities[collection] = append(s.entities[collection], obj)
+	return copyObject(obj)
+}
+
+// Requests returns the requests received by the server so far, as "METHOD /path" strings.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// FailNext makes the next n requests fail with the given HTTP status code, for example to simulate
+// Kong not being available yet.
+func (s *Server) FailNext(n int, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// Reset removes all entities and recorded requests.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entities = map[string][]Object{}
+	s.requests = nil
+	s.failures = nil
+}
+
+// apiError is an error response of the Admin API.
+type apiError struct {
+	status int
+	body   Object
+}
+
+func notFound() *apiError {
+	return &apiError{status: http.StatusNotFound, body: Object{"message": "Not found"}}
+}
+
+func schemaViolation(fields Object) *apiError {
+	return &apiError{status: http.StatusBadRequest, body: Object{
+		"code":    2,
+		"name":    "schema violation",
+		"message": fmt.Sprintf("schema violation (%d violations)", len(fields)),
+		"fields":  fields,
+	}}
+}
+
+func badRequest(message string) *apiError {
+	return &apiError{status: http.StatusBadRequest, body: Object{"message": message}}
+}
+
+func (s *Server) handle(rw http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, fmt.Sprintf("%s %s", req.Method, req.URL.Path))
+
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		writeJSON(rw, status, Object{"message": http.StatusText(status)})
+		return
+	}
+
+	status, result, err := s.route(req)
+	if err != nil {
+		writeJSON(rw, err.status, err.body)
+		return
+	}
+	if result == nil {
+		rw.WriteHeader(status)
+		return
+	}
+	writeJSON(rw, status, result)
+}
+
+// route dispatches the request by path: /{collection}, /{collection}/{key}, /{parent}/{key}/{collection}
+// and /{parent}/{key}/{collection}/{key}.
+func (s *Server) route(req *http.Request) (int, interface{}, *apiError) {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(segments) == 1 && segments[0] == "" {
+		return http.StatusOK, Object{"tagline": "Welcome to kong", "version": "kongtest"}, nil
+	}
+
+	if len(segments) > 2 && segments[0] == "consumers" && segments[2] == "consumer_groups" {
+		return s.membership(req, segments)
+	}
+
+	var ref *reference
+	if len(segments) > 2 {
+		var err *apiError
+		if ref, err = s.parentRef(segments[0], segments[1], segments[2]); err != nil {
+			return 0, nil, err
+		}
+		segments = segments[2:]
+	}
+	collection := segments[0]
+	if schemas[collection] == nil || len(segments) > 2 {
+		return 0, nil, notFound()
+	}
+
+	if len(segments) == 1 {
+		switch req.Method {
+		case "GET":
+			return http.StatusOK, Object{"data": filterTags(s.list(collection, ref), req.URL.Query().Get("tags")), "next": nil}, nil
+		case "POST":
+			body, err := readBody(req, collection)
+			if err != nil {
+				return 0, nil, err
+			}
+			obj, err := s.create(collection, ref, body)
+			return http.StatusCreated, obj, err
+		}
+		return 0, nil, &apiError{status: http.StatusMethodNotAllowed, body: Object{"message": "Method not allowed"}}
+	}
+
+	key := segments[1]
+	switch req.Method {
+	case "GET":
+		obj := s.find(collection, key, ref)
+		if obj == nil {
+			return 0, nil, notFound()
+		}
+		return http.StatusOK, copyObject(obj), nil
+	case "PUT":
+		body, err := readBody(req, collection)
+		if err != nil {
+			return 0, nil, err
+		}
+		obj, err := s.upsert(collection, key, ref, body)
+		return http.StatusOK, obj, err
+	case "PATCH":
+		body, err := readBody(req, collection)
+		if err != nil {
+			return 0, nil, err
+		}
+		obj, err := s.update(collection, key, ref, body)
+		return http.StatusOK, obj, err
+	case "DELETE":
+		return http.StatusNoContent, nil, s.delete(collection, key, ref)
+	}
+	return 0, nil, &apiError{status: http.StatusMethodNotAllowed, body: Object{"message": "Method not allowed"}}
+}
+
+// membership handles the consumer groups of a consumer: /consumers/{key}/consumer_groups and
+// /consumers/{key}/consumer_groups/{key}. The memberships are kept in the "consumer_group_members" collection.
+func (s *Server) membership(req *http.Request, segments []string) (int, interface{}, *apiError) {
+	consumer := s.find("consumers", segments[1], nil)
+	if consumer == nil || len(segments) > 4 {
+		return 0, nil, notFound()
+	}
+	consumerRef := &reference{field: "consumer", id: consumer["id"].(string)}
+	members := s.list("consumer_group_members", consumerRef)
+
+	if len(segments) == 3 {
+		switch req.Method {
+		case "GET":
+			groups := []Object{}
+			for _, member := range members {
+				if group := s.find("consumer_groups", referencedID(member["consumer_group"]), nil); group != nil {
+					groups = append(groups, copyObject(group))
+				}
+			}
+			return http.StatusOK, Object{"data": groups, "next": nil}, nil
+		case "POST":
+			body, err := readBody(req, "consumer_group_members")
+			if err != nil {
+				return 0, nil, err
+			}
+			name, _ := body["group"].(string)
+			group := s.find("consumer_groups", name, nil)
+			if group == nil {
+				return 0, nil, notFound()
+			}
+			for _, member := range members {
+				if referencedID(member["consumer_group"]) == group["id"] {
+					return 0, nil, &apiError{status: http.StatusConflict, body: Object{"message": "consumer is already in the consumer group"}}
+				}
+			}
+			_, err = s.create("consumer_group_members", consumerRef, Object{"consumer_group": map[string]interface{}{"id": group["id"]}})
+			if err != nil {
+				return 0, nil, err
+			}
+			return http.StatusCreated, Object{"consumer": copyObject(consumer), "consumer_groups": []Object{copyObject(group)}}, nil
+		}
+		return 0, nil, &apiError{status: http.StatusMethodNotAllowed, body: Object{"message": "Method not allowed"}}
+	}
+
+	if req.Method != "DELETE" {
+		return 0, nil, &apiError{status: http.StatusMethodNotAllowed, body: Object{"message": "Method not allowed"}}
+	}
+	group := s.find("consumer_groups", segments[3], nil)
+	if group == nil {
+		return 0, nil, notFound()
+	}
+	for _, member := range members {
+		if referencedID(member["consumer_group"]) == group["id"] {
+			s.remove("consumer_group_members", member["id"].(string))
+			return http.StatusNoContent, nil, nil
+		}
+	}
+	return 0, nil, notFound()
+}
+
+// filterTags returns the entities with the given tags. Tags separated with commas must all be set (and),
+// while tags separated with slashes match any of the tags (or).
+func filterTags(objects []Object, tags string) []Object {
+	if tags == "" {
+		return objects
+	}
+	result := []Object{}
+	for _, obj := range objects {
+		set := map[string]bool{}
+		if list, ok := obj["tags"].([]interface{}); ok {
+			for _, tag := range list {
+				set[fmt.Sprintf("%v", tag)] = true
+			}
+		}
+		matches := true
+		if strings.Contains(tags, "/") {
+			matches = false
+			for _, tag := range strings.Split(tags, "/") {
+				matches = matches || set[tag]
+			}
+		} else {
+			for _, tag := range strings.Split(tags, ",") {
+				matches = matches && set[tag]
+			}
+		}
+		if matches {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
+
+// reference is a resolved reference to the parent entity.
+type reference struct {
+	field string
+	id    string
+}
+
+// value returns the value of the reference field.
+func (r *reference) value() interface{} {
+	if strings.HasSuffix(r.field, "_id") {
+		return r.id
+	}
+	return map[string]interface{}{"id": r.id}
+}
+
+// matches checks whether the entity references the parent.
+func (r *reference) matches(obj Object) bool {
+	if r == nil {
+		return true
+	}
+	return referencedID(obj[r.field]) == r.id
+}
+
+// parentRef resolves the reference to the parent entity of an entity from the collection.
+func (s *Server) parentRef(parentCollection, parentKey, collection string) (*reference, *apiError) {
+	child := schemas[collection]
+	if child == nil {
+		return nil, notFound()
+	}
+	for _, rel := range child.parents {
+		if rel.parent != parentCollection {
+			continue
+		}
+		parent := s.find(parentCollection, parentKey, nil)
+		if parent == nil {
+			return nil, notFound()
+		}
+		return &reference{field: rel.field, id: parent["id"].(string)}, nil
+	}
+	return nil, notFound()
+}
+
+// referencedID returns the ID from the value of a reference field.
+func referencedID(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if id, ok := v["id"].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// find looks up the entity by ID or by one of the unique fields. The lookup is limited to the entities
+// of the parent, if ref is not nil.
+func (s *Server) find(collection, key string, ref *reference) Object {
+	schema := schemas[collection]
+	for _, obj := range s.entities[collection] {
+		if !ref.matches(obj) {
+			continue
+		}
+		if obj["id"] == key {
+			return obj
+		}
+		if schema == nil {
+			continue
+		}
+		for _, field := range schema.unique {
+			if value, ok := obj[field].(string); ok && value == key {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) list(collection string, ref *reference) []Object {
+	result := []Object{}
+	for _, obj := range s.entities[collection] {
+		if ref.matches(obj) {
+			result = append(result, copyObject(obj))
+		}
+	}
+	return result
+}
+
+// create adds a new entity. Targets are upserted if UpsertTargets is set, because Kong 2.x and older update
+// an existing target when the same target is added again.
+func (s *Server) create(collection string, ref *reference, body Object) (Object, *apiError) {
+	if collection == "targets" && s.UpsertTargets {
+		if target, ok := body["target"].(string); ok {
+			if existing := s.find(collection, target, ref); existing != nil {
+				return s.update(collection, existing["id"].(string), ref, body)
+			}
+		}
+	}
+	obj := newObject(collection)
+	merge(obj, body)
+	if ref != nil {
+		obj[ref.field] = ref.value()
+	}
+	s.generate(collection, obj)
+	if err := s.validate(collection, obj); err != nil {
+		return nil, err
+	}
+	s.initialize(obj)
+	s.entities[collection] = append(s.entities[collection], obj)
+	return copyObject(obj), nil
+}
+
+// upsert creates or replaces the entity with the given ID or name (Kong 1.x and newer).
+func (s *Server) upsert(collection, key string, ref *reference, body Object) (Object, *apiError) {
+	obj := newObject(collection)
+	merge(obj, body)
+	if ref != nil {
+		obj[ref.field] = ref.value()
+	}
+
+	existing := s.find(collection, key, ref)
+	if existing != nil {
+		obj["id"] = existing["id"]
+		obj["created_at"] = existing["created_at"]
+	} else if uuidPattern.MatchString(key) {
+		obj["id"] = key
+	} else if unique := schemas[collection].unique; len(unique) > 0 {
+		obj[unique[0]] = key
+	}
+
+	s.generate(collection, obj)
+	if err := s.validate(collection, obj); err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		s.initialize(obj)
+		s.entities[collection] = append(s.entities[collection], obj)
+		return copyObject(obj), nil
+	}
+	for i, current := range s.entities[collection] {
+		if current["id"] == existing["id"] {
+			s.entities[collection][i] = obj
+		}
+	}
+	return copyObject(obj), nil
+}
+
+// update merges the body into the existing entity.
+func (s *Server) update(collection, key string, ref *reference, body Object) (Object, *apiError) {
+	existing := s.find(collection, key, ref)
+	if existing == nil {
+		return nil, notFound()
+	}
+	obj := copyObject(existing)
+	merge(obj, body)
+	if err := s.validate(collection, obj); err != nil {
+		return nil, err
+	}
+	for i, current := range s.entities[collection] {
+		if current["id"] == existing["id"] {
+			s.entities[collection][i] = obj
+		}
+	}
+	return copyObject(obj), nil
+}
+
+// delete removes the entity and the entities that belong to it.
+func (s *Server) delete(collection, key string, ref *reference) *apiError {
+	existing := s.find(collection, key, ref)
+	if existing == nil {
+		return notFound()
+	}
+	id := existing["id"].(string)
+
+	for childCollection, child := range schemas {
+		for _, rel := range child.parents {
+			if rel.parent != collection || rel.cascade {
+				continue
+			}
+			childRef := &reference{field: rel.field, id: id}
+			if len(s.list(childCollection, childRef)) > 0 {
+				return badRequest(fmt.Sprintf("an existing '%s' entity references this '%s' entity", childCollection, collection))
+			}
+		}
+	}
+
+	s.remove(collection, id)
+	for childCollection, child := range schemas {
+		for _, rel := range child.parents {
+			if rel.parent != collection {
+				continue
+			}
+			childRef := &reference{field: rel.field, id: id}
+			for _, obj := range s.list(childCollection, childRef) {
+				s.remove(childCollection, obj["id"].(string))
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) remove(collection, id string) {
+	remaining := []Object{}
+	for _, obj := range s.entities[collection] {
+		if obj["id"] != id {
+			remaining = append(remaining, obj)
+		}
+	}
+	s.entities[collection] = remaining
+}
+
+// validate checks the required fields and the unique constraints.
+func (s *Server) validate(collection string, obj Object) *apiError {
+	schema := schemas[collection]
+	fields := Object{}
+	for _, field := range schema.required {
+		if value, ok := obj[field]; !ok || value == nil || value == "" {
+			fields[field] = "required field missing"
+		}
+	}
+	if len(fields) > 0 {
+		return schemaViolation(fields)
+	}
+
+	for _, field := range schema.unique {
+		value, ok := obj[field].(string)
+		if !ok || value == "" {
+			continue
+		}
+		for _, other := range s.entities[collection] {
+			if other["id"] == obj["id"] || other[field] != value {
+				continue
+			}
+			if schema.scoped && !sameParent(schema, obj, other) {
+				continue
+			}
+			return &apiError{status: http.StatusConflict, body: Object{
+				"code":    5,
+				"name":    "unique constraint violation",
+				"message": fmt.Sprintf("UNIQUE violation detected on '{%s=\"%s\"}'", field, value),
+				"fields":  Object{field: value},
+			}}
+		}
+	}
+	return nil
+}
+
+// sameParent checks whether both entities belong to the same parent.
+func sameParent(schema *schema, a, b Object) bool {
+	for _, rel := range schema.parents {
+		if referencedID(a[rel.field]) != referencedID(b[rel.field]) {
+			return false
+		}
+	}
+	return true
+}
+
+// newObject creates an entity with the default values of the collection.
+func newObject(collection string) Object {
+	obj := Object{}
+	if defaults := schemas[collection].defaults; defaults != "" {
+		if err := json.Unmarshal([]byte(defaults), &obj); err != nil {
+			panic(err)
+		}
+	}
+	return obj
+}
+
+// initialize sets the ID and the creation time of a new entity.
+func (s *Server) initialize(obj Object) {
+	if _, ok := obj["id"]; !ok {
+		obj["id"] = s.generateID()
+	}
+	if _, ok := obj["created_at"]; !ok {
+		obj["created_at"] = float64(time.Now().Unix())
+	}
+}
+
+// generate sets the generated fields that are not set.
+func (s *Server) generate(collection string, obj Object) {
+	for _, field := range schemas[collection].generated {
+		if value, ok := obj[field]; !ok || value == "" {
+			obj[field] = strings.Replace(s.generateID(), "-", "", -1)
+		}
+	}
+}
+
+func (s *Server) generateID() string {
+	s.nextID++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", s.nextID)
+}
+
+// merge sets the values from the body into the entity. Nested objects are merged recursively.
+// A null value removes the field.
+func merge(obj Object, body map[string]interface{}) {
+	for key, value := range body {
+		if value == nil {
+			delete(obj, key)
+			continue
+		}
+		nested, ok := value.(map[string]interface{})
+		current, currentOK := obj[key].(map[string]interface{})
+		if ok && currentOK {
+			merge(current, nested)
+			continue
+		}
+		obj[key] = value
+	}
+}
+
+// readBody decodes the JSON or form encoded request body.
+func readBody(req *http.Request, collection string) (Object, *apiError) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	body := Object{}
+	if len(data) == 0 {
+		return body, nil
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, badRequest(err.Error())
+		}
+		return formObject(form, schemas[collection]), nil
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, badRequest(fmt.Sprintf("Cannot parse JSON body: %s", err.Error()))
+	}
+	return body, nil
+}
+
+// formObject converts the form values into an entity, the way Kong does: the array fields are split by comma,
+// and numbers and booleans are converted. Nested fields are set with dotted keys, for example "config.minute".
+func formObject(form url.Values, schema *schema) Object {
+	obj := Object{}
+	for key, values := range form {
+		var value interface{} = values[0]
+		if isArrayField(schema, key) || len(values) > 1 {
+			items := []interface{}{}
+			for _, v := range values {
+				for _, item := range strings.Split(v, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						items = append(items, item)
+					}
+				}
+			}
+			value = items
+		} else if !isUniqueField(schema, key) {
+			value = convert(values[0])
+		}
+
+		target := obj
+		path := strings.Split(key, ".")
+		for _, name := range path[:len(path)-1] {
+			nested, ok := target[name].(map[string]interface{})
+			if !ok {
+				nested = map[string]interface{}{}
+				target[name] = nested
+			}
+			target = nested
+		}
+		target[path[len(path)-1]] = value
+	}
+	return obj
+}
+
+func isArrayField(schema *schema, field string) bool {
+	for _, name := range schema.arrays {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+func isUniqueField(schema *schema, field string) bool {
+	for _, name := range schema.unique {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// convert converts a form value into a number or a boolean, if possible.
+func convert(value string) interface{} {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number
+	}
+	if value == "true" || value == "false" {
+		return value == "true"
+	}
+	return value
+}
+
+// copyObject returns a deep copy of the entity.
+func copyObject(obj Object) Object {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	result := Object{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func writeJSON(rw http.ResponseWriter, status int, body interface{}) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(body)
+}
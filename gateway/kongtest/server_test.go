@@ -0,0 +1,134 @@
+package kongtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func doJSON(t *testing.T, method, url string, body interface{}) (int, Object) {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	result := Object{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestServicesAndRoutes(t *testing.T) {
+	kong := NewServer()
+	defer kong.Close()
+
+	status, service := doJSON(t, "PUT", kong.URL+"/services/users", Object{"host": "users.internal", "port": 8080})
+	if status != 200 || service["name"] != "users" || service["protocol"] != "http" {
+		t.Fatalf("Unexpected service (%d): %v", status, service)
+	}
+
+	status, route := doJSON(t, "PUT", kong.URL+"/services/users/routes/users-route", Object{"paths": []string{"/users"}})
+	if status != 200 || route["service"].(map[string]interface{})["id"] != service["id"] {
+		t.Fatalf("Expected the route to reference the service, got (%d): %v", status, route)
+	}
+
+	if status, _ := doJSON(t, "POST", kong.URL+"/services", Object{"name": "users", "host": "other"}); status != 409 {
+		t.Fatalf("Expected a unique constraint violation, got %d", status)
+	}
+	if status, _ := doJSON(t, "DELETE", kong.URL+"/services/users", nil); status != 400 {
+		t.Fatalf("Expected the service with routes not to be deleted, got %d", status)
+	}
+
+	status, route = doJSON(t, "PATCH", kong.URL+"/routes/users-route", Object{"strip_path": false})
+	if status != 200 || route["strip_path"] != false || route["paths"].([]interface{})[0] != "/users" {
+		t.Fatalf("Unexpected route after update (%d): %v", status, route)
+	}
+
+	if status, _ := doJSON(t, "DELETE", kong.URL+"/routes/users-route", nil); status != 204 {
+		t.Fatalf("Expected the route to be deleted, got %d", status)
+	}
+	if status, _ := doJSON(t, "DELETE", kong.URL+"/services/users", nil); status != 204 {
+		t.Fatalf("Expected the service to be deleted, got %d", status)
+	}
+	if len(kong.List("services")) != 0 {
+		t.Fatal("Expected no services")
+	}
+}
+
+func TestFormEncodedTargets(t *testing.T) {
+	for _, upsert := range []bool{true, false} {
+		kong := NewServer()
+		kong.UpsertTargets = upsert
+
+		kong.Add("upstreams", Object{"name": "users.internal", "slots": 10})
+
+		statuses := []int{}
+		for _, weight := range []string{"10", "20"} {
+			resp, err := http.PostForm(kong.URL+"/upstreams/users.internal/targets", url.Values{
+				"target": {"10.0.0.5:8080"},
+				"weight": {weight},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			statuses = append(statuses, resp.StatusCode)
+		}
+
+		targets := kong.Children("upstreams", "users.internal", "targets")
+		if upsert && (statuses[1] >= 300 || len(targets) != 1 || targets[0]["weight"] != float64(20)) {
+			t.Fatalf("Expected the target to be updated to weight 20, got %d %v", statuses[1], targets)
+		}
+		if !upsert && (statuses[1] != 409 || len(targets) != 1 || targets[0]["weight"] != float64(10)) {
+			t.Fatalf("Expected the duplicate target to be rejected, got %d %v", statuses[1], targets)
+		}
+
+		if status, _ := doJSON(t, "DELETE", kong.URL+"/upstreams/users.internal", nil); status != 204 {
+			t.Fatalf("Expected the upstream to be deleted, got %d", status)
+		}
+		if len(kong.List("targets")) != 0 {
+			t.Fatal("Expected the targets to be deleted with the upstream")
+		}
+		kong.Close()
+	}
+}
+
+func TestFailNextAndRequests(t *testing.T) {
+	kong := NewServer()
+	defer kong.Close()
+
+	kong.FailNext(1, 503)
+	if status, _ := doJSON(t, "GET", kong.URL+"/upstreams/missing", nil); status != 503 {
+		t.Fatalf("Expected the injected failure, got %d", status)
+	}
+	status, body := doJSON(t, "GET", kong.URL+"/upstreams/missing", nil)
+	if status != 404 || body["message"] != "Not found" {
+		t.Fatalf("Expected Not found, got (%d): %v", status, body)
+	}
+	if requests := strings.Join(kong.Requests(), ","); requests != "GET /upstreams/missing,GET /upstreams/missing" {
+		t.Fatalf("Unexpected requests: %s", requests)
+	}
+}
+
+func TestFilterByTags(t *testing.T) {
+	kong := NewServer()
+	defer kong.Close()
+
+	kong.Add("services", Object{"name": "users", "host": "users", "tags": []interface{}{"users", "v1"}})
+	kong.Add("services", Object{"name": "orders", "host": "orders", "tags": []interface{}{"orders", "v1"}})
+
+	for tags, expected := range map[string]int{"v1": 2, "users,v1": 1, "users/orders": 2, "users,orders": 0} {
+		_, body := doJSON(t, "GET", kong.URL+"/services?tags="+url.QueryEscape(tags), nil)
+		if data := body["data"].([]interface{}); len(data) != expected {
+			t.Fatalf("Expected %d services with tags %s, got %d", expected, tags, len(data))
+		}
+	}
+}
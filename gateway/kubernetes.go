@@ -0,0 +1,315 @@
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// KubernetesOptions configures the Kubernetes manifests generated from the MicroserviceConfig.
+type KubernetesOptions struct {
+	// Namespace is the namespace of the generated resources. If empty, no namespace is set.
+	Namespace string
+
+	// Selector holds the labels of the pods of the microservice. Defaults to {"app": MicroserviceName}.
+	Selector map[string]string
+
+	// IngressClass is the class of the Ingress. Defaults to "kong".
+	IngressClass string
+
+	// DisableIngress turns off the generation of the Ingress, for example when only the HTTPRoute is used.
+	DisableIngress bool
+
+	// GatewayName is the name of the Gateway API Gateway to which the HTTPRoute is attached.
+	// If empty, no HTTPRoute is generated.
+	GatewayName string
+
+	// GatewayNamespace is the namespace of the Gateway. If empty, the namespace of the HTTPRoute is used.
+	GatewayNamespace string
+}
+
+type k8sMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type k8sService struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   k8sMetadata    `yaml:"metadata"`
+	Spec       k8sServiceSpec `yaml:"spec"`
+}
+
+type k8sServiceSpec struct {
+	Selector map[string]string `yaml:"selector"`
+	Ports    []k8sServicePort  `yaml:"ports"`
+}
+
+type k8sServicePort struct {
+	Name       string `yaml:"name"`
+	Port       int    `yaml:"port"`
+	TargetPort int    `yaml:"targetPort"`
+	Protocol   string `yaml:"protocol"`
+}
+
+type k8sKongPlugin struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   k8sMetadata            `yaml:"metadata"`
+	Plugin     string                 `yaml:"plugin"`
+	Disabled   bool                   `yaml:"disabled,omitempty"`
+	Config     map[string]interface{} `yaml:"config,omitempty"`
+}
+
+type k8sIngress struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   k8sMetadata    `yaml:"metadata"`
+	Spec       k8sIngressSpec `yaml:"spec"`
+}
+
+type k8sIngressSpec struct {
+	IngressClassName string           `yaml:"ingressClassName"`
+	Rules            []k8sIngressRule `yaml:"rules"`
+}
+
+type k8sIngressRule struct {
+	Host string             `yaml:"host,omitempty"`
+	HTTP k8sIngressRuleHTTP `yaml:"http"`
+}
+
+type k8sIngressRuleHTTP struct {
+	Paths []k8sIngressPath `yaml:"paths"`
+}
+
+type k8sIngressPath struct {
+	Path     string            `yaml:"path"`
+	PathType string            `yaml:"pathType"`
+	Backend  k8sIngressBackend `yaml:"backend"`
+}
+
+type k8sIngressBackend struct {
+	Service k8sIngressServiceBackend `yaml:"service"`
+}
+
+type k8sIngressServiceBackend struct {
+	Name string                `yaml:"name"`
+	Port k8sServiceBackendPort `yaml:"port"`
+}
+
+type k8sServiceBackendPort struct {
+	Number int `yaml:"number"`
+}
+
+type k8sHTTPRoute struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   k8sMetadata      `yaml:"metadata"`
+	Spec       k8sHTTPRouteSpec `yaml:"spec"`
+}
+
+type k8sHTTPRouteSpec struct {
+	ParentRefs []k8sParentRef     `yaml:"parentRefs"`
+	Hostnames  []string           `yaml:"hostnames,omitempty"`
+	Rules      []k8sHTTPRouteRule `yaml:"rules"`
+}
+
+type k8sParentRef struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type k8sHTTPRouteRule struct {
+	Matches     []k8sHTTPRouteMatch `yaml:"matches"`
+	BackendRefs []k8sBackendRef     `yaml:"backendRefs"`
+}
+
+type k8sHTTPRouteMatch struct {
+	Path   k8sHTTPPathMatch `yaml:"path"`
+	Method string           `yaml:"method,omitempty"`
+}
+
+type k8sHTTPPathMatch struct {
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+}
+
+type k8sBackendRef struct {
+	Name   string `yaml:"name"`
+	Port   int    `yaml:"port"`
+	Weight int    `yaml:"weight,omitempty"`
+}
+
+// KubernetesManifests generates the Kubernetes resources that expose the microservice through the Kong Ingress
+// Controller, instead of registering it through the Kong Admin API: a Service for the pods of the microservice,
+// a KongPlugin for every configured plugin, an Ingress (for the Hosts and Paths) and, if a GatewayName is set,
+// a Gateway API HTTPRoute. The resources are returned as a multi-document YAML.
+func KubernetesManifests(config *MicroserviceConfig, options *KubernetesOptions) ([]byte, error) {
+	if config.MicroserviceName == "" {
+		return nil, fmt.Errorf("microservice name is empty")
+	}
+	if config.MicroservicePort <= 0 {
+		return nil, fmt.Errorf("microservice port is not set")
+	}
+	if options == nil {
+		options = &KubernetesOptions{}
+	}
+	name := config.MicroserviceName
+	labels := map[string]string{"app.kubernetes.io/name": name}
+
+	selector := options.Selector
+	if len(selector) == 0 {
+		selector = map[string]string{"app": name}
+	}
+	portName := "http"
+	serviceAnnotations := map[string]string{}
+	if config.UpstreamTLS != nil {
+		portName = "https"
+		serviceAnnotations["konghq.com/protocol"] = "https"
+	}
+	resources := []interface{}{
+		&k8sService{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Metadata:   k8sMetadata{Name: name, Namespace: options.Namespace, Labels: labels, Annotations: serviceAnnotations},
+			Spec: k8sServiceSpec{
+				Selector: selector,
+				Ports: []k8sServicePort{{
+					Name:       portName,
+					Port:       config.MicroservicePort,
+					TargetPort: config.MicroservicePort,
+					Protocol:   "TCP",
+				}},
+			},
+		},
+	}
+
+	routeAnnotations := map[string]string{
+		"konghq.com/strip-path":    fmt.Sprintf("%t", config.StripPath),
+		"konghq.com/preserve-host": fmt.Sprintf("%t", config.PreserveHost),
+	}
+	plugins := []string{}
+	for _, plugin := range config.Plugins {
+		kongPlugin := &k8sKongPlugin{
+			APIVersion: "configuration.konghq.com/v1",
+			Kind:       "KongPlugin",
+			Metadata:   k8sMetadata{Name: fmt.Sprintf("%s-%s", name, plugin.Name), Namespace: options.Namespace, Labels: labels},
+			Plugin:     plugin.Name,
+			Config:     plugin.Config,
+		}
+		if plugin.Enabled != nil && !*plugin.Enabled {
+			kongPlugin.Disabled = true
+		}
+		resources = append(resources, kongPlugin)
+		plugins = append(plugins, kongPlugin.Metadata.Name)
+	}
+	if len(plugins) > 0 {
+		routeAnnotations["konghq.com/plugins"] = strings.Join(plugins, ",")
+	}
+
+	paths := config.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	if !options.DisableIngress {
+		ingressClass := options.IngressClass
+		if ingressClass == "" {
+			ingressClass = "kong"
+		}
+		ingressAnnotations := map[string]string{}
+		for key, value := range routeAnnotations {
+			ingressAnnotations[key] = value
+		}
+		if len(config.Methods) > 0 {
+			ingressAnnotations["konghq.com/methods"] = strings.Join(config.Methods, ",")
+		}
+		ingressPaths := []k8sIngressPath{}
+		for _, path := range paths {
+			ingressPaths = append(ingressPaths, k8sIngressPath{
+				Path:     path,
+				PathType: "Prefix",
+				Backend: k8sIngressBackend{Service: k8sIngressServiceBackend{
+					Name: name,
+					Port: k8sServiceBackendPort{Number: config.MicroservicePort},
+				}},
+			})
+		}
+		rules := []k8sIngressRule{}
+		for _, host := range kubernetesHosts(config.Hosts) {
+			rules = append(rules, k8sIngressRule{Host: host, HTTP: k8sIngressRuleHTTP{Paths: ingressPaths}})
+		}
+		resources = append(resources, &k8sIngress{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "Ingress",
+			Metadata:   k8sMetadata{Name: name, Namespace: options.Namespace, Labels: labels, Annotations: ingressAnnotations},
+			Spec:       k8sIngressSpec{IngressClassName: ingressClass, Rules: rules},
+		})
+	}
+
+	if options.GatewayName != "" {
+		matches := []k8sHTTPRouteMatch{}
+		for _, path := range paths {
+			if len(config.Methods) == 0 {
+				matches = append(matches, k8sHTTPRouteMatch{Path: k8sHTTPPathMatch{Type: "PathPrefix", Value: path}})
+			}
+			for _, method := range config.Methods {
+				matches = append(matches, k8sHTTPRouteMatch{Path: k8sHTTPPathMatch{Type: "PathPrefix", Value: path}, Method: method})
+			}
+		}
+		hostnames := []string{}
+		for _, host := range kubernetesHosts(config.Hosts) {
+			if host != "" {
+				hostnames = append(hostnames, host)
+			}
+		}
+		resources = append(resources, &k8sHTTPRoute{
+			APIVersion: "gateway.networking.k8s.io/v1",
+			Kind:       "HTTPRoute",
+			Metadata:   k8sMetadata{Name: name, Namespace: options.Namespace, Labels: labels, Annotations: routeAnnotations},
+			Spec: k8sHTTPRouteSpec{
+				ParentRefs: []k8sParentRef{{Name: options.GatewayName, Namespace: options.GatewayNamespace}},
+				Hostnames:  hostnames,
+				Rules: []k8sHTTPRouteRule{{
+					Matches:     matches,
+					BackendRefs: []k8sBackendRef{{Name: name, Port: config.MicroservicePort, Weight: config.Weight}},
+				}},
+			},
+		})
+	}
+
+	var buf bytes.Buffer
+	for i, resource := range resources {
+		data, err := yaml.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// kubernetesHosts returns the sorted, unique host names. Returns a single empty host (any host) if there are none.
+func kubernetesHosts(hosts []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, host := range hosts {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			result = append(result, host)
+		}
+	}
+	if len(result) == 0 {
+		return []string{""}
+	}
+	sort.Strings(result)
+	return result
+}
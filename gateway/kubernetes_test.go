@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// assertGolden compares the output with the golden file in testdata. Run the tests with -update to
+// rewrite the golden files.
+func assertGolden(t *testing.T, file string, output []byte) {
+	golden := filepath.Join("testdata", file)
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, output, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != string(expected) {
+		t.Fatalf("Output does not match %s:\n%s", golden, output)
+	}
+}
+
+func TestKubernetesManifests(t *testing.T) {
+	enabled := false
+	config := newServicesModeConfig()
+	config.Plugins = []PluginConfig{
+		{Name: "rate-limiting", Config: map[string]interface{}{"minute": 100, "policy": "local"}},
+		{Name: "cors", Enabled: &enabled},
+	}
+
+	tests := []struct {
+		golden  string
+		config  *MicroserviceConfig
+		options *KubernetesOptions
+	}{
+		{golden: "minimal.yaml", config: &MicroserviceConfig{MicroserviceName: "user-microservice", MicroservicePort: 8080}},
+		{golden: "ingress.yaml", config: config, options: &KubernetesOptions{Namespace: "microservices"}},
+		{golden: "httproute.yaml", config: config, options: &KubernetesOptions{
+			Namespace:        "microservices",
+			Selector:         map[string]string{"app": "user", "tier": "backend"},
+			DisableIngress:   true,
+			GatewayName:      "kong",
+			GatewayNamespace: "kong",
+		}},
+	}
+	for _, test := range tests {
+		output, err := KubernetesManifests(test.config, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertGolden(t, filepath.Join("kubernetes", test.golden), output)
+	}
+}
+
+func TestKubernetesManifestsRequiresNameAndPort(t *testing.T) {
+	if _, err := KubernetesManifests(&MicroserviceConfig{MicroservicePort: 8080}, nil); err == nil {
+		t.Fatal("Expected an error for the missing name")
+	}
+	if _, err := KubernetesManifests(&MicroserviceConfig{MicroserviceName: "users"}, nil); err == nil {
+		t.Fatal("Expected an error for the missing port")
+	}
+}
@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailurePolicy defines how MultiRegistration handles a failed registration on one of the gateways.
+type FailurePolicy int
+
+const (
+	// AllMustSucceed requires the registration to succeed on all gateways. If it fails on any of them,
+	// the successful registrations are rolled back (unregistered).
+	AllMustSucceed FailurePolicy = iota
+
+	// BestEffort registers on as many gateways as possible. The successful registrations are kept,
+	// and the errors from the failed ones are returned.
+	BestEffort
+)
+
+// DefaultRollbackTimeout is the default maximal duration of the rollback of the successful registrations.
+const DefaultRollbackTimeout = 30 * time.Second
+
+// MultiError aggregates the errors from multiple registrations.
+type MultiError struct {
+	// Errors holds the errors, in the order of the registrations that failed.
+	Errors []error
+}
+
+// Error returns all error messages joined together.
+func (m *MultiError) Error() string {
+	messages := []string{}
+	for _, err := range m.Errors {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d registration(s) failed: %s", len(m.Errors), strings.Join(messages, "; "))
+}
+
+// MultiRegistration registers the microservice on multiple gateways at once, for example while migrating
+// from one gateway to another. The registrations are performed concurrently.
+type MultiRegistration struct {
+	// Registrations is the list of wrapped registrations.
+	Registrations []Registration
+
+	// Policy defines how failed registrations are handled.
+	Policy FailurePolicy
+
+	// RollbackTimeout is the maximal duration of the rollback with the AllMustSucceed policy.
+	// Defaults to DefaultRollbackTimeout.
+	RollbackTimeout time.Duration
+}
+
+// NewMultiRegistration creates a Registration that wraps the given registrations with the given failure policy.
+func NewMultiRegistration(policy FailurePolicy, registrations ...Registration) *MultiRegistration {
+	return &MultiRegistration{
+		Registrations: registrations,
+		Policy:        policy,
+	}
+}
+
+// SelfRegister registers the microservice on all gateways.
+func (m *MultiRegistration) SelfRegister() error {
+	return m.SelfRegisterContext(context.Background())
+}
+
+// SelfRegisterContext registers the microservice on all gateways with the given context.
+// With the AllMustSucceed policy, the successful registrations are unregistered if any registration fails,
+// even if the context is canceled or its deadline is exceeded.
+// The errors are returned as *MultiError.
+func (m *MultiRegistration) SelfRegisterContext(ctx context.Context) error {
+	errs := m.each(ctx, m.Registrations, selfRegister)
+
+	failed := []error{}
+	succeeded := []Registration{}
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		} else {
+			succeeded = append(succeeded, m.Registrations[i])
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	if m.Policy == AllMustSucceed {
+		// The rollback runs with a new context, because the registration may have failed with the context done.
+		timeout := m.RollbackTimeout
+		if timeout <= 0 {
+			timeout = DefaultRollbackTimeout
+		}
+		rollbackCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		for _, err := range m.each(rollbackCtx, succeeded, unregister) {
+			if err != nil {
+				failed = append(failed, fmt.Errorf("rollback: %s", err.Error()))
+			}
+		}
+	}
+	return &MultiError{Errors: failed}
+}
+
+// Unregister unregisters the microservice from all gateways.
+func (m *MultiRegistration) Unregister() error {
+	return m.UnregisterContext(context.Background())
+}
+
+// UnregisterContext unregisters the microservice from all gateways with the given context.
+// All registrations are unregistered regardless of the policy, and the errors are returned as *MultiError.
+func (m *MultiRegistration) UnregisterContext(ctx context.Context) error {
+	failed := []error{}
+	for _, err := range m.each(ctx, m.Registrations, unregister) {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: failed}
+}
+
+// each calls fn concurrently for every registration and returns the errors in the order of the registrations.
+func (m *MultiRegistration) each(ctx context.Context, registrations []Registration, fn func(context.Context, Registration) error) []error {
+	errs := make([]error, len(registrations))
+	var wg sync.WaitGroup
+	for i, registration := range registrations {
+		wg.Add(1)
+		go func(i int, registration Registration) {
+			defer wg.Done()
+			errs[i] = fn(ctx, registration)
+		}(i, registration)
+	}
+	wg.Wait()
+	return errs
+}
+
+// selfRegister registers with the context, if the registration supports it.
+func selfRegister(ctx context.Context, registration Registration) error {
+	if contextRegistration, ok := registration.(ContextRegistration); ok {
+		return contextRegistration.SelfRegisterContext(ctx)
+	}
+	return registration.SelfRegister()
+}
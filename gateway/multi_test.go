@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type recordingRegistration struct {
+	sync.Mutex
+	registerErr   error
+	unregisterErr error
+	registered    bool
+}
+
+func (r *recordingRegistration) SelfRegister() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.registerErr != nil {
+		return r.registerErr
+	}
+	r.registered = true
+	return nil
+}
+
+func (r *recordingRegistration) Unregister() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.unregisterErr != nil {
+		return r.unregisterErr
+	}
+	r.registered = false
+	return nil
+}
+
+func TestMultiRegistrationAllSucceed(t *testing.T) {
+	first := &recordingRegistration{}
+	second := &recordingRegistration{}
+
+	multi := NewMultiRegistration(AllMustSucceed, first, second)
+	if err := multi.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if !first.registered || !second.registered {
+		t.Fatal("Expected both registrations to be registered")
+	}
+
+	if err := multi.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if first.registered || second.registered {
+		t.Fatal("Expected both registrations to be unregistered")
+	}
+}
+
+func TestMultiRegistrationAllMustSucceedRollsBack(t *testing.T) {
+	first := &recordingRegistration{}
+	second := &recordingRegistration{registerErr: fmt.Errorf("new gateway down")}
+
+	multi := NewMultiRegistration(AllMustSucceed, first, second)
+	err := multi.SelfRegister()
+	multiErr, ok := err.(*MultiError)
+	if !ok || len(multiErr.Errors) != 1 {
+		t.Fatalf("Expected one aggregated error, got %v", err)
+	}
+	if first.registered {
+		t.Fatal("Expected the successful registration to be rolled back")
+	}
+}
+
+// cancelingRegistration registers successfully and then cancels the context of the registration.
+type cancelingRegistration struct {
+	recordingRegistration
+	cancel context.CancelFunc
+}
+
+func (r *cancelingRegistration) SelfRegisterContext(ctx context.Context) error {
+	defer r.cancel()
+	return r.SelfRegister()
+}
+
+func (r *cancelingRegistration) UnregisterContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Unregister()
+}
+
+func TestMultiRegistrationRollsBackAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := &cancelingRegistration{cancel: cancel}
+	second := &recordingRegistration{registerErr: context.Canceled}
+
+	multi := NewMultiRegistration(AllMustSucceed, first, second)
+	err := multi.SelfRegisterContext(ctx)
+	multiErr, ok := err.(*MultiError)
+	if !ok || len(multiErr.Errors) != 1 {
+		t.Fatalf("Expected only the registration error, got %v", err)
+	}
+	if first.registered {
+		t.Fatal("Expected the successful registration to be rolled back after the context was canceled")
+	}
+}
+
+func TestMultiRegistrationBestEffort(t *testing.T) {
+	first := &recordingRegistration{}
+	second := &recordingRegistration{registerErr: fmt.Errorf("new gateway down")}
+	third := &recordingRegistration{registerErr: fmt.Errorf("consul down")}
+
+	multi := NewMultiRegistration(BestEffort, first, second, third)
+	err := multi.SelfRegister()
+	multiErr, ok := err.(*MultiError)
+	if !ok || len(multiErr.Errors) != 2 {
+		t.Fatalf("Expected two aggregated errors, got %v", err)
+	}
+	if multiErr.Errors[0].Error() != "new gateway down" || multiErr.Errors[1].Error() != "consul down" {
+		t.Fatalf("Expected the errors in the order of registrations, got %v", multiErr.Errors)
+	}
+	if !first.registered {
+		t.Fatal("Expected the successful registration to be kept")
+	}
+}
+
+func TestMultiRegistrationUnregisterAggregatesErrors(t *testing.T) {
+	first := &recordingRegistration{registered: true}
+	second := &recordingRegistration{registered: true, unregisterErr: fmt.Errorf("old gateway down")}
+
+	multi := NewMultiRegistration(BestEffort, first, second)
+	err := multi.Unregister()
+	if multiErr, ok := err.(*MultiError); !ok || len(multiErr.Errors) != 1 {
+		t.Fatalf("Expected one aggregated error, got %v", err)
+	}
+	if first.registered {
+		t.Fatal("Expected the other registration to be unregistered")
+	}
+}
@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Reconcilable is a Registration that can detect drift between the desired configuration of the microservice
+// and the actual state on the API Gateway, and re-apply only the objects that differ.
+type Reconcilable interface {
+	Registration
+
+	// Reconcile compares the desired state with the state on the API Gateway and re-applies the differences.
+	// Returns the list of gateway objects that were (re)applied.
+	Reconcile(ctx context.Context) ([]string, error)
+}
+
+// ReconcileStatus holds the outcome of the last reconciliation.
+type ReconcileStatus struct {
+	// LastSync is the time of the last reconciliation attempt.
+	LastSync time.Time
+
+	// LastSuccess is the time of the last successful reconciliation.
+	LastSuccess time.Time
+
+	// Changed is the list of gateway objects re-applied by the last reconciliation.
+	Changed []string
+
+	// Error is the error of the last reconciliation, or nil if it was successful.
+	Error error
+}
+
+// Reconciler periodically re-registers the microservice on the API Gateway, so the registration is restored
+// if the gateway is restarted or the registration is changed by hand.
+// If the Registration is Reconcilable, only the differences are re-applied. Otherwise, SelfRegisterContext
+// (or SelfRegister, if the Registration is not a ContextRegistration) is called on every run.
+type Reconciler struct {
+	// Registration is the registration being reconciled.
+	Registration Registration
+
+	// Interval is the time between two reconciliations.
+	Interval time.Duration
+
+	mutex  sync.RWMutex
+	status ReconcileStatus
+}
+
+// NewReconciler creates a Reconciler for the given Registration that runs on the given interval.
+func NewReconciler(registration Registration, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		Registration: registration,
+		Interval:     interval,
+	}
+}
+
+// Run reconciles the registration immediately and then on every interval, until the context is done.
+// Returns an error if the Interval is not positive, or the context error once the context is done.
+func (r *Reconciler) Run(ctx context.Context) error {
+	return runEvery(ctx, r.Interval, func(ctx context.Context) {
+		r.Sync(ctx)
+	})
+}
+
+// runEvery calls fn immediately and then on every interval, until the context is done. Returns an error if
+// the interval is not positive, or the context error once the context is done.
+func runEvery(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fn(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync performs a single reconciliation with the given context and records its status.
+func (r *Reconciler) Sync(ctx context.Context) error {
+	var changed []string
+	var err error
+
+	switch registration := r.Registration.(type) {
+	case Reconcilable:
+		changed, err = registration.Reconcile(ctx)
+	case ContextRegistration:
+		err = registration.SelfRegisterContext(ctx)
+	default:
+		err = registration.SelfRegister()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.status.LastSync = time.Now()
+	r.status.Changed = changed
+	r.status.Error = err
+	if err == nil {
+		r.status.LastSuccess = r.status.LastSync
+	}
+	return err
+}
+
+// Status returns the status of the last reconciliation.
+func (r *Reconciler) Status() ReconcileStatus {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.status
+}
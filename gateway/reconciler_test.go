@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Microkubes/microservice-tools/gateway/kongtest"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+type countingRegistration struct {
+	registered int
+	err        error
+}
+
+func (c *countingRegistration) SelfRegister() error {
+	c.registered++
+	return c.err
+}
+
+func (c *countingRegistration) Unregister() error {
+	return nil
+}
+
+func TestReconcilerSyncFallsBackToSelfRegister(t *testing.T) {
+	registration := &countingRegistration{}
+	reconciler := NewReconciler(registration, time.Minute)
+
+	if err := reconciler.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if registration.registered != 1 {
+		t.Fatalf("Expected SelfRegister to be called once, but was called %d times", registration.registered)
+	}
+	status := reconciler.Status()
+	if status.Error != nil || status.LastSuccess.IsZero() || status.LastSuccess != status.LastSync {
+		t.Fatalf("Expected a successful sync status, got %+v", status)
+	}
+
+	registration.err = fmt.Errorf("gateway down")
+	if err := reconciler.Sync(context.Background()); err == nil {
+		t.Fatal("Expected the registration error")
+	}
+	status = reconciler.Status()
+	if status.Error == nil || !status.LastSync.After(status.LastSuccess) {
+		t.Fatalf("Expected a failed sync status, got %+v", status)
+	}
+}
+
+func TestReconcilerRunStopsWithContext(t *testing.T) {
+	registration := &countingRegistration{}
+	reconciler := NewReconciler(registration, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := reconciler.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if registration.registered < 2 {
+		t.Fatalf("Expected multiple syncs, got %d", registration.registered)
+	}
+}
+
+func TestRunRejectsNonPositiveInterval(t *testing.T) {
+	registration := &countingRegistration{}
+	runners := map[string]func(context.Context) error{
+		"reconciler": NewReconciler(registration, 0).Run,
+		"sweeper":    (&Sweeper{}).Run,
+		"rotator":    NewCertificateRotator(nil, "", "", -time.Second).Run,
+	}
+	for name, run := range runners {
+		if err := run(context.Background()); err == nil || err == context.Canceled {
+			t.Fatalf("%s: expected an error for the interval, got %v", name, err)
+		}
+	}
+	if registration.registered != 0 {
+		t.Fatalf("Expected no syncs, got %d", registration.registered)
+	}
+}
+
+func TestKongReconcileReappliesOnlyDrift(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	self := "10.0.0.5:8080"
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"name":  "user.api.jormugandr.org",
+			"slots": 10,
+			"tags":  []string{"user-microservice"},
+		})
+
+	gock.New("http://kong:8001").
+		Get("/services/user-microservice").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"name":     "user-microservice",
+			"host":     "user.api.jormugandr.org",
+			"port":     8080,
+			"protocol": "http",
+			"tags":     []string{"user-microservice"},
+		})
+
+	gock.New("http://kong:8001").
+		Get("/routes/user-microservice").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"name":          "user-microservice",
+			"hosts":         []string{"user.api.jormugandr.org", "localhost"},
+			"paths":         []string{"/edited-by-hand"},
+			"methods":       []string{"POST", "GET"},
+			"strip_path":    true,
+			"preserve_host": false,
+			"tags":          []string{"user-microservice"},
+		})
+
+	gock.New("http://kong:8001").
+		Put("/services/user-microservice/routes/user-microservice").
+		SetMatcher(NewJSONMatcher(t).
+			Field("paths", []string{"/users"}).
+			Matcher).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"name": "user-microservice",
+		})
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org/targets").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{
+				{"target": self, "weight": 10, "tags": []string{"user-microservice", "instance:user-1"}},
+			},
+		})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, config)
+	gateway.InstanceID = "user-1"
+
+	changed, err := gateway.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "route" {
+		t.Fatalf("Expected only the route to be re-applied, got %v", changed)
+	}
+
+	if gock.IsPending() {
+		for _, mock := range gock.GetAll() {
+			t.Error("Mock:", mock.Request())
+		}
+		t.Fatal("Expected all HTTP calls to be made")
+	}
+}
+
+func TestKongReconcileUpdatesTargetOnLaterPage(t *testing.T) {
+	client := &http.Client{}
+
+	defer gock.Off()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org/targets").
+		MatchParam("offset", "page2").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{
+				{"target": "10.0.0.5:8080", "weight": 5, "tags": []string{"user-microservice", "instance:user-1"}},
+			},
+			"next": nil,
+		})
+
+	gock.New("http://kong:8001").
+		Get("/upstreams/user.api.jormugandr.org/targets").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{
+				{"target": "10.0.0.6:8080", "weight": 10},
+			},
+			"next": "/upstreams/user.api.jormugandr.org/targets?offset=page2",
+		})
+
+	gock.New("http://kong:8001").
+		Patch("/upstreams/user.api.jormugandr.org/targets/10.0.0.5:8080").
+		SetMatcher(NewJSONMatcher(t).
+			Field("weight", 10).
+			Matcher).
+		Reply(200).
+		JSON(map[string]interface{}{"target": "10.0.0.5:8080", "weight": 10})
+
+	gock.InterceptClient(client)
+
+	gateway := NewKongGateway("http://kong:8001", client, config)
+	gateway.InstanceID = "user-1"
+
+	changed, err := gateway.reconcileTarget(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "target" {
+		t.Fatalf("Expected the target to be updated, got %v", changed)
+	}
+	if gock.IsPending() {
+		for _, mock := range gock.GetAll() {
+			t.Error("Mock:", mock.Request())
+		}
+		t.Fatal("Expected the existing target to be updated in place")
+	}
+}
+
+func TestKongReconcileRepairsServiceTags(t *testing.T) {
+	kong := kongtest.NewServer()
+	defer kong.Close()
+
+	config := newServicesModeConfig()
+	config.AdvertiseAddress = "10.0.0.5"
+	gateway := NewKongGateway(kong.URL, &http.Client{}, config)
+	if err := gateway.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gateway.request(context.Background(), "PATCH", "services/user-microservice", map[string]interface{}{"tags": []string{"edited"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := gateway.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "service" {
+		t.Fatalf("Expected only the service to be re-applied, got %v", changed)
+	}
+	if tags, _ := stringList(kong.Get("services", "user-microservice")["tags"]); !containsStrings(tags, []string{"user-microservice"}) {
+		t.Fatalf("Expected the owner tag to be restored, got %v", tags)
+	}
+}
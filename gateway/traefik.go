@@ -0,0 +1,335 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// TraefikRegistration registers the microservice with Traefik (v2 and newer) through the file provider.
+//
+// Every instance writes its own file with a service that points to the instance. The shared file of the
+// microservice holds the router (built from Hosts, Paths and Methods) and a weighted service that balances
+// the requests between the instances by their Weight. The shared file is regenerated from the instance files
+// on every registration and unregistration. All files are written atomically, so Traefik never reads a
+// partially written file.
+type TraefikRegistration struct {
+	// ConfigDir is the directory watched by the Traefik file provider.
+	ConfigDir string
+
+	// EntryPoints is the list of Traefik entry points for the router. If empty, all entry points are used.
+	EntryPoints []string
+
+	// InstanceAddress is the address (host or IP) of this instance. If empty, the address is resolved
+	// with AddressResolver.
+	InstanceAddress string
+
+	// AddressResolver resolves the address of this instance. If nil, DefaultAddressResolver is used.
+	AddressResolver AddressResolver
+
+	config *MicroserviceConfig
+}
+
+// NewTraefikRegistration creates a Traefik Registration that writes the dynamic configuration into
+// the given file provider directory.
+func NewTraefikRegistration(configDir string, config *MicroserviceConfig) *TraefikRegistration {
+	return &TraefikRegistration{
+		ConfigDir: configDir,
+		config:    config,
+	}
+}
+
+// traefikConfig is the Traefik dynamic configuration.
+type traefikConfig struct {
+	HTTP traefikHTTP `yaml:"http"`
+}
+
+type traefikHTTP struct {
+	Routers     map[string]*traefikRouter     `yaml:"routers,omitempty"`
+	Middlewares map[string]*traefikMiddleware `yaml:"middlewares,omitempty"`
+	Services    map[string]*traefikService    `yaml:"services,omitempty"`
+}
+
+type traefikRouter struct {
+	Rule        string   `yaml:"rule"`
+	Service     string   `yaml:"service"`
+	EntryPoints []string `yaml:"entryPoints,omitempty"`
+	Middlewares []string `yaml:"middlewares,omitempty"`
+}
+
+type traefikMiddleware struct {
+	StripPrefix *traefikStripPrefix `yaml:"stripPrefix,omitempty"`
+}
+
+type traefikStripPrefix struct {
+	Prefixes []string `yaml:"prefixes"`
+}
+
+type traefikService struct {
+	LoadBalancer *traefikLoadBalancer `yaml:"loadBalancer,omitempty"`
+	Weighted     *traefikWeighted     `yaml:"weighted,omitempty"`
+}
+
+type traefikLoadBalancer struct {
+	PassHostHeader bool            `yaml:"passHostHeader"`
+	Servers        []traefikServer `yaml:"servers"`
+}
+
+type traefikServer struct {
+	URL string `yaml:"url"`
+}
+
+type traefikWeighted struct {
+	Services []traefikWeightedService `yaml:"services"`
+}
+
+type traefikWeightedService struct {
+	Name   string `yaml:"name"`
+	Weight int    `yaml:"weight"`
+}
+
+// traefikWeightComment is the comment in the instance file that holds the weight of the instance.
+// Traefik ignores the comments, while the weight is needed to generate the weighted service.
+const traefikWeightComment = "# weight: "
+
+// SelfRegister writes the file for this instance and regenerates the shared file of the microservice.
+func (t *TraefikRegistration) SelfRegister() error {
+	address, err := t.instanceAddress()
+	if err != nil {
+		return err
+	}
+	instance := &traefikConfig{
+		HTTP: traefikHTTP{
+			Services: map[string]*traefikService{
+				t.instanceServiceName(address): {
+					LoadBalancer: &traefikLoadBalancer{
+						PassHostHeader: t.config.PreserveHost,
+						Servers: []traefikServer{
+							{URL: fmt.Sprintf("http://%s", joinHostPort(address, t.config.MicroservicePort))},
+						},
+					},
+				},
+			},
+		},
+	}
+	header := fmt.Sprintf("%s%d\n", traefikWeightComment, t.weight())
+	if err := t.writeConfig(t.instanceFile(address), header, instance); err != nil {
+		return err
+	}
+	return t.writeSharedConfig()
+}
+
+// Unregister removes the file for this instance and regenerates the shared file of the microservice.
+// The shared file is removed once there are no instances left.
+func (t *TraefikRegistration) Unregister() error {
+	address, err := t.instanceAddress()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(t.instanceFile(address)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return t.writeSharedConfig()
+}
+
+// writeSharedConfig generates the router and the weighted service from the instance files found in the
+// config directory, and writes them into the shared file of the microservice.
+// The instances of the microservice hold a lock on a hidden file in the config directory while reading the
+// instance files and writing the shared file, so a concurrent registration cannot overwrite the shared file
+// with a stale list of instances.
+func (t *TraefikRegistration) writeSharedConfig() error {
+	unlock, err := lockFile(filepath.Join(t.ConfigDir, fmt.Sprintf(".%s.lock", t.config.MicroserviceName)))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	instances, err := t.instanceWeights()
+	if err != nil {
+		return err
+	}
+	sharedFile := filepath.Join(t.ConfigDir, fmt.Sprintf("%s.yml", t.config.MicroserviceName))
+	if len(instances) == 0 {
+		if err := os.Remove(sharedFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	name := t.config.MicroserviceName
+	router := &traefikRouter{
+		Rule:        traefikRule(t.config.Hosts, t.config.Paths, t.config.Methods),
+		Service:     name,
+		EntryPoints: t.EntryPoints,
+	}
+	shared := &traefikConfig{
+		HTTP: traefikHTTP{
+			Routers: map[string]*traefikRouter{
+				name: router,
+			},
+			Services: map[string]*traefikService{
+				name: {
+					Weighted: &traefikWeighted{
+						Services: instances,
+					},
+				},
+			},
+		},
+	}
+	if t.config.StripPath && len(t.config.Paths) > 0 {
+		middleware := fmt.Sprintf("%s-strip-prefix", name)
+		router.Middlewares = []string{middleware}
+		shared.HTTP.Middlewares = map[string]*traefikMiddleware{
+			middleware: {
+				StripPrefix: &traefikStripPrefix{
+					Prefixes: t.config.Paths,
+				},
+			},
+		}
+	}
+	return t.writeConfig(sharedFile, "", shared)
+}
+
+// instanceWeights reads the instance files of the microservice and returns the weighted services, sorted by name.
+func (t *TraefikRegistration) instanceWeights() ([]traefikWeightedService, error) {
+	files, err := filepath.Glob(filepath.Join(t.ConfigDir, fmt.Sprintf("%s.instance.*.yml", t.config.MicroserviceName)))
+	if err != nil {
+		return nil, err
+	}
+	instances := []traefikWeightedService{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		weight := DefaultTargetWeight
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, traefikWeightComment) {
+				if weight, err = strconv.Atoi(strings.TrimPrefix(line, traefikWeightComment)); err != nil {
+					return nil, fmt.Errorf("invalid weight in %s: %s", file, err.Error())
+				}
+			}
+		}
+		instance := traefikConfig{}
+		if err := yaml.Unmarshal(data, &instance); err != nil {
+			return nil, err
+		}
+		for serviceName := range instance.HTTP.Services {
+			instances = append(instances, traefikWeightedService{Name: serviceName, Weight: weight})
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Name < instances[j].Name
+	})
+	return instances, nil
+}
+
+// writeConfig writes the configuration into the file atomically. The configuration is first written into
+// a hidden temporary file in the same directory (ignored by Traefik), which is then renamed to the target file.
+func (t *TraefikRegistration) writeConfig(file, header string, config *traefikConfig) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, append([]byte(header), data...))
+}
+
+// instanceAddress returns the address of this instance.
+func (t *TraefikRegistration) instanceAddress() (string, error) {
+	if t.InstanceAddress != "" {
+		return t.InstanceAddress, nil
+	}
+	return resolveAddress(t.config, t.AddressResolver)
+}
+
+// instanceFile returns the path of the file for the instance with the given address.
+func (t *TraefikRegistration) instanceFile(address string) string {
+	return filepath.Join(t.ConfigDir, fmt.Sprintf("%s.instance.%s.yml", t.config.MicroserviceName, sanitizeName(fmt.Sprintf("%s_%d", address, t.config.MicroservicePort))))
+}
+
+// instanceServiceName returns the name of the Traefik service for the instance with the given address.
+func (t *TraefikRegistration) instanceServiceName(address string) string {
+	return sanitizeName(fmt.Sprintf("%s-%s-%d", t.config.MicroserviceName, address, t.config.MicroservicePort))
+}
+
+func (t *TraefikRegistration) weight() int {
+	if t.config.Weight > 0 {
+		return t.config.Weight
+	}
+	return DefaultTargetWeight
+}
+
+// traefikRule builds a Traefik router rule matching any of the hosts, any of the path prefixes and any of the methods.
+func traefikRule(hosts, paths, methods []string) string {
+	matchers := []string{}
+	if rule := traefikMatchAny("Host", hosts); rule != "" {
+		matchers = append(matchers, rule)
+	}
+	if rule := traefikMatchAny("PathPrefix", paths); rule != "" {
+		matchers = append(matchers, rule)
+	}
+	if rule := traefikMatchAny("Method", methods); rule != "" {
+		matchers = append(matchers, rule)
+	}
+	if len(matchers) == 0 {
+		return "PathPrefix(`/`)"
+	}
+	return strings.Join(matchers, " && ")
+}
+
+func traefikMatchAny(matcher string, values []string) string {
+	rules := []string{}
+	for _, value := range values {
+		rules = append(rules, fmt.Sprintf("%s(`%s`)", matcher, value))
+	}
+	if len(rules) > 1 {
+		return fmt.Sprintf("(%s)", strings.Join(rules, " || "))
+	}
+	return strings.Join(rules, "")
+}
+
+var unsafeNameChars = regexp.MustCompile("[^a-zA-Z0-9_-]+")
+
+// sanitizeName replaces the characters that are not safe in file and object names with '-'.
+func sanitizeName(name string) string {
+	return unsafeNameChars.ReplaceAllString(name, "-")
+}
+
+// writeFileAtomic writes the data into a hidden temporary file in the same directory, and then renames it
+// to the target file, so readers never see a partially written file.
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), fmt.Sprintf(".%s.*.tmp", filepath.Base(file)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package gateway
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the given file, creating it if needed, and returns the function that
+// releases the lock. The lock is held across processes, so the instances that share the config directory
+// (on the same host or on the same volume) take turns.
+func lockFile(file string) (func(), error) {
+	lock, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+		lock.Close()
+	}, nil
+}
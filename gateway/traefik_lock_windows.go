@@ -0,0 +1,14 @@
+package gateway
+
+import "os"
+
+// lockFile creates the given file and returns a no-op release function, as flock is not available on Windows.
+func lockFile(file string) (func(), error) {
+	lock, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		lock.Close()
+	}, nil
+}
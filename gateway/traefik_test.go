@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func newTraefikTestConfig() *MicroserviceConfig {
+	return &MicroserviceConfig{
+		MicroserviceName: "user-microservice",
+		MicroservicePort: 8080,
+		Hosts:            []string{"user.api.jormugandr.org", "localhost"},
+		Paths:            []string{"/users"},
+		StripPath:        true,
+		Weight:           10,
+	}
+}
+
+func readTraefikConfig(t *testing.T, file string) *traefikConfig {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &traefikConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestTraefikRegistration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traefik")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := NewTraefikRegistration(dir, newTraefikTestConfig())
+	first.InstanceAddress = "10.0.0.5"
+	first.EntryPoints = []string{"web"}
+
+	secondConfig := newTraefikTestConfig()
+	secondConfig.Weight = 30
+	second := NewTraefikRegistration(dir, secondConfig)
+	second.InstanceAddress = "10.0.0.6"
+	second.EntryPoints = []string{"web"}
+
+	if err := first.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SelfRegister(); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := readTraefikConfig(t, filepath.Join(dir, "user-microservice.instance.10-0-0-5_8080.yml"))
+	service := instance.HTTP.Services["user-microservice-10-0-0-5-8080"]
+	if service == nil || service.LoadBalancer.Servers[0].URL != "http://10.0.0.5:8080" {
+		t.Fatalf("Unexpected instance configuration: %+v", instance.HTTP.Services)
+	}
+
+	shared := readTraefikConfig(t, filepath.Join(dir, "user-microservice.yml"))
+	router := shared.HTTP.Routers["user-microservice"]
+	if router == nil {
+		t.Fatal("Expected a router for the microservice")
+	}
+	expectedRule := "(Host(`user.api.jormugandr.org`) || Host(`localhost`)) && PathPrefix(`/users`)"
+	if router.Rule != expectedRule {
+		t.Fatalf("Expected rule %s, got %s", expectedRule, router.Rule)
+	}
+	if len(router.Middlewares) != 1 || shared.HTTP.Middlewares[router.Middlewares[0]].StripPrefix.Prefixes[0] != "/users" {
+		t.Fatal("Expected a strip prefix middleware")
+	}
+	weighted := shared.HTTP.Services["user-microservice"].Weighted.Services
+	if len(weighted) != 2 ||
+		weighted[0].Name != "user-microservice-10-0-0-5-8080" || weighted[0].Weight != 10 ||
+		weighted[1].Name != "user-microservice-10-0-0-6-8080" || weighted[1].Weight != 30 {
+		t.Fatalf("Unexpected weighted services: %+v", weighted)
+	}
+
+	if err := first.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	shared = readTraefikConfig(t, filepath.Join(dir, "user-microservice.yml"))
+	if weighted = shared.HTTP.Services["user-microservice"].Weighted.Services; len(weighted) != 1 {
+		t.Fatalf("Expected one instance after unregister, got %+v", weighted)
+	}
+
+	if err := second.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != ".user-microservice.lock" {
+		t.Fatalf("Expected all files but the lock file to be removed, found %d", len(files))
+	}
+}
+
+func TestTraefikRegistrationConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traefik")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	errs := make(chan error)
+	for i := 1; i <= 10; i++ {
+		registration := NewTraefikRegistration(dir, newTraefikTestConfig())
+		registration.InstanceAddress = fmt.Sprintf("10.0.0.%d", i)
+		go func() {
+			errs <- registration.SelfRegister()
+		}()
+	}
+	for i := 1; i <= 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shared := readTraefikConfig(t, filepath.Join(dir, "user-microservice.yml"))
+	if weighted := shared.HTTP.Services["user-microservice"].Weighted.Services; len(weighted) != 10 {
+		t.Fatalf("Expected all instances in the shared file, got %+v", weighted)
+	}
+}
+
+func TestTraefikRule(t *testing.T) {
+	rule := traefikRule([]string{"example.org"}, []string{"/a", "/b"}, []string{"GET"})
+	expected := "Host(`example.org`) && (PathPrefix(`/a`) || PathPrefix(`/b`)) && Method(`GET`)"
+	if rule != expected {
+		t.Fatalf("Expected %s, got %s", expected, rule)
+	}
+	if rule := traefikRule(nil, nil, nil); rule != "PathPrefix(`/`)" {
+		t.Fatalf("Expected a catch-all rule, got %s", rule)
+	}
+}
@@ -6,4 +6,5 @@ require (
 	github.com/keitaroinc/goa v1.5.0
 	github.com/streadway/amqp v0.0.0-20190827072141-edfb9018d271
 	gopkg.in/h2non/gock.v1 v1.0.15
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -1,5 +1,93 @@
 package main
 
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Microkubes/microservice-tools/config"
+	"github.com/Microkubes/microservice-tools/gateway"
+)
+
+const usage = `Usage: microservice-tools <command> [options]
+
+Commands:
+  k8s    generate the Kubernetes Service, Ingress and HTTPRoute manifests from a service config file
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "k8s":
+		err = k8sCommand(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// k8sCommand writes the Kubernetes manifests for the service config file to the standard output, or to a file.
+func k8sCommand(args []string) error {
+	flags := flag.NewFlagSet("k8s", flag.ExitOnError)
+	options := &gateway.KubernetesOptions{}
+	flags.StringVar(&options.Namespace, "namespace", "", "namespace of the generated resources")
+	flags.StringVar(&options.IngressClass, "ingress-class", "kong", "class of the Ingress")
+	flags.BoolVar(&options.DisableIngress, "no-ingress", false, "do not generate the Ingress")
+	flags.StringVar(&options.GatewayName, "gateway", "", "name of the Gateway for the HTTPRoute (no HTTPRoute if empty)")
+	flags.StringVar(&options.GatewayNamespace, "gateway-namespace", "", "namespace of the Gateway")
+	output := flags.String("o", "", "output file (standard output if empty)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: microservice-tools k8s [options] <config.json>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	serviceConfig, err := loadMicroserviceConfig(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	manifests, err := gateway.KubernetesManifests(serviceConfig, options)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(manifests)
+		return err
+	}
+	return ioutil.WriteFile(*output, manifests, 0644)
+}
 
+// loadMicroserviceConfig loads the gateway configuration of the microservice from a service config file
+// (with the configuration under "service"), or from a file with only the gateway configuration.
+func loadMicroserviceConfig(file string) (*gateway.MicroserviceConfig, error) {
+	serviceConfig, err := config.LoadConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if serviceConfig.Service != nil {
+		return serviceConfig.Service, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	microserviceConfig := &gateway.MicroserviceConfig{}
+	if err := json.Unmarshal(data, microserviceConfig); err != nil {
+		return nil, err
+	}
+	return microserviceConfig, nil
 }
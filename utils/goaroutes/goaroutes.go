@@ -0,0 +1,213 @@
+package goaroutes
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Microkubes/microservice-tools/gateway"
+	"github.com/keitaroinc/goa"
+)
+
+// Endpoint is an HTTP method and path (with goa wildcards, for example "/users/:userID") served by the service.
+type Endpoint struct {
+	Method string
+	Path   string
+}
+
+// DefaultRegexPrefix marks the regex paths of the routes in the services Kong mode, as required by Kong 3.x.
+const DefaultRegexPrefix = "~"
+
+// unsafeNameChars matches the characters that are not allowed in the names of the Kong routes.
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._~-]+`)
+
+// Recorder is a goa.ServeMux that records the endpoints of the controllers mounted on the service,
+// and passes them on to the original mux of the service.
+type Recorder struct {
+	goa.ServeMux
+
+	// LegacyRegexPaths leaves out the DefaultRegexPrefix of the regex paths in the services Kong mode.
+	// Kong 2.x and older detect the regex paths by their content, so set it for these versions.
+	// The prefix is never used in the apis Kong mode (Kong 0.x).
+	LegacyRegexPaths bool
+
+	mutex     sync.Mutex
+	endpoints []Endpoint
+}
+
+// Record replaces the Mux of the service with a Recorder. It must be called before the controllers are mounted,
+// because the controllers mounted earlier are registered on the original mux and are never recorded:
+//
+//	service := goa.New("user")
+//	routes := goaroutes.Record(service)
+//	app.MountUserController(service, NewUserController(service))
+//	err := routes.Apply(serviceConfig.Service)
+func Record(service *goa.Service) *Recorder {
+	recorder := &Recorder{ServeMux: service.Mux}
+	service.Mux = recorder
+	return recorder
+}
+
+// Handle records the endpoint and sets the handler on the original mux.
+func (r *Recorder) Handle(method, path string, handle goa.MuxHandler) {
+	r.mutex.Lock()
+	r.endpoints = append(r.endpoints, Endpoint{Method: method, Path: path})
+	r.mutex.Unlock()
+	r.ServeMux.Handle(method, path, handle)
+}
+
+// Endpoints returns the recorded endpoints, ordered by path and method.
+func (r *Recorder) Endpoints() []Endpoint {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	endpoints := append([]Endpoint{}, r.endpoints...)
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+	return endpoints
+}
+
+// Paths returns the path prefixes on the gateway that cover all recorded endpoints. The path of an endpoint is cut
+// before the first wildcard segment (":param" or "*param"), and the prefixes covered by a shorter prefix are left out.
+// For example, the endpoints "/users", "/users/:userID" and "/users/:userID/roles" result in "/users".
+func (r *Recorder) Paths() []string {
+	prefixes := []string{}
+	for _, endpoint := range r.Endpoints() {
+		prefixes = append(prefixes, pathPrefix(endpoint.Path))
+	}
+	sort.Strings(prefixes)
+
+	paths := []string{}
+	for _, prefix := range prefixes {
+		covered := false
+		for _, path := range paths {
+			if path == "/" || prefix == path || strings.HasPrefix(prefix, path+"/") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			paths = append(paths, prefix)
+		}
+	}
+	return paths
+}
+
+// Methods returns the HTTP methods of the recorded endpoints, ordered by name.
+func (r *Recorder) Methods() []string {
+	seen := map[string]bool{}
+	methods := []string{}
+	for _, endpoint := range r.Endpoints() {
+		if !seen[endpoint.Method] {
+			seen[endpoint.Method] = true
+			methods = append(methods, endpoint.Method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Routes returns a gateway route for every recorded path, matching exactly that path and only the methods mounted
+// on it. The path is matched with a regex, in which a ":param" segment matches a single segment and a "*param"
+// segment matches the rest of the path. For example, "GET /users/:userID" results in the route "users-userID"
+// with the path "/users/[^/]+$" and the method GET. The regex paths are prefixed with the given regexPrefix
+// (see DefaultRegexPrefix). Returns nil if no endpoints were recorded.
+func (r *Recorder) Routes(regexPrefix string) []gateway.RouteConfig {
+	stripPath := false
+	var routes []gateway.RouteConfig
+	names := map[string]int{}
+	last := ""
+	for _, endpoint := range r.Endpoints() {
+		if len(routes) > 0 && endpoint.Path == last {
+			route := &routes[len(routes)-1]
+			if route.Methods[len(route.Methods)-1] != endpoint.Method {
+				route.Methods = append(route.Methods, endpoint.Method)
+			}
+			continue
+		}
+		last = endpoint.Path
+
+		name := routeName(endpoint.Path)
+		names[name]++
+		if names[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, names[name])
+		}
+		routes = append(routes, gateway.RouteConfig{
+			Name:    name,
+			Paths:   []string{regexPrefix + pathRegex(endpoint.Path)},
+			Methods: []string{endpoint.Method},
+			// The whole path is matched, so there is no prefix to strip.
+			StripPath: &stripPath,
+		})
+	}
+	return routes
+}
+
+// Apply sets the Routes of the microservice configuration to the recorded endpoints, so the gateway exposes exactly
+// the endpoints served by the service. The Paths and the Methods are set as well, for the gateways that register
+// the path prefixes instead of the routes (Traefik, Consul, Envoy and Kubernetes). The regex paths of the routes
+// are prefixed with DefaultRegexPrefix only in the services Kong mode, unless LegacyRegexPaths is set.
+// Returns an error and leaves the configuration unchanged if no endpoints were recorded, which usually means
+// that the controllers were mounted before Record was called.
+func (r *Recorder) Apply(config *gateway.MicroserviceConfig) error {
+	if len(r.Endpoints()) == 0 {
+		return fmt.Errorf("no endpoints recorded for %s, call Record before mounting the controllers", config.MicroserviceName)
+	}
+	regexPrefix := ""
+	if config.KongMode == gateway.KongModeServices && !r.LegacyRegexPaths {
+		regexPrefix = DefaultRegexPrefix
+	}
+	config.Routes = r.Routes(regexPrefix)
+	config.Paths = r.Paths()
+	config.Methods = r.Methods()
+	return nil
+}
+
+// pathRegex converts the goa path into a regex that matches exactly the paths served by the endpoint.
+func pathRegex(path string) string {
+	segments := []string{}
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			segments = append(segments, "[^/]+")
+		case strings.HasPrefix(segment, "*"):
+			segments = append(segments, ".*")
+		default:
+			segments = append(segments, regexp.QuoteMeta(segment))
+		}
+	}
+	return "/" + strings.Join(segments, "/") + "$"
+}
+
+// routeName returns the name of the route for the goa path, built from the path segments and the names of
+// the wildcards. The route for "/" is named "root".
+func routeName(path string) string {
+	segments := []string{}
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		segment = unsafeNameChars.ReplaceAllString(strings.TrimLeft(segment, ":*"), "-")
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return "root"
+	}
+	return strings.Join(segments, "-")
+}
+
+// pathPrefix returns the part of the goa path before the first wildcard segment.
+func pathPrefix(path string) string {
+	segments := []string{}
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			break
+		}
+		segments = append(segments, segment)
+	}
+	return "/" + strings.Join(segments, "/")
+}
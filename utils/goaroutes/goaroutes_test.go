@@ -0,0 +1,144 @@
+package goaroutes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Microkubes/microservice-tools/gateway"
+	"github.com/keitaroinc/goa"
+)
+
+func mountUserController(service *goa.Service, served *[]string) {
+	handler := func(rw http.ResponseWriter, req *http.Request, params url.Values) {
+		*served = append(*served, req.Method+" "+req.URL.Path)
+	}
+	service.Mux.Handle("GET", "/users", handler)
+	service.Mux.Handle("POST", "/users", handler)
+	service.Mux.Handle("GET", "/users/:userID", handler)
+	service.Mux.Handle("DELETE", "/users/:userID", handler)
+	service.Mux.Handle("GET", "/users/:userID/roles", handler)
+	service.Mux.Handle("GET", "/files/*path", handler)
+}
+
+func TestRecordPassesRequestsToService(t *testing.T) {
+	service := goa.New("user")
+	routes := Record(service)
+	served := []string{}
+	mountUserController(service, &served)
+
+	service.Mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42/roles", nil))
+	if len(served) != 1 || served[0] != "GET /users/42/roles" {
+		t.Fatalf("Expected the request to be served by the controller, got %v", served)
+	}
+	if endpoints := routes.Endpoints(); len(endpoints) != 6 || endpoints[0] != (Endpoint{Method: "GET", Path: "/files/*path"}) {
+		t.Fatalf("Unexpected endpoints: %v", endpoints)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	service := goa.New("user")
+	routes := Record(service)
+	mountUserController(service, &[]string{})
+
+	expected := map[string]gateway.RouteConfig{
+		"files-path":         {Paths: []string{"~/files/.*$"}, Methods: []string{"GET"}},
+		"users":              {Paths: []string{"~/users$"}, Methods: []string{"GET", "POST"}},
+		"users-userID":       {Paths: []string{"~/users/[^/]+$"}, Methods: []string{"DELETE", "GET"}},
+		"users-userID-roles": {Paths: []string{"~/users/[^/]+/roles$"}, Methods: []string{"GET"}},
+	}
+	generated := routes.Routes(DefaultRegexPrefix)
+	if len(generated) != len(expected) {
+		t.Fatalf("Expected a route for every path, got %v", generated)
+	}
+	for _, route := range generated {
+		want, ok := expected[route.Name]
+		if !ok {
+			t.Fatalf("Unexpected route %s", route.Name)
+		}
+		if !reflect.DeepEqual(route.Paths, want.Paths) || !reflect.DeepEqual(route.Methods, want.Methods) {
+			t.Errorf("Route %s: expected %v %v, got %v %v", route.Name, want.Paths, want.Methods, route.Paths, route.Methods)
+		}
+		if route.StripPath == nil || *route.StripPath {
+			t.Errorf("Route %s: expected the path not to be stripped", route.Name)
+		}
+	}
+
+	// The routes match exactly the paths served by the service.
+	userPath := regexp.MustCompile("^" + strings.TrimPrefix(expected["users-userID"].Paths[0], "~"))
+	for path, match := range map[string]bool{"/users/42": true, "/users/42/roles": false, "/users/": false, "/users/42/other": false} {
+		if userPath.MatchString(path) != match {
+			t.Errorf("Expected the match of %s to be %v", path, match)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	service := goa.New("user")
+	routes := Record(service)
+	mountUserController(service, &[]string{})
+
+	config := &gateway.MicroserviceConfig{KongMode: gateway.KongModeServices}
+	if err := routes.Apply(config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Routes) != 4 || config.Routes[0].Paths[0] != "~/files/.*$" {
+		t.Fatalf("Expected the routes with the regex prefix, got %v", config.Routes)
+	}
+	if !reflect.DeepEqual(config.Paths, []string{"/files", "/users"}) {
+		t.Fatalf("Unexpected path prefixes: %v", config.Paths)
+	}
+	if !reflect.DeepEqual(config.Methods, []string{"DELETE", "GET", "POST"}) {
+		t.Fatalf("Unexpected methods: %v", config.Methods)
+	}
+	if name := routeName("/"); name != "root" {
+		t.Fatalf("Unexpected name of the root route: %s", name)
+	}
+}
+
+func TestApplyWithoutRegexPrefix(t *testing.T) {
+	service := goa.New("user")
+	routes := Record(service)
+	mountUserController(service, &[]string{})
+
+	// The apis Kong mode (Kong 0.x) does not support the regex prefix.
+	config := &gateway.MicroserviceConfig{KongMode: gateway.KongModeAPIs}
+	if err := routes.Apply(config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Routes) != 4 || config.Routes[0].Paths[0] != "/files/.*$" {
+		t.Fatalf("Expected the routes without the regex prefix in the apis mode, got %v", config.Routes)
+	}
+
+	// Kong 2.x and older in the services mode.
+	routes.LegacyRegexPaths = true
+	config = &gateway.MicroserviceConfig{KongMode: gateway.KongModeServices}
+	if err := routes.Apply(config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Routes) != 4 || config.Routes[0].Paths[0] != "/files/.*$" {
+		t.Fatalf("Expected the routes without the regex prefix for the legacy regex paths, got %v", config.Routes)
+	}
+}
+
+func TestApplyWithoutEndpoints(t *testing.T) {
+	service := goa.New("user")
+	// The controllers mounted before Record are not recorded.
+	mountUserController(service, &[]string{})
+	routes := Record(service)
+
+	if generated := routes.Routes(DefaultRegexPrefix); generated != nil {
+		t.Fatalf("Expected no routes, got %v", generated)
+	}
+	config := &gateway.MicroserviceConfig{KongMode: gateway.KongModeServices, Paths: []string{"/users"}}
+	if err := routes.Apply(config); err == nil {
+		t.Fatal("Expected an error when no endpoints were recorded")
+	}
+	if config.Routes != nil || !reflect.DeepEqual(config.Paths, []string{"/users"}) {
+		t.Fatalf("Expected the configuration to be left unchanged, got %v %v", config.Routes, config.Paths)
+	}
+}